@@ -0,0 +1,130 @@
+// Command dissolve is a tool for browsing, resolving and serving DNS-SD
+// services using conventional (unicast) DNS.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if err := run(ctx, os.Args[1:], os.Stdout); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "dissolve:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// command is a dissolve sub-command.
+type command struct {
+	Name    string
+	Usage   string
+	Summary string
+	Run     func(ctx context.Context, args []string, w io.Writer) error
+}
+
+// commands returns the set of available sub-commands.
+func commands() []command {
+	return []command{
+		{
+			Name:    "types",
+			Usage:   "[flags] <domain>",
+			Summary: "list the service types advertised within a domain",
+			Run:     runTypes,
+		},
+		{
+			Name:    "browse",
+			Usage:   "[flags] <service> <domain>",
+			Summary: "list the instances of a service type advertised within a domain",
+			Run:     runBrowse,
+		},
+		{
+			Name:    "resolve",
+			Usage:   "[flags] <instance> <service> <domain>",
+			Summary: "show the details of a single service instance",
+			Run:     runResolve,
+		},
+		{
+			Name:    "serve",
+			Usage:   "[flags] <file>",
+			Summary: "serve the instances described in a JSON or YAML file",
+			Run:     runServe,
+		},
+	}
+}
+
+// run runs the sub-command named by the first element of args.
+func run(ctx context.Context, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return flag.ErrHelp
+	}
+
+	for _, c := range commands() {
+		if c.Name == args[0] {
+			return c.Run(ctx, args[1:], w)
+		}
+	}
+
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(os.Stderr)
+		return flag.ErrHelp
+	}
+
+	return fmt.Errorf("unrecognized command %q", args[0])
+}
+
+// usage writes the top-level usage information to w.
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: dissolve <command> [flags] [args]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+
+	for _, c := range commands() {
+		fmt.Fprintf(w, "  %-8s %s\n", c.Name, c.Summary)
+	}
+}
+
+// newFlagSet returns a flag set for the given sub-command.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	fs.Usage = func() {
+		for _, c := range commands() {
+			if c.Name == name {
+				fmt.Fprintf(fs.Output(), "usage: dissolve %s %s\n", c.Name, c.Usage)
+				fmt.Fprintln(fs.Output())
+				fmt.Fprintf(fs.Output(), "%s\n", c.Summary)
+			}
+		}
+
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// parseArgs parses args using fs and verifies that the number of positional
+// arguments is n.
+func parseArgs(fs *flag.FlagSet, args []string, n int) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != n {
+		fs.Usage()
+		return fmt.Errorf("expected %d argument(s), got %d", n, fs.NArg())
+	}
+
+	return nil
+}
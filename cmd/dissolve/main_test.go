@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("func run()", func() {
+	var ctx context.Context
+
+	BeforeEach(func() {
+		c, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		DeferCleanup(cancel)
+		ctx = c
+	})
+
+	DescribeTable(
+		"it returns an error if the arguments are invalid",
+		func(args []string, expect string) {
+			err := run(ctx, args, &bytes.Buffer{})
+			Expect(err).To(MatchError(expect))
+		},
+		Entry("no command", []string{}, flag.ErrHelp.Error()),
+		Entry("help command", []string{"help"}, flag.ErrHelp.Error()),
+		Entry("help flag", []string{"-h"}, flag.ErrHelp.Error()),
+		Entry("unrecognized command", []string{"unknown"}, `unrecognized command "unknown"`),
+		Entry("unrecognized flag", []string{"types", "-unknown", "example.org"}, "flag provided but not defined: -unknown"),
+		Entry("invalid flag value", []string{"types", "-timeout", "soon", "example.org"}, `invalid value "soon" for flag -timeout: parse error`),
+		Entry("types without a domain", []string{"types"}, "expected 1 argument(s), got 0"),
+		Entry("browse without a domain", []string{"browse", "_http._tcp"}, "expected 2 argument(s), got 1"),
+		Entry("resolve with too many arguments", []string{"resolve", "a", "b", "c", "d"}, "expected 3 argument(s), got 4"),
+		Entry("serve without a file", []string{"serve"}, "expected 1 argument(s), got 0"),
+		Entry("serve with a missing file", []string{"serve", "missing.yaml"}, "open missing.yaml: no such file or directory"),
+	)
+
+	Describe("the serve command", func() {
+		var file string
+
+		BeforeEach(func() {
+			file = filepath.Join(GinkgoT().TempDir(), "dissolve.yaml")
+
+			err := os.WriteFile(
+				file,
+				[]byte(`
+instances:
+  - name: Instance A
+    service: _http._tcp
+    domain: example.org
+    host: a.example.com
+    port: 12345
+    addresses:
+      - 192.168.20.1
+listeners:
+  - network: udp
+    address: 127.0.0.1:65355
+`),
+				0o600,
+			)
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("serves the instances in the file until the context is canceled", func() {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			w := &bytes.Buffer{}
+			result := make(chan error, 1)
+
+			go func() {
+				result <- run(ctx, []string{"serve", file}, w)
+			}()
+
+			Eventually(func() (string, error) {
+				out := &bytes.Buffer{}
+				err := run(
+					ctx,
+					[]string{"browse", "-server", "127.0.0.1:65355", "-timeout", "100ms", "_http._tcp", "example.org"},
+					out,
+				)
+				return out.String(), err
+			}).Should(Equal("Instance A\n"))
+
+			cancel()
+			Expect(<-result).ShouldNot(HaveOccurred())
+
+			Expect(w.String()).To(Equal(
+				"advertising \"Instance A\" (_http._tcp.example.org)\n" +
+					"listening on 127.0.0.1:65355/udp\n",
+			))
+		})
+	})
+})
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dogmatiq/dissolve/dnssd"
+	"github.com/miekg/dns"
+)
+
+// resolverFlags are the flags common to all commands that perform queries.
+type resolverFlags struct {
+	Server  string
+	Timeout time.Duration
+}
+
+// register adds the resolver flags to fs.
+func (f *resolverFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.Server, "server", "", "query this DNS server (host or host:port) instead of those in the system configuration")
+	fs.DurationVar(&f.Timeout, "timeout", 5*time.Second, "the maximum time to wait for a result")
+}
+
+// resolver returns the resolver described by the flags.
+func (f *resolverFlags) resolver() (*dnssd.UnicastResolver, error) {
+	if f.Server == "" {
+		return dnssd.NewUnicastResolver()
+	}
+
+	host, port, err := net.SplitHostPort(f.Server)
+	if err != nil {
+		host, port = f.Server, "53"
+	}
+
+	return &dnssd.UnicastResolver{
+		Config: &dns.ClientConfig{
+			Servers: []string{host},
+			Port:    port,
+		},
+	}, nil
+}
+
+// runTypes runs the "types" command.
+func runTypes(ctx context.Context, args []string, w io.Writer) error {
+	var rf resolverFlags
+
+	fs := newFlagSet("types")
+	rf.register(fs)
+
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	r, err := rf.resolver()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, rf.Timeout)
+	defer cancel()
+
+	serviceTypes, err := r.EnumerateServiceTypes(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(serviceTypes)
+
+	for _, t := range serviceTypes {
+		fmt.Fprintln(w, t)
+	}
+
+	return nil
+}
+
+// runBrowse runs the "browse" command.
+func runBrowse(ctx context.Context, args []string, w io.Writer) error {
+	var (
+		rf      resolverFlags
+		subType string
+	)
+
+	fs := newFlagSet("browse")
+	rf.register(fs)
+	fs.StringVar(&subType, "subtype", "", "only list instances that provide this service sub-type, such as \"_printer\"")
+
+	if err := parseArgs(fs, args, 2); err != nil {
+		return err
+	}
+
+	r, err := rf.resolver()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, rf.Timeout)
+	defer cancel()
+
+	var instances []string
+
+	if subType == "" {
+		instances, err = r.EnumerateInstances(ctx, fs.Arg(0), fs.Arg(1))
+	} else {
+		instances, err = r.EnumerateInstancesBySubType(ctx, subType, fs.Arg(0), fs.Arg(1))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(instances)
+
+	for _, n := range instances {
+		fmt.Fprintln(w, n)
+	}
+
+	return nil
+}
+
+// runResolve runs the "resolve" command.
+func runResolve(ctx context.Context, args []string, w io.Writer) error {
+	var rf resolverFlags
+
+	fs := newFlagSet("resolve")
+	rf.register(fs)
+
+	if err := parseArgs(fs, args, 3); err != nil {
+		return err
+	}
+
+	r, err := rf.resolver()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, rf.Timeout)
+	defer cancel()
+
+	i, ok, err := r.LookupInstance(ctx, fs.Arg(0), fs.Arg(1), fs.Arg(2))
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return errors.New("instance not found")
+	}
+
+	writeInstance(w, i)
+
+	return nil
+}
+
+// writeInstance writes a human-readable description of i to w.
+func writeInstance(w io.Writer, i dnssd.ServiceInstance) {
+	fmt.Fprintf(w, "name:     %s\n", i.Name)
+	fmt.Fprintf(w, "service:  %s\n", i.ServiceType)
+	fmt.Fprintf(w, "domain:   %s\n", i.Domain)
+	fmt.Fprintf(w, "target:   %s\n", net.JoinHostPort(i.TargetHost, strconv.Itoa(int(i.TargetPort))))
+	fmt.Fprintf(w, "priority: %d\n", i.Priority)
+	fmt.Fprintf(w, "weight:   %d\n", i.Weight)
+	fmt.Fprintf(w, "ttl:      %s\n", i.TTL)
+
+	for n, attrs := range i.Attributes {
+		fmt.Fprintf(w, "txt[%d]:\n", n)
+
+		for _, pair := range attrs.ToTXT() {
+			fmt.Fprintf(w, "  %s\n", pair)
+		}
+	}
+}
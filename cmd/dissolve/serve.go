@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/dogmatiq/dissolve/config"
+)
+
+// runServe runs the "serve" command.
+func runServe(ctx context.Context, args []string, w io.Writer) error {
+	var network, addr string
+
+	fs := newFlagSet("serve")
+	fs.StringVar(&network, "net", "udp", "the network to listen on, \"udp\" or \"tcp\", if the file contains no listeners")
+	fs.StringVar(&addr, "addr", ":53", "the address to listen on, if the file contains no listeners")
+
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if len(cfg.Listeners) == 0 {
+		cfg.Listeners = []config.Listener{
+			{Network: network, Address: addr},
+		}
+	}
+
+	server, err := cfg.NewServer()
+	if err != nil {
+		return err
+	}
+
+	for _, i := range cfg.Instances {
+		fmt.Fprintf(w, "advertising %q (%s.%s)\n", i.Name, i.Service, i.Domain)
+	}
+
+	for _, l := range cfg.Listeners {
+		fmt.Fprintf(w, "listening on %s/%s\n", l.Address, l.Network)
+	}
+
+	err = cfg.Serve(ctx, server)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+
+	return err
+}
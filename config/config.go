@@ -0,0 +1,267 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dogmatiq/dissolve/dnssd"
+	"golang.org/x/sync/errgroup"
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes a set of DNS-SD service instances and the listeners on
+// which they are served.
+type Config struct {
+	// Instances is the set of service instances to advertise.
+	Instances []Instance `json:"instances" yaml:"instances"`
+
+	// Listeners is the set of network addresses on which the instances are
+	// served.
+	Listeners []Listener `json:"listeners" yaml:"listeners"`
+}
+
+// Instance describes a single DNS-SD service instance.
+type Instance struct {
+	// Name is the service instance's unqualified name, for example
+	// "Boardroom Printer".
+	Name string `json:"name" yaml:"name"`
+
+	// Service is the type of service that the instance provides, for example
+	// "_http._tcp".
+	Service string `json:"service" yaml:"service"`
+
+	// Domain is the domain under which the instance is advertised.
+	Domain string `json:"domain" yaml:"domain"`
+
+	// Host is the fully-qualified hostname of the machine that hosts the
+	// service.
+	Host string `json:"host" yaml:"host"`
+
+	// Port is the TCP or UDP port on which the service is provided.
+	Port uint16 `json:"port" yaml:"port"`
+
+	// Priority is the priority of the instance, as per RFC 2782.
+	Priority uint16 `json:"priority" yaml:"priority"`
+
+	// Weight is the weight of the instance, as per RFC 2782.
+	Weight uint16 `json:"weight" yaml:"weight"`
+
+	// TTL is the time-to-live of the instance's DNS records, expressed as a
+	// duration string such as "2m". If it is empty, dnssd.DefaultTTL is used.
+	TTL string `json:"ttl" yaml:"ttl"`
+
+	// Attributes contains the instance's attributes. Each element corresponds
+	// to a single TXT record.
+	//
+	// A nil value (null in JSON, ~ or an empty value in YAML) represents a
+	// flag, any other value is a key/value pair.
+	Attributes []map[string]*string `json:"attributes" yaml:"attributes"`
+
+	// SubTypes is the set of service sub-types that the instance provides.
+	SubTypes []string `json:"subtypes" yaml:"subtypes"`
+
+	// Addresses is a set of IP addresses that are served as A and AAAA records
+	// for Host.
+	Addresses []string `json:"addresses" yaml:"addresses"`
+}
+
+// Listener describes a network address on which a server listens.
+type Listener struct {
+	// Network is the network type, either "udp" or "tcp".
+	Network string `json:"network" yaml:"network"`
+
+	// Address is the address to listen on, for example ":53".
+	Address string `json:"address" yaml:"address"`
+}
+
+// Load loads the configuration from the file at the given path.
+//
+// Files with a ".yaml" or ".yml" extension are parsed as YAML, all other files
+// are parsed as JSON.
+func Load(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var cfg Config
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		cfg, err = ParseYAML(data)
+	default:
+		cfg, err = ParseJSON(data)
+	}
+
+	if err != nil {
+		return Config{}, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// ParseJSON parses a JSON configuration document.
+func ParseJSON(data []byte) (Config, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	var cfg Config
+	if err := dec.Decode(&cfg); err != nil {
+		return Config{}, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	return cfg, nil
+}
+
+// ParseYAML parses a YAML configuration document.
+func ParseYAML(data []byte) (Config, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	var cfg Config
+	if err := dec.Decode(&cfg); err != nil {
+		return Config{}, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	return cfg, nil
+}
+
+// Validate returns an error if the configuration is invalid.
+func (c Config) Validate() error {
+	for _, inst := range c.Instances {
+		if _, _, err := inst.Build(); err != nil {
+			return err
+		}
+	}
+
+	for _, l := range c.Listeners {
+		if l.Network != "udp" && l.Network != "tcp" {
+			return fmt.Errorf("listener %q: unsupported network %q, expected \"udp\" or \"tcp\"", l.Address, l.Network)
+		}
+	}
+
+	return nil
+}
+
+// Build returns the service instance and advertise options described by i.
+func (i Instance) Build() (dnssd.ServiceInstance, []dnssd.AdvertiseOption, error) {
+	inst := dnssd.ServiceInstance{
+		ServiceInstanceName: dnssd.ServiceInstanceName{
+			Name:        i.Name,
+			ServiceType: i.Service,
+			Domain:      i.Domain,
+		},
+		TargetHost: i.Host,
+		TargetPort: i.Port,
+		Priority:   i.Priority,
+		Weight:     i.Weight,
+	}
+
+	if i.Name == "" || i.Service == "" || i.Domain == "" {
+		return dnssd.ServiceInstance{}, nil, fmt.Errorf("instance %q: name, service and domain must not be empty", i.Name)
+	}
+
+	if i.TTL != "" {
+		ttl, err := time.ParseDuration(i.TTL)
+		if err != nil {
+			return dnssd.ServiceInstance{}, nil, fmt.Errorf("instance %q: invalid TTL: %w", i.Name, err)
+		}
+		inst.TTL = ttl
+	}
+
+	for _, pairs := range i.Attributes {
+		attrs := dnssd.NewAttributes()
+
+		for k, v := range pairs {
+			pair := k
+			if v != nil {
+				pair += "=" + *v
+			}
+
+			var err error
+			attrs, _, err = attrs.WithTXT(pair)
+			if err != nil {
+				return dnssd.ServiceInstance{}, nil, fmt.Errorf("instance %q: invalid attribute: %w", i.Name, err)
+			}
+		}
+
+		inst.Attributes = append(inst.Attributes, attrs)
+	}
+
+	var options []dnssd.AdvertiseOption
+
+	for _, t := range i.SubTypes {
+		options = append(options, dnssd.WithServiceSubType(t))
+	}
+
+	for _, a := range i.Addresses {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			return dnssd.ServiceInstance{}, nil, fmt.Errorf("instance %q: invalid IP address %q", i.Name, a)
+		}
+		options = append(options, dnssd.WithIPAddress(ip))
+	}
+
+	return inst, options, nil
+}
+
+// NewServer returns a new server that advertises the configured instances.
+func (c Config) NewServer() (*dnssd.UnicastServer, error) {
+	server := &dnssd.UnicastServer{}
+
+	if err := c.AdvertiseTo(server); err != nil {
+		return nil, err
+	}
+
+	return server, nil
+}
+
+// AdvertiseTo advertises the configured instances on an existing server.
+func (c Config) AdvertiseTo(server *dnssd.UnicastServer) error {
+	for _, i := range c.Instances {
+		inst, options, err := i.Build()
+		if err != nil {
+			return err
+		}
+
+		if err := server.Advertise(inst, options...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Serve runs server on each of the configured listeners until ctx is canceled
+// or an error occurs.
+func (c Config) Serve(ctx context.Context, server *dnssd.UnicastServer) error {
+	if len(c.Listeners) == 0 {
+		return errors.New("no listeners are configured")
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+
+	for _, l := range c.Listeners {
+		g.Go(func() error {
+			return server.Run(ctx, l.Network, l.Address)
+		})
+	}
+
+	return g.Wait()
+}
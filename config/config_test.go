@@ -0,0 +1,186 @@
+package config_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/dogmatiq/dissolve/config"
+	"github.com/dogmatiq/dissolve/dnssd"
+	"github.com/miekg/dns"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("type Config", func() {
+	const yamlConfig = `
+instances:
+  - name: Instance A
+    service: _http._tcp
+    domain: example.org
+    host: a.example.com
+    port: 12345
+    priority: 10
+    weight: 20
+    ttl: 1m
+    attributes:
+      - path: /index.html
+        secure:
+    subtypes:
+      - _printer
+    addresses:
+      - 192.168.20.1
+listeners:
+  - network: udp
+    address: 127.0.0.1:65354
+`
+
+	const jsonConfig = `{
+	"instances": [
+		{
+			"name": "Instance A",
+			"service": "_http._tcp",
+			"domain": "example.org",
+			"host": "a.example.com",
+			"port": 12345,
+			"priority": 10,
+			"weight": 20,
+			"ttl": "1m",
+			"attributes": [{ "path": "/index.html", "secure": null }],
+			"subtypes": ["_printer"],
+			"addresses": ["192.168.20.1"]
+		}
+	],
+	"listeners": [
+		{ "network": "udp", "address": "127.0.0.1:65354" }
+	]
+}`
+
+	expectedInstance := func() dnssd.ServiceInstance {
+		return dnssd.ServiceInstance{
+			ServiceInstanceName: dnssd.ServiceInstanceName{
+				Name:        "Instance A",
+				ServiceType: "_http._tcp",
+				Domain:      "example.org",
+			},
+			TargetHost: "a.example.com",
+			TargetPort: 12345,
+			Priority:   10,
+			Weight:     20,
+			TTL:        1 * time.Minute,
+			Attributes: dnssd.AttributeCollection{
+				dnssd.NewAttributes().
+					WithPair("path", []byte("/index.html")).
+					WithFlag("secure"),
+			},
+		}
+	}
+
+	Describe("func Load()", func() {
+		DescribeTable(
+			"it loads the configuration based on the file extension",
+			func(filename, content string) {
+				path := filepath.Join(GinkgoT().TempDir(), filename)
+				err := os.WriteFile(path, []byte(content), 0600)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				cfg, err := Load(path)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(cfg.Instances).To(HaveLen(1))
+				Expect(cfg.Listeners).To(Equal([]Listener{
+					{Network: "udp", Address: "127.0.0.1:65354"},
+				}))
+
+				inst, options, err := cfg.Instances[0].Build()
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(inst).To(Equal(expectedInstance()))
+				Expect(options).To(HaveLen(2))
+			},
+			Entry("YAML", "config.yaml", yamlConfig),
+			Entry("YAML (.yml)", "config.yml", yamlConfig),
+			Entry("JSON", "config.json", jsonConfig),
+		)
+
+		It("returns an error if the file does not exist", func() {
+			_, err := Load(filepath.Join(GinkgoT().TempDir(), "missing.json"))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	DescribeTable(
+		"it returns an error if the configuration is invalid",
+		func(content, expect string) {
+			_, err := ParseYAML([]byte(content))
+			Expect(err).To(MatchError(ContainSubstring(expect)))
+		},
+		Entry(
+			"unknown field",
+			`instances: [{ name: A, service: _http._tcp, domain: example.org, colour: blue }]`,
+			"field colour not found",
+		),
+		Entry(
+			"missing service type",
+			`instances: [{ name: A, domain: example.org }]`,
+			"name, service and domain must not be empty",
+		),
+		Entry(
+			"invalid TTL",
+			`instances: [{ name: A, service: _http._tcp, domain: example.org, ttl: forever }]`,
+			"invalid TTL",
+		),
+		Entry(
+			"invalid attribute",
+			`instances: [{ name: A, service: _http._tcp, domain: example.org, attributes: [{ "\t": x }] }]`,
+			"invalid attribute",
+		),
+		Entry(
+			"invalid IP address",
+			`instances: [{ name: A, service: _http._tcp, domain: example.org, addresses: [localhost] }]`,
+			"invalid IP address",
+		),
+		Entry(
+			"unsupported network",
+			`listeners: [{ network: sctp, address: ":53" }]`,
+			"unsupported network",
+		),
+	)
+
+	Describe("func Serve()", func() {
+		It("serves the configured instances on the configured listeners", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+
+			cfg, err := ParseJSON([]byte(jsonConfig))
+			Expect(err).ShouldNot(HaveOccurred())
+
+			server, err := cfg.NewServer()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			result := make(chan error, 1)
+			go func() {
+				result <- cfg.Serve(ctx, server)
+			}()
+
+			// Fudge-factor to allow the server time to start.
+			time.Sleep(100 * time.Millisecond)
+
+			req := &dns.Msg{}
+			req.SetQuestion("a.example.com.", dns.TypeA)
+
+			res, _, err := (&dns.Client{}).ExchangeContext(ctx, req, "127.0.0.1:65354")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Answer).To(HaveLen(1))
+			Expect(res.Answer[0].(*dns.A).A.Equal(net.IPv4(192, 168, 20, 1))).To(BeTrue())
+
+			cancel()
+			Expect(<-result).To(Equal(context.Canceled))
+		})
+
+		It("returns an error if there are no listeners", func() {
+			err := Config{}.Serve(context.Background(), &dnssd.UnicastServer{})
+			Expect(err).To(MatchError("no listeners are configured"))
+		})
+	})
+})
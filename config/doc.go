@@ -0,0 +1,3 @@
+// Package config loads declarative descriptions of DNS-SD service instances
+// and the servers that advertise them, from JSON or YAML documents.
+package config
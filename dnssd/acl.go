@@ -0,0 +1,92 @@
+package dnssd
+
+import (
+	"log/slog"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// authorize returns true if the client at the given address is permitted to
+// make the given request.
+func (s *UnicastServer) authorize(client net.Addr, req *dns.Msg) bool {
+	if len(s.AllowedNetworks) != 0 || len(s.DeniedNetworks) != 0 {
+		ip := clientIP(client)
+		if ip == nil {
+			return false
+		}
+
+		if containsIP(s.DeniedNetworks, ip) {
+			return false
+		}
+
+		if len(s.AllowedNetworks) != 0 && !containsIP(s.AllowedNetworks, ip) {
+			return false
+		}
+	}
+
+	if s.Authorize != nil {
+		return s.Authorize(client, req)
+	}
+
+	return true
+}
+
+// refuse sends a REFUSED response to a request from an unauthorized client.
+func (s *UnicastServer) refuse(w dns.ResponseWriter, req *dns.Msg) {
+	res := &dns.Msg{}
+	res.SetRcode(req, dns.RcodeRefused)
+
+	attrs := []slog.Attr{
+		slog.String("client", w.RemoteAddr().String()),
+	}
+
+	if len(req.Question) == 1 {
+		attrs = append(attrs, questionAttrs(req.Question[0])...)
+	}
+
+	if err := w.WriteMsg(res); err != nil {
+		logAttrs(
+			s.Logger,
+			slog.LevelWarn,
+			"unable to write DNS response",
+			append(attrs, slog.Any("error", err))...,
+		)
+		return
+	}
+
+	s.observeQuery(req, res)
+	logAttrs(s.Logger, slog.LevelDebug, "refused DNS request from unauthorized client", attrs...)
+}
+
+// clientIP returns the IP address of the client at the given address, or nil
+// if it can not be determined.
+func clientIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.IPAddr:
+		return addr.IP
+	case nil:
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+
+	return net.ParseIP(host)
+}
+
+// containsIP returns true if ip is within any of the given networks.
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package dnssd
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// additionalRecords is a short-lived store of records that were received in
+// the additional section of a response.
+//
+// Well-behaved DNS-SD servers include the SRV and TXT records of each instance
+// in the additional section of the response to a PTR query, such that clients
+// need not query for them separately.
+//
+// Each set of records is used at most once, and only before its TTL expires,
+// such that it avoids only the queries that immediately follow the response in
+// which the records were received. Records that are never used are evicted
+// once they expire, or earlier if the store is full.
+//
+// See https://www.rfc-editor.org/rfc/rfc6763#section-12.1.
+type additionalRecords struct {
+	m       sync.Mutex
+	entries map[additionalRecordsKey]additionalRecordsEntry
+}
+
+// maxAdditionalRecordsEntries is the maximum number of entries in
+// additionalRecords.
+//
+// If the store is full after expired entries are evicted, the entry that
+// expires soonest is evicted to make room for each new one.
+const maxAdditionalRecordsEntries = 1024
+
+// additionalRecordsKey is the key of an entry in additionalRecords.
+type additionalRecordsKey struct {
+	name         string
+	questionType uint16
+}
+
+// additionalRecordsEntry is an entry in additionalRecords.
+type additionalRecordsEntry struct {
+	records   []dns.RR
+	expiresAt time.Time
+}
+
+// Add stores the records in res.Extra that are owned by one of the given
+// names.
+//
+// Records owned by any other name are ignored, as the server that sent them
+// is not necessarily authoritative for that name.
+func (a *additionalRecords) Add(owners []string, res *dns.Msg) {
+	if len(res.Extra) == 0 {
+		return
+	}
+
+	allowed := make(map[string]struct{}, len(owners))
+	for _, n := range owners {
+		allowed[additionalRecordsName(n)] = struct{}{}
+	}
+
+	now := time.Now()
+	entries := map[additionalRecordsKey]additionalRecordsEntry{}
+
+	for _, rr := range res.Extra {
+		h := rr.Header()
+		if h.Rrtype == dns.TypeOPT {
+			continue
+		}
+
+		name := additionalRecordsName(h.Name)
+		if _, ok := allowed[name]; !ok {
+			continue
+		}
+
+		k := additionalRecordsKey{name, h.Rrtype}
+		e := entries[k]
+		e.records = append(e.records, rr)
+
+		expiresAt := now.Add(time.Duration(h.Ttl) * time.Second)
+		if e.expiresAt.IsZero() || expiresAt.Before(e.expiresAt) {
+			e.expiresAt = expiresAt
+		}
+
+		entries[k] = e
+	}
+
+	if len(entries) == 0 {
+		return
+	}
+
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	if a.entries == nil {
+		a.entries = map[additionalRecordsKey]additionalRecordsEntry{}
+	}
+
+	for k, e := range entries {
+		if _, ok := a.entries[k]; !ok && len(a.entries) >= maxAdditionalRecordsEntries {
+			a.evict(now)
+		}
+
+		a.entries[k] = e
+	}
+}
+
+// evict removes all expired entries from the store. If none have expired, the
+// entry that expires soonest is removed. It assumes a.m is already locked.
+func (a *additionalRecords) evict(now time.Time) {
+	var (
+		soonest   additionalRecordsKey
+		expiresAt time.Time
+	)
+
+	for k, e := range a.entries {
+		if !now.Before(e.expiresAt) {
+			delete(a.entries, k)
+		} else if expiresAt.IsZero() || e.expiresAt.Before(expiresAt) {
+			soonest, expiresAt = k, e.expiresAt
+		}
+	}
+
+	if len(a.entries) >= maxAdditionalRecordsEntries {
+		delete(a.entries, soonest)
+	}
+}
+
+// Take removes and returns the records of the given type that are owned by
+// name, if they have not expired.
+func (a *additionalRecords) Take(name string, questionType uint16) ([]dns.RR, bool) {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	k := additionalRecordsKey{additionalRecordsName(name), questionType}
+	e, ok := a.entries[k]
+	if !ok {
+		return nil, false
+	}
+
+	delete(a.entries, k)
+
+	if !time.Now().Before(e.expiresAt) {
+		return nil, false
+	}
+
+	return e.records, true
+}
+
+// additionalRecordsName returns the name used as a key in additionalRecords.
+func additionalRecordsName(name string) string {
+	return strings.ToLower(normalizeName(name))
+}
@@ -0,0 +1,163 @@
+package dnssd
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/dogmatiq/dissolve/internal/domainname"
+	"github.com/miekg/dns"
+)
+
+// AdvertiseAlias starts advertising a CNAME record that makes alias an alias
+// for the target hostname.
+//
+// Queries for alias are answered with the CNAME record, followed by the
+// records of the requested type at target, if the server has any. This allows
+// machines that host service instances to be known by several names, for
+// example by aliasing a friendly hostname to the target host of an SRV record.
+//
+// Any alias previously advertised with the same name is replaced. It returns
+// an error if alias is the name of any other advertised record, as a name that
+// has a CNAME record must not have any other records.
+//
+// See https://www.rfc-editor.org/rfc/rfc1034#section-3.6.2.
+func (s *UnicastServer) AdvertiseAlias(alias, target string) error {
+	name := domainname.Absolute(alias)
+
+	rr := &dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+			Ttl:    ttlInSeconds(DefaultTTL),
+		},
+		Target: domainname.Absolute(target),
+	}
+
+	s.lock()
+	defer s.m.Unlock()
+
+	if err := s.checkAlias(name); err != nil {
+		return err
+	}
+
+	s.advertiseAliasRecord(rr)
+
+	return nil
+}
+
+// RemoveAlias stops advertising the CNAME record for the given alias that was
+// advertised using AdvertiseAlias().
+//
+// It is a no-op if no such alias is advertised.
+func (s *UnicastServer) RemoveAlias(alias string) {
+	name := domainname.Absolute(alias)
+
+	s.lock()
+	defer s.m.Unlock()
+
+	if s.removeAlias(name) {
+		logAttrs(
+			s.Logger,
+			slog.LevelInfo,
+			"stopped advertising alias",
+			slog.String("alias", name),
+		)
+	}
+}
+
+// checkAlias returns an error if the given fully-qualified name has any
+// records other than the CNAME record of an existing alias. It assumes s.m is
+// already locked.
+func (s *UnicastServer) checkAlias(name string) error {
+	for rrtype := range s.recordsAt(name) {
+		if rrtype != dns.TypeCNAME {
+			return fmt.Errorf("can not advertise %q as an alias because it has other records", name)
+		}
+	}
+
+	return nil
+}
+
+// checkNotAlias returns an error if any of the given records is owned by the
+// name of an advertised alias, as a name that has a CNAME record must not have
+// any other records. It assumes s.m is already locked.
+func (s *UnicastServer) checkNotAlias(records []dns.RR) error {
+	for _, rr := range records {
+		name := rr.Header().Name
+		if len(s.recordsAt(name)[dns.TypeCNAME]) != 0 {
+			return fmt.Errorf("can not advertise records for %q because it is an alias", name)
+		}
+	}
+
+	return nil
+}
+
+// advertiseAliasRecord starts advertising the given CNAME record, replacing
+// any existing alias with the same name. It assumes s.m is already locked for
+// writing.
+func (s *UnicastServer) advertiseAliasRecord(rr *dns.CNAME) {
+	s.removeAlias(rr.Hdr.Name)
+
+	if s.aliases == nil {
+		s.aliases = map[string]*dns.CNAME{}
+	}
+
+	s.aliases[rr.Hdr.Name] = rr
+	s.serial++
+	s.addRecord(rr)
+
+	logAttrs(
+		s.Logger,
+		slog.LevelInfo,
+		"advertising alias",
+		slog.String("alias", rr.Hdr.Name),
+		slog.String("target", rr.Target),
+	)
+}
+
+// removeAlias removes the CNAME record for the alias with the given
+// fully-qualified name. It returns false if there is no such alias. It assumes
+// s.m is already locked for writing.
+func (s *UnicastServer) removeAlias(name string) bool {
+	rr, ok := s.aliases[name]
+	if !ok {
+		return false
+	}
+
+	s.removeRecord(rr)
+	delete(s.aliases, name)
+	s.serial++
+
+	return true
+}
+
+// resolveAliases returns the records of the given type at name, preceded by
+// the chain of CNAME records that are followed to reach them, if name is an
+// alias.
+//
+// Only the server's own records are consulted. If the chain contains a loop or
+// is too long, only the CNAME records are returned. It assumes s.m is already
+// locked for reading.
+func (s *UnicastServer) resolveAliases(name string, qtype uint16) []dns.RR {
+	var answers []dns.RR
+	chain := &cnameChain{seen: []string{name}}
+
+	for {
+		records := s.recordsAt(name)
+
+		cnames := records[dns.TypeCNAME]
+		if len(cnames) == 0 {
+			return append(answers, records[qtype]...)
+		}
+
+		cname := cnames[0].(*dns.CNAME)
+		answers = append(answers, cname)
+
+		if !chain.visit(cname.Target) {
+			return answers
+		}
+
+		name = cname.Target
+	}
+}
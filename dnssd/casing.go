@@ -0,0 +1,51 @@
+package dnssd
+
+import (
+	"math/rand/v2"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// randomizesCase returns true if the resolver randomizes the case of the names
+// in its queries.
+//
+// Randomization is not used for DoT or DoH queries, which are already
+// protected against spoofing by TLS.
+func (r *UnicastResolver) randomizesCase() bool {
+	return r.RandomizeCase && r.DoT == nil && r.DoH == nil
+}
+
+// randomizeCase returns name with the case of each ASCII letter chosen at
+// random.
+//
+// See https://datatracker.ietf.org/doc/html/draft-vixie-dnsext-dns0x20-00.
+func randomizeCase(name string) string {
+	var b strings.Builder
+	b.Grow(len(name))
+
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+
+		if rand.IntN(2) == 0 {
+			if c >= 'a' && c <= 'z' {
+				c -= 'a' - 'A'
+			} else if c >= 'A' && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+		}
+
+		b.WriteByte(c)
+	}
+
+	return b.String()
+}
+
+// questionMatches returns true if the question in res is an exact,
+// case-sensitive match for the question in req.
+func questionMatches(req, res *dns.Msg) bool {
+	return len(res.Question) == 1 &&
+		res.Question[0].Name == req.Question[0].Name &&
+		res.Question[0].Qtype == req.Question[0].Qtype &&
+		res.Question[0].Qclass == req.Question[0].Qclass
+}
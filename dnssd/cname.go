@@ -0,0 +1,169 @@
+package dnssd
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// maxCNAMEHops is the maximum number of CNAME records that are followed when
+// resolving a single name.
+const maxCNAMEHops = 8
+
+// cnameChain tracks the names visited while following a chain of CNAME
+// records, in order to detect loops and enforce maxCNAMEHops.
+type cnameChain struct {
+	seen []string
+}
+
+// visit records that name has been reached by following a CNAME record. It
+// returns false if name has already been visited or the chain is too long.
+func (c *cnameChain) visit(name string) bool {
+	if len(c.seen) >= maxCNAMEHops {
+		return false
+	}
+
+	for _, n := range c.seen {
+		if equalNames(n, name) {
+			return false
+		}
+	}
+
+	c.seen = append(c.seen, name)
+	return true
+}
+
+// follow follows the chain of CNAME records in answers, starting at name. It
+// returns the last name in the chain.
+func (c *cnameChain) follow(answers []dns.RR, name string) (string, bool) {
+	for {
+		cname, ok := findCNAME(answers, name)
+		if !ok {
+			return name, true
+		}
+
+		if !c.visit(cname.Target) {
+			return "", false
+		}
+
+		name = cname.Target
+	}
+}
+
+// findCNAME returns the CNAME record in answers that is owned by name, if any.
+func findCNAME(answers []dns.RR, name string) (*dns.CNAME, bool) {
+	for _, rr := range answers {
+		if cname, ok := rr.(*dns.CNAME); ok && equalNames(cname.Hdr.Name, name) {
+			return cname, true
+		}
+	}
+	return nil, false
+}
+
+// equalNames returns true if a and b are the same domain name.
+//
+// Names are compared case-insensitively, and escape sequences are decoded
+// before comparison, such that "caf\195\169." is equal to "Café.".
+func equalNames(a, b string) bool {
+	return strings.EqualFold(normalizeName(a), normalizeName(b))
+}
+
+// normalizeName returns the presentation format of name with all non-ASCII
+// and special characters escaped consistently.
+func normalizeName(name string) string {
+	buf := make([]byte, 256)
+
+	n, err := dns.PackDomainName(dns.Fqdn(name), buf, 0, nil, false)
+	if err != nil {
+		return name
+	}
+
+	normalized, _, err := dns.UnpackDomainName(buf[:n], 0)
+	if err != nil {
+		return name
+	}
+
+	return normalized
+}
+
+// queryCanonical performs a DNS query, following any CNAME records that alias
+// name to some other "canonical" name.
+//
+// The answer section of the returned response contains only those records of
+// the requested type that are owned by the canonical name.
+func (r *UnicastResolver) queryCanonical(
+	ctx context.Context,
+	name string,
+	questionType uint16,
+) (*dns.Msg, bool, error) {
+	chain := &cnameChain{seen: []string{name}}
+
+	for {
+		res, ok, err := r.query(ctx, name, questionType)
+		if !ok || err != nil {
+			return nil, false, err
+		}
+
+		canonical, ok := chain.follow(res.Answer, name)
+		if !ok {
+			r.cnameChainFailed(name, questionType)
+			return nil, false, nil
+		}
+
+		var answers []dns.RR
+		for _, rr := range res.Answer {
+			h := rr.Header()
+			if h.Rrtype == questionType && equalNames(h.Name, canonical) {
+				answers = append(answers, rr)
+			}
+		}
+
+		// If the server did not include the records for the canonical name in
+		// its response, query for them directly.
+		if len(answers) == 0 && canonical != name {
+			name = canonical
+			continue
+		}
+
+		res.Answer = answers
+		return res, true, nil
+	}
+}
+
+// canonicalTarget returns the canonical name of the given host, which is the
+// target of an SRV record.
+//
+// RFC 2782 requires that SRV targets are not aliases, but in practice they
+// sometimes are. extra is the additional section of the response that
+// contained the SRV record. Any CNAME records for host within it are followed,
+// but the server is not queried for CNAME records that it did not include. If
+// host is not an alias, or its CNAME records form a loop, host is returned
+// unchanged.
+func (r *UnicastResolver) canonicalTarget(host string, extra []dns.RR) string {
+	name := dns.Fqdn(host)
+	chain := &cnameChain{seen: []string{name}}
+
+	name, ok := chain.follow(extra, name)
+	if !ok {
+		r.cnameChainFailed(host, dns.TypeCNAME)
+		return host
+	}
+
+	return strings.TrimSuffix(name, ".")
+}
+
+// cnameChainFailed logs a message indicating that a chain of CNAME records
+// starting at name contains a loop or is too long.
+func (r *UnicastResolver) cnameChainFailed(name string, questionType uint16) {
+	logAttrs(
+		r.Logger,
+		slog.LevelDebug,
+		"CNAME chain contains a loop or is too long",
+		append(
+			questionAttrs(dns.Question{Name: name, Qtype: questionType}),
+			slog.Int("max_hops", maxCNAMEHops),
+		)...,
+	)
+}
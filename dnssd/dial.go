@@ -0,0 +1,53 @@
+package dnssd
+
+import (
+	"context"
+	"crypto/tls"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// dial opens a connection to the DNS server at addr using the network and
+// TLS configuration of client.
+//
+// If r.DialContext is nil, the connection is established using client's own
+// dialer.
+func (r *UnicastResolver) dial(
+	ctx context.Context,
+	client *dns.Client,
+	addr string,
+) (*dns.Conn, error) {
+	if r.DialContext == nil {
+		return client.Dial(addr)
+	}
+
+	network := client.Net
+	if network == "" {
+		network = "udp"
+	}
+
+	useTLS := strings.HasSuffix(network, "-tls")
+	network = strings.TrimSuffix(network, "-tls")
+
+	conn, err := r.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if useTLS {
+		tlsConn := tls.Client(conn, client.TLSConfig)
+
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		conn = tlsConn
+	}
+
+	return &dns.Conn{
+		Conn:    conn,
+		UDPSize: client.UDPSize,
+	}, nil
+}
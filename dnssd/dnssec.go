@@ -0,0 +1,189 @@
+package dnssd
+
+import (
+	"crypto"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// SigningKey is a DNSSEC key that is used to sign the records in a zone.
+type SigningKey struct {
+	// DNSKEY is the public key, as it is published at the zone apex. Its
+	// owner name must be the zone apex.
+	DNSKEY *dns.DNSKEY
+
+	// PrivateKey is the private key that corresponds to DNSKEY, such as an
+	// *ecdsa.PrivateKey or ed25519.PrivateKey.
+	PrivateKey crypto.Signer
+}
+
+const (
+	// signatureInceptionOffset is the amount of time before the current time
+	// that RRSIG records become valid, allowing for clock skew between the
+	// server and validating resolvers.
+	signatureInceptionOffset = 1 * time.Hour
+
+	// signatureValidity is the amount of time after the current time that
+	// RRSIG records remain valid.
+	signatureValidity = 7 * 24 * time.Hour
+)
+
+// signs returns true if responses about names within z are signed for
+// clients that request DNSSEC records.
+func (z Zone) signs() bool {
+	return z.ZSK != nil
+}
+
+// dnskeyRecords returns the DNSKEY records at the apex of z.
+func (z Zone) dnskeyRecords() []dns.RR {
+	var records []dns.RR
+
+	for _, k := range []*SigningKey{z.KSK, z.ZSK} {
+		if k == nil {
+			continue
+		}
+
+		rr := dns.Copy(k.DNSKEY).(*dns.DNSKEY)
+		rr.Hdr.Name = z.apex()
+		rr.Hdr.Rrtype = dns.TypeDNSKEY
+		rr.Hdr.Class = dns.ClassINET
+		records = append(records, rr)
+	}
+
+	return records
+}
+
+// wantsDNSSEC returns true if req indicates that the client wants DNSSEC
+// records included in the response, by setting the DO bit.
+//
+// See https://www.rfc-editor.org/rfc/rfc3225.
+func wantsDNSSEC(req *dns.Msg) bool {
+	opt := req.IsEdns0()
+	return opt != nil && opt.Do()
+}
+
+// signResponse adds DNSSEC records to res, which is a response to a query for
+// a name within z. types is the set of record types that exist at the queried
+// name. It assumes s.m is already locked for reading.
+//
+// Negative responses are proven using a single NSEC record at the queried
+// name, which is generated on the fly. Responses for names that do not exist
+// are sent as NODATA responses whose NSEC record includes the NXNAME type.
+//
+// See https://www.rfc-editor.org/rfc/rfc4470.
+// See https://www.rfc-editor.org/rfc/rfc9824.
+func (s *UnicastServer) signResponse(z Zone, res *dns.Msg, types []uint16) {
+	q := res.Question[0]
+
+	if res.Rcode == dns.RcodeNameError {
+		res.Rcode = dns.RcodeSuccess
+		res.Ns = append(res.Ns, newNSECRecord(q.Name, s.negativeSOARecord(z), dns.TypeNXNAME))
+	} else if len(res.Answer) == 0 {
+		res.Ns = append(res.Ns, newNSECRecord(q.Name, s.negativeSOARecord(z), types...))
+	}
+
+	now := time.Now()
+
+	res.Answer = s.signRecords(z, res.Answer, now)
+	res.Ns = s.signRecords(z, res.Ns, now)
+	res.Extra = s.signRecords(z, res.Extra, now)
+
+	res.SetEdns0(s.udpSize(), true)
+}
+
+// newNSECRecord returns an NSEC record that proves that there are no records
+// at name other than those of the given types.
+//
+// The "next" name is the immediate successor of name in the canonical
+// ordering, such that the record does not cover any other names.
+func newNSECRecord(name string, soa *dns.SOA, types ...uint16) *dns.NSEC {
+	bitmap := append(slices.Clone(types), dns.TypeRRSIG, dns.TypeNSEC)
+	slices.Sort(bitmap)
+
+	return &dns.NSEC{
+		Hdr: dns.RR_Header{
+			Name:   name,
+			Rrtype: dns.TypeNSEC,
+			Class:  dns.ClassINET,
+			Ttl:    soa.Hdr.Ttl,
+		},
+		NextDomain: `\000.` + name,
+		TypeBitMap: slices.Compact(bitmap),
+	}
+}
+
+// signRecords returns records with an RRSIG record added after each RRset
+// that is within z.
+func (s *UnicastServer) signRecords(z Zone, records []dns.RR, now time.Time) []dns.RR {
+	var (
+		keys   []rrsetKey
+		rrsets = map[rrsetKey][]dns.RR{}
+		result []dns.RR
+	)
+
+	for _, rr := range records {
+		h := rr.Header()
+
+		if h.Rrtype == dns.TypeOPT || !dns.IsSubDomain(z.apex(), h.Name) {
+			result = append(result, rr)
+			continue
+		}
+
+		k := rrsetKey{dns.CanonicalName(h.Name), h.Rrtype}
+		if _, ok := rrsets[k]; !ok {
+			keys = append(keys, k)
+		}
+		rrsets[k] = append(rrsets[k], rr)
+	}
+
+	for _, k := range keys {
+		rrset := rrsets[k]
+		result = append(result, rrset...)
+
+		key := z.ZSK
+		if k.Type == dns.TypeDNSKEY && z.KSK != nil {
+			key = z.KSK
+		}
+
+		sig, err := newRRSIGRecord(z, key, rrset, now)
+		if err != nil {
+			logAttrs(
+				s.Logger,
+				slog.LevelWarn,
+				"unable to sign DNS records",
+				slog.String("name", rrset[0].Header().Name),
+				slog.String("type", dns.TypeToString[k.Type]),
+				slog.Any("error", err),
+			)
+			continue
+		}
+
+		result = append(result, sig)
+	}
+
+	return result
+}
+
+// newRRSIGRecord returns an RRSIG record that signs rrset using key.
+func newRRSIGRecord(z Zone, key *SigningKey, rrset []dns.RR, now time.Time) (*dns.RRSIG, error) {
+	h := rrset[0].Header()
+
+	sig := &dns.RRSIG{
+		Hdr: dns.RR_Header{
+			Name:   h.Name,
+			Rrtype: dns.TypeRRSIG,
+			Class:  dns.ClassINET,
+			Ttl:    h.Ttl,
+		},
+		Algorithm:  key.DNSKEY.Algorithm,
+		SignerName: z.apex(),
+		KeyTag:     key.DNSKEY.KeyTag(),
+		Inception:  uint32(now.Add(-signatureInceptionOffset).Unix()),
+		Expiration: uint32(now.Add(signatureValidity).Unix()),
+	}
+
+	return sig, sig.Sign(key.PrivateKey, rrset)
+}
@@ -0,0 +1,140 @@
+package dnssd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dohContentType is the media type of DNS messages sent and received using
+// DNS-over-HTTPS.
+const dohContentType = "application/dns-message"
+
+// DoHConfig is the configuration for making DNS queries using DNS-over-HTTPS
+// (DoH), as per RFC 8484.
+type DoHConfig struct {
+	// URLs is the set of DoH endpoints to query, such as
+	// "https://dns.example.com/dns-query".
+	//
+	// They are queried in order, in place of the servers in the resolver's
+	// Config.
+	URLs []string
+
+	// Client is the HTTP client used to make requests.
+	//
+	// If it is nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// client returns the HTTP client to use for DoH requests.
+func (c *DoHConfig) client() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+	return http.DefaultClient
+}
+
+// exchange sends req to the DoH endpoint at url and returns the response.
+func (c *DoHConfig) exchange(
+	ctx context.Context,
+	url string,
+	req *dns.Msg,
+) (*dns.Msg, error) {
+	// RFC 8484 recommends an ID of zero to make responses more cacheable.
+	//
+	// See https://www.rfc-editor.org/rfc/rfc8484#section-4.1.
+	m := req.Copy()
+	m.Id = 0
+
+	data, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq.Header.Set("Content-Type", dohContentType)
+	httpReq.Header.Set("Accept", dohContentType)
+
+	httpRes, err := c.client().Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpRes.Body.Close()
+
+	if httpRes.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status: %s", httpRes.Status)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(httpRes.Header.Get("Content-Type"))
+	if err != nil || mediaType != dohContentType {
+		return nil, fmt.Errorf("unexpected content type: %q", httpRes.Header.Get("Content-Type"))
+	}
+
+	data, err = io.ReadAll(io.LimitReader(httpRes.Body, dns.MaxMsgSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > dns.MaxMsgSize {
+		return nil, fmt.Errorf("response exceeds %d bytes", dns.MaxMsgSize)
+	}
+
+	res := &dns.Msg{}
+	if err := res.Unpack(data); err != nil {
+		return nil, err
+	}
+
+	res.Id = req.Id
+
+	return res, nil
+}
+
+// queryDoH performs a DNS query against a single DoH endpoint.
+func (r *UnicastResolver) queryDoH(
+	ctx context.Context,
+	url string,
+	req *dns.Msg,
+) (*dns.Msg, bool) {
+	attrs := append(
+		questionAttrs(req.Question[0]),
+		slog.String("server", url),
+	)
+
+	start := time.Now()
+	res, err := r.DoH.exchange(ctx, url, req)
+	r.observeResponse(ctx, url, req.Question[0], start, res, err)
+
+	if err != nil {
+		logAttrs(
+			r.Logger,
+			slog.LevelDebug,
+			"unable to query DNS server",
+			append(attrs, slog.Any("error", err))...,
+		)
+		return nil, false
+	}
+
+	logAttrs(
+		r.Logger,
+		slog.LevelDebug,
+		"received DNS response",
+		append(
+			attrs,
+			rcodeAttr(res.Rcode),
+			slog.Int("answers", len(res.Answer)),
+		)...,
+	)
+
+	return res, true
+}
@@ -0,0 +1,101 @@
+package dnssd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"net"
+)
+
+// DefaultDoTPort is the default port used for DNS-over-TLS queries.
+const DefaultDoTPort = "853"
+
+// DoTConfig is the configuration for making DNS queries using DNS-over-TLS
+// (DoT), as per RFC 7858.
+type DoTConfig struct {
+	// TLSConfig is the TLS configuration to use when connecting to each
+	// server.
+	//
+	// If it is nil, or TLSConfig.ServerName is empty, the server's certificate
+	// is verified against the server address from the resolver's Config.
+	TLSConfig *tls.Config
+
+	// Port is the port on which the servers accept DoT connections.
+	//
+	// If it is empty, DefaultDoTPort is used.
+	Port string
+
+	// PinnedKeys is a set of SHA-256 digests of the DER-encoded
+	// SubjectPublicKeyInfo of acceptable server keys.
+	//
+	// If it is non-empty, the connection is rejected unless the server's
+	// certificate chain contains at least one of these keys, in addition to
+	// the usual certificate verification.
+	//
+	// See https://www.rfc-editor.org/rfc/rfc7858#section-4.2.
+	PinnedKeys [][]byte
+}
+
+// port returns the port to use for DoT connections.
+func (c *DoTConfig) port() string {
+	if c.Port != "" {
+		return c.Port
+	}
+	return DefaultDoTPort
+}
+
+// tlsConfig returns the TLS configuration to use when connecting to the
+// server with the given address.
+func (c *DoTConfig) tlsConfig(server string) *tls.Config {
+	var config *tls.Config
+	if c.TLSConfig == nil {
+		config = &tls.Config{}
+	} else {
+		config = c.TLSConfig.Clone()
+	}
+
+	if config.ServerName == "" {
+		config.ServerName = server
+	}
+
+	if len(c.PinnedKeys) != 0 {
+		next := config.VerifyConnection
+
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if !c.matchesPinnedKey(state) {
+				return errors.New("server certificate chain does not contain a pinned key")
+			}
+
+			if next != nil {
+				return next(state)
+			}
+
+			return nil
+		}
+	}
+
+	return config
+}
+
+// matchesPinnedKey returns true if the certificate chain presented by the
+// server contains one of the pinned keys.
+func (c *DoTConfig) matchesPinnedKey(state tls.ConnectionState) bool {
+	for _, cert := range state.PeerCertificates {
+		digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+		for _, pin := range c.PinnedKeys {
+			if bytes.Equal(digest[:], pin) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// address returns the address to use for DoT connections to the server at
+// the given address.
+func (c *DoTConfig) address(server string) string {
+	return net.JoinHostPort(server, c.port())
+}
@@ -0,0 +1,97 @@
+package dnssd
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultUDPSize is the default maximum UDP payload size that is advertised to
+// servers using EDNS(0).
+//
+// This value avoids IP fragmentation on the vast majority of networks, as
+// recommended by https://www.dnsflagday.net/2020/.
+const DefaultUDPSize = 1232
+
+// setEDNS0 adds an EDNS(0) OPT record to req, unless EDNS(0) is disabled.
+func (r *UnicastResolver) setEDNS0(req *dns.Msg) {
+	if r.DisableEDNS0 {
+		return
+	}
+
+	size := r.UDPSize
+	if size == 0 {
+		size = DefaultUDPSize
+	}
+
+	req.SetEdns0(size, false)
+}
+
+// queryServerWithEDNS0Fallback performs a DNS query against a single server.
+// If the server rejects the EDNS(0) OPT record in req, the query is repeated
+// without it.
+//
+// See https://www.rfc-editor.org/rfc/rfc6891#section-7.
+func (r *UnicastResolver) queryServerWithEDNS0Fallback(
+	ctx context.Context,
+	server string,
+	req *dns.Msg,
+) (*dns.Msg, bool) {
+	res, ok := r.queryServer(ctx, server, req)
+	if !ok || !rejectsEDNS0(req, res) {
+		return res, ok
+	}
+
+	logAttrs(
+		r.Logger,
+		slog.LevelDebug,
+		"DNS server does not support EDNS(0), retrying without it",
+		append(
+			questionAttrs(req.Question[0]),
+			slog.String("server", server),
+			rcodeAttr(res.Rcode),
+		)...,
+	)
+
+	fallback := req.Copy()
+	fallback.Extra = nil
+
+	return r.queryServer(ctx, server, fallback)
+}
+
+// rejectsEDNS0 returns true if res indicates that the server does not support
+// the EDNS(0) OPT record in req.
+func rejectsEDNS0(req, res *dns.Msg) bool {
+	if req.IsEdns0() == nil || res.IsEdns0() != nil {
+		return false
+	}
+
+	switch res.Rcode {
+	case dns.RcodeFormatError, dns.RcodeNotImplemented, dns.RcodeServerFailure:
+		return true
+	default:
+		return false
+	}
+}
+
+// udpSize returns the maximum UDP payload size that the server sends to
+// clients that support EDNS(0).
+func (s *UnicastServer) udpSize() uint16 {
+	if s.UDPSize == 0 {
+		return DefaultUDPSize
+	}
+	return s.UDPSize
+}
+
+// setEDNS0 adds an EDNS(0) OPT record to res if req contains one, unless res
+// already has an OPT record.
+//
+// See https://www.rfc-editor.org/rfc/rfc6891#section-6.1.1.
+func (s *UnicastServer) setEDNS0(req, res *dns.Msg) {
+	if req.IsEdns0() == nil || res.IsEdns0() != nil {
+		return
+	}
+
+	res.SetEdns0(s.udpSize(), false)
+}
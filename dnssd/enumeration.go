@@ -148,3 +148,70 @@ func RelativeSelectiveInstanceEnumerationDomain(subType, serviceType string) str
 		RelativeInstanceEnumerationDomain(serviceType),
 	)
 }
+
+// DomainEnumerationType is a type of "domain enumeration" query.
+//
+// Domain enumeration is used to discover the domains that are recommended for
+// browsing and registering services.
+//
+// See https://www.rfc-editor.org/rfc/rfc6763#section-11
+type DomainEnumerationType string
+
+const (
+	// BrowsingDomains is the domain enumeration type used to find the domains
+	// that are recommended for browsing.
+	BrowsingDomains DomainEnumerationType = "b"
+
+	// DefaultBrowsingDomain is the domain enumeration type used to find the
+	// single recommended default domain for browsing.
+	DefaultBrowsingDomain DomainEnumerationType = "db"
+
+	// LegacyBrowsingDomains is the domain enumeration type used to find the
+	// domains that are recommended for automatic browsing by legacy clients.
+	LegacyBrowsingDomains DomainEnumerationType = "lb"
+
+	// RegistrationDomains is the domain enumeration type used to find the
+	// domains that are recommended for registering services.
+	RegistrationDomains DomainEnumerationType = "r"
+
+	// DefaultRegistrationDomain is the domain enumeration type used to find the
+	// single recommended default domain for registering services.
+	DefaultRegistrationDomain DomainEnumerationType = "dr"
+)
+
+// AbsoluteDomainEnumerationDomain returns the absolute DNS name that is queried
+// to perform "domain enumeration" of a specific type.
+//
+// domain is the domain that is queried. It may be a domain learned from some
+// other source, such as DHCP, or the reverse-mapping domain of an IP address.
+//
+// See https://www.rfc-editor.org/rfc/rfc6763#section-11
+func AbsoluteDomainEnumerationDomain(t DomainEnumerationType, domain string) string {
+	return domainname.Absolute(
+		RelativeDomainEnumerationDomain(t),
+		domain,
+	)
+}
+
+// RelativeDomainEnumerationDomain returns the DNS name that is queried to
+// perform "domain enumeration" of a specific type, relative to the domain that
+// is queried.
+//
+// See https://www.rfc-editor.org/rfc/rfc6763#section-11
+func RelativeDomainEnumerationDomain(t DomainEnumerationType) string {
+	return domainname.Relative(string(t), "_dns-sd", "_udp")
+}
+
+// Domains is the result of performing "domain enumeration".
+//
+// Each field contains the domains that were discovered for the corresponding
+// DomainEnumerationType, without the trailing dot.
+//
+// See https://www.rfc-editor.org/rfc/rfc6763#section-11
+type Domains struct {
+	Browsing            []string
+	DefaultBrowsing     []string
+	LegacyBrowsing      []string
+	Registration        []string
+	DefaultRegistration []string
+}
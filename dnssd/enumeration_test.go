@@ -47,3 +47,18 @@ var _ = Describe("func RelativeSelectiveInstanceEnumerationDomain()", func() {
 		Expect(d).To(Equal("_printer._sub._http._tcp"))
 	})
 })
+
+var _ = Describe("func AbsoluteDomainEnumerationDomain()", func() {
+	DescribeTable(
+		"it returns the absolute 'domain enumeration domain' for the given type and domain",
+		func(t DomainEnumerationType, expect string) {
+			d := AbsoluteDomainEnumerationDomain(t, "example.org")
+			Expect(d).To(Equal(expect))
+		},
+		Entry("browsing", BrowsingDomains, "b._dns-sd._udp.example.org."),
+		Entry("default browsing", DefaultBrowsingDomain, "db._dns-sd._udp.example.org."),
+		Entry("legacy browsing", LegacyBrowsingDomains, "lb._dns-sd._udp.example.org."),
+		Entry("registration", RegistrationDomains, "r._dns-sd._udp.example.org."),
+		Entry("default registration", DefaultRegistrationDomain, "dr._dns-sd._udp.example.org."),
+	)
+})
@@ -0,0 +1,108 @@
+package dnssd_test
+
+import (
+	"testing"
+
+	. "github.com/dogmatiq/dissolve/dnssd"
+	"github.com/miekg/dns"
+)
+
+func FuzzParseInstance(f *testing.F) {
+	f.Add(`Boardroom\ Printer._http._tcp.example.org.`)
+	f.Add(`Instance\.A\\._http._tcp.example.org.`)
+	f.Add(`trailing\`)
+	f.Add(``)
+
+	f.Fuzz(func(t *testing.T, name string) {
+		instance, tail, err := ParseInstance(name)
+		if err != nil {
+			return
+		}
+
+		// Escaping the parsed instance name and parsing it again must produce
+		// the same result.
+		again, rest, err := ParseInstance(EscapeInstance(instance) + "." + tail)
+		if err != nil {
+			t.Fatalf("unable to re-parse escaped instance name: %s", err)
+		}
+
+		if again != instance || rest != tail {
+			t.Fatalf("re-parsed instance name %q (tail %q) does not match %q (tail %q)", again, rest, instance, tail)
+		}
+	})
+}
+
+func FuzzAttributesWithTXT(f *testing.F) {
+	f.Add("key=value")
+	f.Add("flag")
+	f.Add("=ignored")
+	f.Add("key=")
+	f.Add("\x00=value")
+
+	f.Fuzz(func(t *testing.T, pair string) {
+		attrs, ok, err := NewAttributes().WithTXT(pair)
+		if err != nil || !ok {
+			return
+		}
+
+		// The attributes must be able to be encoded and decoded again without
+		// loss.
+		var decoded Attributes
+		for _, p := range attrs.ToTXT() {
+			decoded, _, err = decoded.WithTXT(p)
+			if err != nil {
+				t.Fatalf("unable to parse encoded attribute %q: %s", p, err)
+			}
+		}
+
+		if !decoded.Equal(attrs) {
+			t.Fatalf("decoded attributes do not match original %q", pair)
+		}
+	})
+}
+
+func FuzzUnicastServerServeDNS(f *testing.F) {
+	server := &UnicastServer{}
+	if err := server.Advertise(
+		ServiceInstance{
+			ServiceInstanceName: ServiceInstanceName{
+				Name:        "Instance A",
+				ServiceType: "_http._tcp",
+				Domain:      "example.org",
+			},
+			TargetHost: "a.example.com",
+			TargetPort: 12345,
+		},
+	); err != nil {
+		f.Fatal(err)
+	}
+
+	for _, q := range []dns.Question{
+		{Name: "_http._tcp.example.org.", Qtype: dns.TypePTR, Qclass: dns.ClassINET},
+		{Name: `Instance\ A._http._tcp.example.org.`, Qtype: dns.TypeANY, Qclass: dns.ClassANY},
+	} {
+		req := &dns.Msg{}
+		req.Question = []dns.Question{q}
+		data, err := req.Pack()
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		req := &dns.Msg{}
+		if err := req.Unpack(data); err != nil {
+			return
+		}
+
+		w := &responseWriter{}
+		server.ServeDNS(w, req)
+
+		for _, res := range w.Messages {
+			if _, err := res.Pack(); err != nil {
+				t.Fatalf("unable to pack response: %s", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package dnssd
+
+import (
+	"log/slog"
+	"net"
+
+	"github.com/dogmatiq/dissolve/internal/domainname"
+	"github.com/miekg/dns"
+)
+
+// AdvertiseHost starts advertising A and AAAA records for the given hostname.
+//
+// It allows the addresses of a machine that hosts several service instances
+// to be managed independently of those instances, instead of being added to
+// a single instance using WithIPAddress(). The records are included in the
+// additional section of responses about any instance that targets hostname.
+//
+// ips replaces any addresses that were previously advertised for hostname
+// using AdvertiseHost(). Addresses added to instances using WithIPAddress()
+// are unaffected.
+//
+// It returns an error if hostname is the name of an alias advertised using
+// AdvertiseAlias().
+func (s *UnicastServer) AdvertiseHost(hostname string, ips ...net.IP) error {
+	target := ServiceInstance{TargetHost: hostname}
+	name := domainname.Absolute(hostname)
+
+	var records []dns.RR
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			records = append(records, NewARecord(target, ip))
+		} else if ip.To16() != nil {
+			records = append(records, NewAAAARecord(target, ip))
+		}
+	}
+
+	s.lock()
+	defer s.m.Unlock()
+
+	if err := s.checkNotAlias(records); err != nil {
+		return err
+	}
+
+	s.advertiseHostRecords(name, records)
+
+	return nil
+}
+
+// RemoveHost stops advertising the address records for the given hostname
+// that were advertised using AdvertiseHost().
+//
+// It is a no-op if no such host is advertised.
+func (s *UnicastServer) RemoveHost(hostname string) {
+	name := domainname.Absolute(hostname)
+
+	s.lock()
+	defer s.m.Unlock()
+
+	if s.removeHost(name) {
+		logAttrs(
+			s.Logger,
+			slog.LevelInfo,
+			"stopped advertising host",
+			slog.String("host", name),
+		)
+	}
+}
+
+// removeHost removes the address records for the host with the given
+// fully-qualified name. It returns false if there is no such host. It assumes
+// s.m is already locked for writing.
+func (s *UnicastServer) removeHost(name string) bool {
+	records, ok := s.hosts[name]
+	if !ok {
+		return false
+	}
+
+	for _, rr := range records {
+		s.removeRecord(rr)
+	}
+
+	delete(s.hosts, name)
+	s.serial++
+
+	return true
+}
+
+// advertiseHostRecords starts advertising the given address records for the
+// host with the given fully-qualified name, replacing any existing records for
+// that host. It assumes s.m is already locked for writing.
+func (s *UnicastServer) advertiseHostRecords(name string, records []dns.RR) {
+	s.removeHost(name)
+
+	if s.hosts == nil {
+		s.hosts = map[string][]dns.RR{}
+	}
+
+	s.hosts[name] = records
+	s.serial++
+
+	for _, rr := range records {
+		s.addRecord(rr)
+	}
+
+	logAttrs(
+		s.Logger,
+		slog.LevelInfo,
+		"advertising host",
+		slog.String("host", name),
+		slog.Int("addresses", len(records)),
+	)
+}
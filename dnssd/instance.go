@@ -1,6 +1,8 @@
 package dnssd
 
 import (
+	"net"
+	"slices"
 	"time"
 )
 
@@ -54,6 +56,16 @@ type ServiceInstance struct {
 	// empty TXT record.
 	Attributes AttributeCollection
 
+	// Bindings contains the parameters of the instance's SVCB and HTTPS
+	// records, if any.
+	//
+	// These records are optional. They allow clients to discover the
+	// protocols supported by the service, such as HTTP/3, without first
+	// connecting to it.
+	//
+	// See https://www.rfc-editor.org/rfc/rfc9460.
+	Bindings []ServiceBinding
+
 	// TTL is the time-to-live of the instance's DNS records.
 	TTL time.Duration
 }
@@ -66,5 +78,54 @@ func (i ServiceInstance) Equal(inst ServiceInstance) bool {
 		i.Priority == inst.Priority &&
 		i.Weight == inst.Weight &&
 		i.Attributes.Equal(inst.Attributes) &&
+		slices.EqualFunc(i.Bindings, inst.Bindings, ServiceBinding.Equal) &&
 		i.TTL == inst.TTL
 }
+
+// ServiceBinding describes the parameters of a single SVCB or HTTPS record
+// associated with a service instance.
+//
+// See https://www.rfc-editor.org/rfc/rfc9460.
+type ServiceBinding struct {
+	// HTTPS is true if the binding is described by an HTTPS record, rather than
+	// a generic SVCB record.
+	HTTPS bool
+
+	// Priority is the priority of the binding relative to the instance's other
+	// bindings. Lower values have a higher priority.
+	//
+	// A priority of zero indicates that the binding is an alias for Target, in
+	// which case the remaining fields are ignored.
+	Priority uint16
+
+	// Target is the fully-qualified hostname of the machine that provides the
+	// service.
+	//
+	// If it is empty, the binding refers to the owner of the record, which is
+	// the service instance name.
+	Target string
+
+	// ALPN is the set of Application-Layer Protocol Negotiation identifiers
+	// supported by the service, such as "h2" or "h3".
+	ALPN []string
+
+	// Port is the port on which the service is provided, if different to the
+	// default port for the protocol.
+	Port uint16
+
+	// IPv4Hints and IPv6Hints are addresses that clients may use to reach
+	// Target without performing any further queries.
+	IPv4Hints []net.IP
+	IPv6Hints []net.IP
+}
+
+// Equal returns true if b and binding are equal.
+func (b ServiceBinding) Equal(binding ServiceBinding) bool {
+	return b.HTTPS == binding.HTTPS &&
+		b.Priority == binding.Priority &&
+		b.Target == binding.Target &&
+		slices.Equal(b.ALPN, binding.ALPN) &&
+		b.Port == binding.Port &&
+		slices.EqualFunc(b.IPv4Hints, binding.IPv4Hints, net.IP.Equal) &&
+		slices.EqualFunc(b.IPv6Hints, binding.IPv6Hints, net.IP.Equal)
+}
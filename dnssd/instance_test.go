@@ -1,6 +1,7 @@
 package dnssd_test
 
 import (
+	"net"
 	"time"
 
 	. "github.com/dogmatiq/dissolve/dnssd"
@@ -78,6 +79,19 @@ var _ = Describe("type ServiceInstance", func() {
 					},
 				},
 			),
+			Entry(
+				"equivalent binding hints",
+				ServiceInstance{
+					Bindings: []ServiceBinding{
+						{Priority: 1, IPv4Hints: []net.IP{net.IPv4(192, 168, 20, 1)}},
+					},
+				},
+				ServiceInstance{
+					Bindings: []ServiceBinding{
+						{Priority: 1, IPv4Hints: []net.IP{net.IPv4(192, 168, 20, 1).To4()}},
+					},
+				},
+			),
 			Entry(
 				"multiple copies of the same set of attributes",
 				ServiceInstance{
@@ -167,6 +181,32 @@ var _ = Describe("type ServiceInstance", func() {
 				ServiceInstance{TTL: 30 * time.Second},
 				ServiceInstance{TTL: 60 * time.Second},
 			),
+			Entry(
+				"different bindings",
+				ServiceInstance{
+					Bindings: []ServiceBinding{
+						{HTTPS: true, Priority: 1, ALPN: []string{"h2"}},
+					},
+				},
+				ServiceInstance{
+					Bindings: []ServiceBinding{
+						{HTTPS: true, Priority: 1, ALPN: []string{"h3"}},
+					},
+				},
+			),
+			Entry(
+				"different binding hints",
+				ServiceInstance{
+					Bindings: []ServiceBinding{
+						{Priority: 1, IPv4Hints: []net.IP{net.IPv4(192, 168, 20, 1)}},
+					},
+				},
+				ServiceInstance{
+					Bindings: []ServiceBinding{
+						{Priority: 1, IPv4Hints: []net.IP{net.IPv4(192, 168, 20, 2)}},
+					},
+				},
+			),
 			Entry(
 				"different attributes",
 				ServiceInstance{
@@ -2,6 +2,7 @@ package dnssd
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/dogmatiq/dissolve/internal/domainname"
@@ -106,12 +107,13 @@ func EscapeInstance(instance string) string {
 
 	var w strings.Builder
 
-	for _, r := range instance {
-		if strings.ContainsRune(needsEscape, r) {
-			w.WriteRune('\\')
+	for i := 0; i < len(instance); i++ {
+		ch := instance[i]
+		if strings.IndexByte(needsEscape, ch) != -1 {
+			w.WriteByte('\\')
 		}
 
-		w.WriteRune(r)
+		w.WriteByte(ch)
 	}
 
 	return w.String()
@@ -137,26 +139,62 @@ func ParseInstance(name string) (instance, tail string, err error) {
 	// preceding literal dots with a backslash (so "." becomes "\.").
 	// Likewise, any backslashes in the <Instance> portion should also be
 	// escaped by preceding them with a backslash (so "\" becomes "\\").
+	//
+	// Names received from DNS messages may also contain "\DDD" escape
+	// sequences, as per RFC 1035, which represent an arbitrary byte by its
+	// decimal value. This is how the miekg/dns package represents bytes outside
+	// of the printable ASCII range, such as those in UTF-8 encoded names.
 	var w strings.Builder
-	escaped := false
-
-	for i, r := range name {
-		if escaped {
-			escaped = false
-		} else if r == '\\' {
-			escaped = true
-			continue
-		} else if r == '.' {
-			tail = name[i+1:] // we know '.' is a single byte
-			break
+
+	for i := 0; i < len(name); i++ {
+		switch ch := name[i]; ch {
+		case '.':
+			return w.String(), name[i+1:], nil
+		case '\\':
+			if i == len(name)-1 {
+				return "", "", errors.New("name is terminated with an escape character")
+			}
+
+			b, n, err := unescape(name[i+1:])
+			if err != nil {
+				return "", "", err
+			}
+			w.WriteByte(b)
+			i += n
+		default:
+			w.WriteByte(ch)
 		}
+	}
+
+	return w.String(), "", nil
+}
+
+// unescape decodes the escape sequence at the start of s, which is the text
+// immediately following a backslash.
+//
+// It returns the decoded byte and the number of bytes of s that were consumed.
+func unescape(s string) (b byte, n int, err error) {
+	if s == "" {
+		return 0, 0, errors.New("escape sequence is incomplete")
+	}
+
+	if !isDigit(s[0]) {
+		return s[0], 1, nil
+	}
 
-		w.WriteRune(r)
+	if len(s) < 3 || !isDigit(s[1]) || !isDigit(s[2]) {
+		return 0, 0, errors.New("decimal escape sequence must contain exactly 3 digits")
 	}
 
-	if escaped {
-		return "", "", errors.New("name is terminated with an escape character")
+	v := int(s[0]-'0')*100 + int(s[1]-'0')*10 + int(s[2]-'0')
+	if v > 255 {
+		return 0, 0, fmt.Errorf("decimal escape sequence \\%s is out of range", s[:3])
 	}
 
-	return w.String(), tail, nil
+	return byte(v), 3, nil
+}
+
+// isDigit returns true if ch is an ASCII decimal digit.
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
 }
@@ -129,6 +129,23 @@ var _ = Describe("func ParseInstance()", func() {
 		Expect(tail).To(Equal("example.org"))
 	})
 
+	It("unescapes decimal escape sequences", func() {
+		n, tail, err := ParseInstance(`Caf\195\169\032Bar.example.org`)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(n).To(Equal("Café Bar"))
+		Expect(tail).To(Equal("example.org"))
+	})
+
+	It("returns an error if a decimal escape sequence is too short", func() {
+		_, _, err := ParseInstance(`Foo\12`)
+		Expect(err).To(MatchError("decimal escape sequence must contain exactly 3 digits"))
+	})
+
+	It("returns an error if a decimal escape sequence is out of range", func() {
+		_, _, err := ParseInstance(`Foo\256`)
+		Expect(err).To(MatchError(`decimal escape sequence \256 is out of range`))
+	})
+
 	It("returns an error if the name ends with the escape sequence", func() {
 		_, _, err := ParseInstance(`Foo\`)
 		Expect(err).To(MatchError("name is terminated with an escape character"))
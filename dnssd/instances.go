@@ -0,0 +1,146 @@
+package dnssd
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// AdvertisedInstance is a service instance that is advertised by a
+// UnicastServer.
+type AdvertisedInstance struct {
+	ServiceInstance
+
+	// IPAddresses is the set of IP addresses of the instance's target host
+	// that are advertised along with the instance, as per WithIPAddress().
+	IPAddresses []net.IP
+
+	// ServiceSubTypes is the set of service sub-types that the instance is
+	// advertised as providing, as per WithServiceSubType().
+	ServiceSubTypes []string
+}
+
+// Options returns the options that advertise i.IPAddresses and
+// i.ServiceSubTypes, such that i can be advertised by another server.
+func (i AdvertisedInstance) Options() []AdvertiseOption {
+	var options []AdvertiseOption
+
+	for _, ip := range i.IPAddresses {
+		options = append(options, WithIPAddress(ip))
+	}
+
+	for _, subType := range i.ServiceSubTypes {
+		options = append(options, WithServiceSubType(subType))
+	}
+
+	return options
+}
+
+// Instances returns the service instances that are currently advertised by
+// the server, in order of their fully-qualified names.
+//
+// The instances are decoded from the records that the server is serving. As
+// such, a zero TTL is reported as DefaultTTL, and empty attribute collections
+// are omitted.
+//
+// Hosts and aliases advertised using AdvertiseHost() and AdvertiseAlias() are
+// not included.
+func (s *UnicastServer) Instances() []AdvertisedInstance {
+	s.rlock()
+	defer s.m.RUnlock()
+
+	instances := make([]AdvertisedInstance, 0, len(s.instances))
+
+	for _, name := range sortedKeys(s.instances) {
+		n, ok := parseServiceInstanceName(name)
+		if !ok {
+			continue
+		}
+
+		instances = append(instances, newAdvertisedInstance(n, s.instances[name].records))
+	}
+
+	return instances
+}
+
+// newAdvertisedInstance returns the instance described by the given records,
+// which are those produced by NewRecords().
+func newAdvertisedInstance(n ServiceInstanceName, records []dns.RR) AdvertisedInstance {
+	i := AdvertisedInstance{}
+	i.ServiceInstanceName = n
+
+	for _, rr := range records {
+		switch rr := rr.(type) {
+		case *dns.SRV:
+			i.TTL = time.Duration(rr.Hdr.Ttl) * time.Second
+			unpackSRV(&i.ServiceInstance, rr)
+
+		case *dns.TXT:
+			var attrs Attributes
+			for _, pair := range rr.Txt {
+				// The records were built from valid attributes, so they are
+				// always decoded without error.
+				attrs, _ = withEscapedTXT(attrs, pair)
+			}
+
+			if !attrs.IsEmpty() {
+				i.Attributes = append(i.Attributes, attrs)
+			}
+
+		case *dns.SVCB:
+			i.Bindings = append(i.Bindings, unpackSVCB(rr, false))
+
+		case *dns.HTTPS:
+			i.Bindings = append(i.Bindings, unpackSVCB(&rr.SVCB, true))
+
+		case *dns.PTR:
+			if labels := dns.SplitDomainName(rr.Hdr.Name); len(labels) > 1 && labels[1] == "_sub" {
+				i.ServiceSubTypes = append(i.ServiceSubTypes, labels[0])
+			}
+
+		case *dns.A:
+			i.IPAddresses = append(i.IPAddresses, rr.A)
+
+		case *dns.AAAA:
+			i.IPAddresses = append(i.IPAddresses, rr.AAAA)
+		}
+	}
+
+	return i
+}
+
+// AdvertiserLister is an interface for types that can list the service
+// instances that they advertise, such as UnicastServer.
+//
+// It allows reconcilers and clean-up jobs to discover orphaned instances
+// without relying on external record dumps.
+type AdvertiserLister interface {
+	// List returns the advertised instances of the given service type within
+	// the given domain.
+	List(ctx context.Context, serviceType, domain string) ([]ServiceInstance, error)
+}
+
+var _ AdvertiserLister = (*UnicastServer)(nil)
+
+// List returns the advertised instances of the given service type within the
+// given domain, in order of their fully-qualified names.
+//
+// It is a convenience for filtering the result of Instances(), and
+// implements AdvertiserLister.
+func (s *UnicastServer) List(ctx context.Context, serviceType, domain string) ([]ServiceInstance, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var instances []ServiceInstance
+
+	for _, i := range s.Instances() {
+		if equalNames(i.ServiceType, serviceType) && equalNames(i.Domain, domain) {
+			instances = append(instances, i.ServiceInstance)
+		}
+	}
+
+	return instances, nil
+}
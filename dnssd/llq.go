@@ -0,0 +1,530 @@
+package dnssd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DNS Long-Lived Queries (LLQ) constants.
+//
+// See https://www.rfc-editor.org/rfc/rfc8764.
+const (
+	llqVersion = 1
+
+	llqOpcodeSetup   = 1
+	llqOpcodeRefresh = 2
+	llqOpcodeEvent   = 3
+)
+
+const (
+	// llqLease is the lease time requested for each LLQ.
+	llqLease = time.Hour
+
+	// llqMinLease is the shortest lease that is accepted from a server.
+	//
+	// Each LLQ is refreshed before its lease expires, so a shorter lease would
+	// cause the client to send refresh requests in a tight loop.
+	llqMinLease = 30 * time.Second
+
+	// llqRetransmitInterval is the interval at which unanswered LLQ requests
+	// are retransmitted.
+	llqRetransmitInterval = time.Second
+
+	// llqAttempts is the number of times an LLQ request is sent before giving
+	// up.
+	llqAttempts = 3
+)
+
+// llqErrorToString maps LLQ error codes to strings.
+var llqErrorToString = map[uint16]string{
+	1: "SERV-FULL",
+	2: "STATIC",
+	3: "FORMAT-ERR",
+	4: "NO-SUCH-LLQ",
+	5: "BAD-VERS",
+	6: "UNKNOWN-ERR",
+}
+
+// AbsoluteLLQServiceName returns the absolute DNS name that is queried to
+// discover the DNS Long-Lived Query server for a zone.
+//
+// See https://www.rfc-editor.org/rfc/rfc8764#section-5.2.
+func AbsoluteLLQServiceName(zone string) string {
+	return AbsoluteInstanceEnumerationDomain("_dns-llq._udp", zone)
+}
+
+// lookupLLQServer returns the address of the DNS Long-Lived Query server for
+// the given zone.
+//
+// ok is false if the zone does not advertise an LLQ server.
+func (r *UnicastResolver) lookupLLQServer(
+	ctx context.Context,
+	zone string,
+) (addr string, ok bool, err error) {
+	srv, ok, err := r.lookupSRV(ctx, AbsoluteLLQServiceName(zone))
+	if !ok || err != nil {
+		return "", false, err
+	}
+
+	host := strings.TrimSuffix(srv.Target, ".")
+	return net.JoinHostPort(host, strconv.Itoa(int(srv.Port))), true, nil
+}
+
+// llqSession manages a set of DNS Long-Lived Queries made against a single
+// server.
+//
+// See https://www.rfc-editor.org/rfc/rfc8764.
+type llqSession struct {
+	conn   net.Conn
+	logger *slog.Logger
+
+	changed chan struct{}
+	done    chan struct{}
+
+	m       sync.Mutex
+	err     error
+	pending map[uint16]chan *dns.Msg
+	queries map[dns.Question]*llq
+}
+
+// llq is a single long-lived query.
+type llq struct {
+	ID     uint64
+	Cancel context.CancelFunc
+}
+
+var _ changeNotifier = (*llqSession)(nil)
+
+// dialLLQSession returns a session that makes long-lived queries against the
+// server at addr.
+func dialLLQSession(
+	ctx context.Context,
+	addr string,
+	logger *slog.Logger,
+) (*llqSession, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &llqSession{
+		conn:    conn,
+		logger:  logger,
+		changed: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		pending: map[uint16]chan *dns.Msg{},
+		queries: map[dns.Question]*llq{},
+	}
+
+	go s.read()
+
+	return s, nil
+}
+
+// Changed returns a channel that receives a value whenever the server sends
+// an event for any of the long-lived queries.
+func (s *llqSession) Changed() <-chan struct{} {
+	return s.changed
+}
+
+// Done returns a channel that is closed when the session is terminated.
+func (s *llqSession) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the error that caused the session to terminate.
+func (s *llqSession) Err() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.err
+}
+
+// Close cancels all long-lived queries and terminates the session.
+func (s *llqSession) Close() {
+	s.m.Lock()
+	queries := s.queries
+	s.queries = map[dns.Question]*llq{}
+	s.m.Unlock()
+
+	for q, l := range queries {
+		s.cancel(q, l)
+	}
+
+	s.close(errors.New("session closed"))
+}
+
+// Sync sets up a long-lived query for each of the questions in qs, and cancels
+// any existing long-lived queries that are not in qs.
+func (s *llqSession) Sync(ctx context.Context, qs []dns.Question) error {
+	want := make(map[dns.Question]struct{}, len(qs))
+	for _, q := range qs {
+		want[q] = struct{}{}
+	}
+
+	s.m.Lock()
+	unwanted := map[dns.Question]*llq{}
+	for q, l := range s.queries {
+		if _, ok := want[q]; !ok {
+			delete(s.queries, q)
+			unwanted[q] = l
+		}
+	}
+	s.m.Unlock()
+
+	for q, l := range unwanted {
+		s.cancel(q, l)
+	}
+
+	for q := range want {
+		s.m.Lock()
+		_, ok := s.queries[q]
+		s.m.Unlock()
+
+		if ok {
+			continue
+		}
+
+		if err := s.setup(ctx, q); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setup establishes a long-lived query for q using the four-way handshake.
+//
+// See https://www.rfc-editor.org/rfc/rfc8764#section-5.
+func (s *llqSession) setup(ctx context.Context, q dns.Question) error {
+	// Send the initial request. The server responds with a challenge that
+	// contains the LLQ ID.
+	opt, err := s.exchange(ctx, newLLQRequest(q, llqOpcodeSetup, 0, llqLease))
+	if err != nil {
+		return err
+	}
+
+	lease, err := grantedLease(opt)
+	if err != nil {
+		return err
+	}
+
+	// Echo the challenge back to the server, which responds with an ACK, along
+	// with the current answers to the question. We don't use the answers, as
+	// the enumerator performs its own queries when it is notified of a change.
+	opt, err = s.exchange(ctx, newLLQRequest(q, llqOpcodeSetup, opt.Id, lease))
+	if err != nil {
+		return err
+	}
+
+	lease, err = grantedLease(opt)
+	if err != nil {
+		return err
+	}
+
+	refreshCtx, cancel := context.WithCancel(context.Background())
+
+	s.m.Lock()
+	s.queries[q] = &llq{ID: opt.Id, Cancel: cancel}
+	s.m.Unlock()
+
+	go s.maintain(refreshCtx, q, lease)
+
+	return nil
+}
+
+// maintain refreshes the LLQ for q before its lease expires, until ctx is
+// canceled.
+func (s *llqSession) maintain(ctx context.Context, q dns.Question, lease time.Duration) {
+	for {
+		// Refresh when 80% of the lease has elapsed, such that there is time
+		// to retry if the refresh request is lost.
+		timer := time.NewTimer(lease * 8 / 10)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		var err error
+		lease, err = s.refresh(ctx, q)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.close(err)
+			}
+			return
+		}
+	}
+}
+
+// refresh renews the lease of the LLQ for q. It returns the lease granted by
+// the server.
+func (s *llqSession) refresh(ctx context.Context, q dns.Question) (time.Duration, error) {
+	s.m.Lock()
+	l, ok := s.queries[q]
+	s.m.Unlock()
+
+	if !ok {
+		return 0, context.Canceled
+	}
+
+	opt, err := s.exchange(ctx, newLLQRequest(q, llqOpcodeRefresh, l.ID, llqLease))
+	if err != nil {
+		return 0, err
+	}
+
+	return grantedLease(opt)
+}
+
+// grantedLease returns the lease granted by the server in opt. It returns an
+// error if the lease is shorter than llqMinLease.
+func grantedLease(opt *dns.EDNS0_LLQ) (time.Duration, error) {
+	lease := time.Duration(opt.LeaseLife) * time.Second
+	if lease < llqMinLease {
+		return 0, fmt.Errorf(
+			"DNS long-lived query server granted a lease of %s, which is shorter than the minimum of %s",
+			lease,
+			llqMinLease,
+		)
+	}
+
+	return lease, nil
+}
+
+// cancel cancels the LLQ for q by refreshing it with a lease of zero.
+//
+// This is a best-effort attempt, it does not wait for a response from the
+// server. The lease expires on the server regardless.
+func (s *llqSession) cancel(q dns.Question, l *llq) {
+	l.Cancel()
+	_ = s.write(newLLQRequest(q, llqOpcodeRefresh, l.ID, 0))
+}
+
+// exchange sends req to the server and waits for the response, retransmitting
+// the request if necessary.
+func (s *llqSession) exchange(ctx context.Context, req *dns.Msg) (*dns.EDNS0_LLQ, error) {
+	res := make(chan *dns.Msg, 1)
+
+	s.m.Lock()
+	if s.err != nil {
+		s.m.Unlock()
+		return nil, s.err
+	}
+
+	for s.pending[req.Id] != nil {
+		req.Id = dns.Id()
+	}
+	s.pending[req.Id] = res
+	s.m.Unlock()
+
+	defer func() {
+		s.m.Lock()
+		delete(s.pending, req.Id)
+		s.m.Unlock()
+	}()
+
+	for attempt := 0; attempt < llqAttempts; attempt++ {
+		if err := s.write(req); err != nil {
+			return nil, err
+		}
+
+		timer := time.NewTimer(llqRetransmitInterval)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-s.done:
+			timer.Stop()
+			return nil, s.Err()
+		case <-timer.C:
+			continue
+		case m := <-res:
+			timer.Stop()
+
+			opt := llqOption(m)
+			if opt == nil {
+				return nil, errors.New("LLQ server response does not contain an LLQ option")
+			}
+
+			if opt.Error != 0 {
+				return nil, fmt.Errorf(
+					"LLQ server rejected query for %s %s: %s",
+					req.Question[0].Name,
+					dns.TypeToString[req.Question[0].Qtype],
+					llqErrorString(opt.Error),
+				)
+			}
+
+			return opt, nil
+		}
+	}
+
+	return nil, errors.New("LLQ server did not respond")
+}
+
+// write sends a DNS message to the server.
+func (s *llqSession) write(m *dns.Msg) error {
+	data, err := m.Pack()
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.conn.Write(data); err != nil {
+		s.close(err)
+		return err
+	}
+
+	return nil
+}
+
+// read reads messages from the server until the session is terminated.
+func (s *llqSession) read() {
+	buf := make([]byte, dns.MaxMsgSize)
+
+	for {
+		n, err := s.conn.Read(buf)
+		if err != nil {
+			s.close(err)
+			return
+		}
+
+		m := &dns.Msg{}
+		if err := m.Unpack(buf[:n]); err != nil {
+			continue
+		}
+
+		if opt := llqOption(m); opt != nil && opt.Opcode == llqOpcodeEvent {
+			s.event(m, opt)
+			continue
+		}
+
+		s.m.Lock()
+		res := s.pending[m.Id]
+		s.m.Unlock()
+
+		if res != nil {
+			select {
+			case res <- m:
+			default:
+			}
+		}
+	}
+}
+
+// event handles an LLQ event message, which notifies the client of changes to
+// the answers of a long-lived query.
+//
+// See https://www.rfc-editor.org/rfc/rfc8764#section-6.
+func (s *llqSession) event(m *dns.Msg, opt *dns.EDNS0_LLQ) {
+	logAttrs(
+		s.logger,
+		slog.LevelDebug,
+		"received DNS long-lived query event",
+		slog.String("server", s.conn.RemoteAddr().String()),
+		slog.Int("answers", len(m.Answer)),
+	)
+
+	// Acknowledge the event by echoing the message header, question and LLQ
+	// option back to the server.
+	ack := &dns.Msg{}
+	ack.Id = m.Id
+	ack.Response = true
+	ack.Question = m.Question
+	ack.Extra = []dns.RR{newLLQOPT(opt.Opcode, opt.Id, time.Duration(opt.LeaseLife)*time.Second)}
+	_ = s.write(ack)
+
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// close terminates the session with the given error. It is a no-op if the
+// session has already been terminated.
+func (s *llqSession) close(err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.err != nil {
+		return
+	}
+
+	s.err = err
+	close(s.done)
+	s.conn.Close()
+
+	for _, l := range s.queries {
+		l.Cancel()
+	}
+}
+
+// newLLQRequest returns a query for q that contains an LLQ option.
+func newLLQRequest(
+	q dns.Question,
+	opcode uint16,
+	id uint64,
+	lease time.Duration,
+) *dns.Msg {
+	m := &dns.Msg{}
+	m.Id = dns.Id()
+	m.Question = []dns.Question{q}
+	m.Extra = []dns.RR{newLLQOPT(opcode, id, lease)}
+	return m
+}
+
+// newLLQOPT returns an OPT record containing an LLQ option.
+func newLLQOPT(opcode uint16, id uint64, lease time.Duration) *dns.OPT {
+	opt := &dns.OPT{
+		Hdr: dns.RR_Header{
+			Name:   ".",
+			Rrtype: dns.TypeOPT,
+		},
+	}
+	opt.SetUDPSize(dns.DefaultMsgSize)
+	opt.Option = append(
+		opt.Option,
+		&dns.EDNS0_LLQ{
+			Code:      dns.EDNS0LLQ,
+			Version:   llqVersion,
+			Opcode:    opcode,
+			Id:        id,
+			LeaseLife: uint32(lease.Seconds()),
+		},
+	)
+	return opt
+}
+
+// llqOption returns the LLQ option in m, or nil if there is none.
+func llqOption(m *dns.Msg) *dns.EDNS0_LLQ {
+	if opt := m.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if o, ok := o.(*dns.EDNS0_LLQ); ok {
+				return o
+			}
+		}
+	}
+	return nil
+}
+
+// llqErrorString returns a human-readable representation of an LLQ error code.
+func llqErrorString(code uint16) string {
+	if s, ok := llqErrorToString[code]; ok {
+		return s
+	}
+	return strconv.Itoa(int(code))
+}
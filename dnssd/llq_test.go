@@ -0,0 +1,14 @@
+package dnssd_test
+
+import (
+	. "github.com/dogmatiq/dissolve/dnssd"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("func AbsoluteLLQServiceName()", func() {
+	It("returns the name that is queried to discover the DNS long-lived query server for a zone", func() {
+		d := AbsoluteLLQServiceName("example.org")
+		Expect(d).To(Equal("_dns-llq._udp.example.org."))
+	})
+})
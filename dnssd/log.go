@@ -0,0 +1,47 @@
+package dnssd
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/miekg/dns"
+)
+
+// logAttrs logs a message to logger at the given level.
+//
+// It is a no-op if logger is nil, which allows each type that supports logging
+// to leave its Logger field unset.
+func logAttrs(
+	logger *slog.Logger,
+	level slog.Level,
+	msg string,
+	attrs ...slog.Attr,
+) {
+	if logger == nil {
+		return
+	}
+
+	logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
+
+// instanceAttrs returns the log attributes that describe a service instance.
+func instanceAttrs(n ServiceInstanceName) []slog.Attr {
+	return []slog.Attr{
+		slog.String("instance", n.Name),
+		slog.String("service_type", n.ServiceType),
+		slog.String("domain", n.Domain),
+	}
+}
+
+// questionAttrs returns the log attributes that describe a DNS question.
+func questionAttrs(q dns.Question) []slog.Attr {
+	return []slog.Attr{
+		slog.String("qname", q.Name),
+		slog.String("qtype", dns.TypeToString[q.Qtype]),
+	}
+}
+
+// rcodeAttr returns the log attribute that describes a DNS response code.
+func rcodeAttr(rcode int) slog.Attr {
+	return slog.String("rcode", dns.RcodeToString[rcode])
+}
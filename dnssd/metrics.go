@@ -0,0 +1,146 @@
+package dnssd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ResolverMetrics is an interface for recording metrics about the queries that
+// a UnicastResolver sends to DNS servers.
+//
+// Each attempt to query a server results in exactly one call to either
+// ResponseReceived() or QueryFailed(). Responses that are served from a cache
+// or by middleware are not reported.
+//
+// Implementations must be safe for concurrent use.
+type ResolverMetrics interface {
+	// ResponseReceived is called when a response is received from a server.
+	//
+	// server is the address of the server, or the URL of the DoH endpoint.
+	// rcode is the response code, such as dns.RcodeServerFailure or
+	// dns.RcodeNameError. latency is the time taken to connect to the server
+	// and receive its response.
+	ResponseReceived(server string, q dns.Question, rcode int, latency time.Duration)
+
+	// QueryFailed is called when no response is received from a server.
+	//
+	// If the attempt timed out, err is context.DeadlineExceeded.
+	QueryFailed(server string, q dns.Question, err error, latency time.Duration)
+}
+
+// observeResponse records the result of a single attempt to query a server
+// using r.Metrics, if it is non-nil.
+func (r *UnicastResolver) observeResponse(
+	ctx context.Context,
+	server string,
+	q dns.Question,
+	start time.Time,
+	res *dns.Msg,
+	err error,
+) {
+	if r.Metrics == nil {
+		return
+	}
+
+	latency := time.Since(start)
+
+	if res != nil {
+		r.Metrics.ResponseReceived(server, q, res.Rcode, latency)
+		return
+	}
+
+	// Report timeouts consistently, regardless of whether they were caused by
+	// the context deadline or by a deadline on the connection itself.
+	var netErr net.Error
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	} else if errors.As(err, &netErr) && netErr.Timeout() {
+		err = context.DeadlineExceeded
+	}
+
+	r.Metrics.QueryFailed(server, q, err, latency)
+}
+
+// ServerMetrics is an interface for recording metrics about the queries that a
+// UnicastServer serves and the service instances that it advertises.
+//
+// Implementations must be safe for concurrent use. They must not call the
+// server's methods, as some of the methods of ServerMetrics are called while
+// the server's internal lock is held.
+type ServerMetrics interface {
+	// QueryServed is called when the server sends a response to a query.
+	//
+	// q is the question from the query, which is the zero-value if the query
+	// did not contain exactly one question. rcode is the response code, such
+	// as dns.RcodeSuccess or dns.RcodeRefused. answers is the number of
+	// records in the answer section of the response, and size is the size of
+	// the response in bytes.
+	//
+	// It is not called for DNS UPDATE messages.
+	QueryServed(q dns.Question, rcode int, answers, size int)
+
+	// LockWaited is called each time the server acquires the lock that
+	// protects its records, with the time spent waiting for it.
+	//
+	// exclusive is true if the lock was acquired to modify the records, or
+	// false if it was acquired to serve a query. Long waits indicate
+	// contention between queries and changes to the advertised instances.
+	LockWaited(exclusive bool, d time.Duration)
+
+	// InstancesChanged is called when a service instance is advertised or
+	// removed, with the number of instances that are now advertised.
+	InstancesChanged(count int)
+}
+
+// lock acquires s.m for writing, reporting the time spent waiting for it to
+// s.Metrics, if it is non-nil.
+func (s *UnicastServer) lock() {
+	if s.Metrics == nil {
+		s.m.Lock()
+		return
+	}
+
+	start := time.Now()
+	s.m.Lock()
+	s.Metrics.LockWaited(true, time.Since(start))
+}
+
+// rlock acquires s.m for reading, reporting the time spent waiting for it to
+// s.Metrics, if it is non-nil.
+func (s *UnicastServer) rlock() {
+	if s.Metrics == nil {
+		s.m.RLock()
+		return
+	}
+
+	start := time.Now()
+	s.m.RLock()
+	s.Metrics.LockWaited(false, time.Since(start))
+}
+
+// observeQuery records the response to a query using s.Metrics, if it is
+// non-nil.
+func (s *UnicastServer) observeQuery(req, res *dns.Msg) {
+	if s.Metrics == nil || req.Opcode == dns.OpcodeUpdate {
+		return
+	}
+
+	var q dns.Question
+	if len(req.Question) == 1 {
+		q = req.Question[0]
+	}
+
+	s.Metrics.QueryServed(q, res.Rcode, len(res.Answer), res.Len())
+}
+
+// observeInstances records the number of advertised instances using
+// s.Metrics, if it is non-nil. It assumes s.m is already locked.
+func (s *UnicastServer) observeInstances() {
+	if s.Metrics != nil {
+		s.Metrics.InstancesChanged(len(s.instances))
+	}
+}
@@ -0,0 +1,61 @@
+package dnssd
+
+import (
+	"context"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// QueryFunc is a function that performs a single DNS query on behalf of a
+// UnicastResolver.
+//
+// It returns the first authoritative response to q, which is either a
+// successful response or an NXDOMAIN response. It returns a nil response if
+// none of the servers had an answer.
+type QueryFunc func(ctx context.Context, q dns.Question) (*dns.Msg, error)
+
+// QueryMiddleware is a function that wraps a QueryFunc to add behavior before
+// or after each query, such as logging, caching or policy enforcement.
+//
+// The middleware may return a response or error without calling next.
+type QueryMiddleware func(next QueryFunc) QueryFunc
+
+// withMiddleware returns a QueryFunc that calls q via the given middleware.
+//
+// The first middleware is the outermost, that is, it is the first to be
+// invoked for each query.
+func withMiddleware(q QueryFunc, middleware []QueryMiddleware) QueryFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		q = middleware[i](q)
+	}
+	return q
+}
+
+// ResponseFunc is a function that builds the response to a DNS query on
+// behalf of a UnicastServer.
+//
+// client is the address of the client that sent req, or nil if the query was
+// made using UnicastServer.Exchange(). It returns nil if the query should not
+// be answered.
+type ResponseFunc func(ctx context.Context, client net.Addr, req *dns.Msg) *dns.Msg
+
+// ResponseMiddleware is a function that wraps a ResponseFunc to add behavior
+// before or after the response to each query is built, such as
+// authentication, per-client filtering or response rewriting.
+//
+// The middleware may return a response without calling next, or return nil to
+// drop the query without sending a response.
+type ResponseMiddleware func(next ResponseFunc) ResponseFunc
+
+// withResponseMiddleware returns a ResponseFunc that calls f via the given
+// middleware.
+//
+// The first middleware is the outermost, that is, it is the first to be
+// invoked for each query.
+func withResponseMiddleware(f ResponseFunc, middleware []ResponseMiddleware) ResponseFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		f = middleware[i](f)
+	}
+	return f
+}
@@ -0,0 +1,150 @@
+package dnssd
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// negativeCache is a cache of negative responses, that is, responses that
+// indicate that a name does not exist (NXDOMAIN) or that it has no records of
+// the requested type (NODATA).
+//
+// See https://www.rfc-editor.org/rfc/rfc2308.
+type negativeCache struct {
+	m       sync.Mutex
+	entries map[negativeCacheKey]time.Time
+}
+
+// maxNegativeCacheEntries is the maximum number of entries in a negativeCache.
+//
+// If the cache is full after expired entries are evicted, the entry that
+// expires soonest is evicted to make room for the new one.
+const maxNegativeCacheEntries = 1024
+
+// negativeCacheKey is the key of an entry in a negativeCache.
+//
+// NXDOMAIN responses apply to all record types, and are stored with a
+// questionType of dns.TypeNone.
+type negativeCacheKey struct {
+	name         string
+	questionType uint16
+}
+
+// Has returns true if there is an unexpired negative response for the given
+// name and question type.
+func (c *negativeCache) Has(name string, questionType uint16) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	name = dns.CanonicalName(name)
+	now := time.Now()
+
+	for _, k := range []negativeCacheKey{
+		{name, dns.TypeNone},
+		{name, questionType},
+	} {
+		if expiresAt, ok := c.entries[k]; ok {
+			if now.Before(expiresAt) {
+				return true
+			}
+			delete(c.entries, k)
+		}
+	}
+
+	return false
+}
+
+// Add adds a negative response to the cache.
+//
+// The response is cached for the negative TTL described by the SOA record in
+// its authority section. If there is no SOA record the response is not
+// cached.
+func (c *negativeCache) Add(name string, questionType uint16, res *dns.Msg) {
+	ttl, ok := negativeTTL(res)
+	if !ok {
+		return
+	}
+
+	k := negativeCacheKey{dns.CanonicalName(name), questionType}
+	if res.Rcode == dns.RcodeNameError {
+		k.questionType = dns.TypeNone
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.entries == nil {
+		c.entries = map[negativeCacheKey]time.Time{}
+	}
+
+	now := time.Now()
+
+	if _, ok := c.entries[k]; !ok && len(c.entries) >= maxNegativeCacheEntries {
+		c.evict(now)
+	}
+
+	c.entries[k] = now.Add(ttl)
+}
+
+// evict removes all expired entries from the cache. If none have expired, the
+// entry that expires soonest is removed. It assumes c.m is already locked.
+func (c *negativeCache) evict(now time.Time) {
+	var (
+		soonest   negativeCacheKey
+		expiresAt time.Time
+	)
+
+	for k, t := range c.entries {
+		if !now.Before(t) {
+			delete(c.entries, k)
+		} else if expiresAt.IsZero() || t.Before(expiresAt) {
+			soonest, expiresAt = k, t
+		}
+	}
+
+	if len(c.entries) >= maxNegativeCacheEntries {
+		delete(c.entries, soonest)
+	}
+}
+
+type bypassNegativeCacheKey struct{}
+
+// withoutNegativeCache returns a context that causes the queries made using it
+// to ignore any cached negative responses.
+//
+// It is used when the caller knows that the records may have changed, such as
+// after a change notification, in which case a cached negative response would
+// hide the new records until it expires. The responses to such queries are
+// still added to the cache.
+func withoutNegativeCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassNegativeCacheKey{}, true)
+}
+
+// bypassNegativeCache returns true if ctx was returned by
+// withoutNegativeCache().
+func bypassNegativeCache(ctx context.Context) bool {
+	bypass, _ := ctx.Value(bypassNegativeCacheKey{}).(bool)
+	return bypass
+}
+
+// negativeTTL returns the duration for which a negative response may be
+// cached, which is the lesser of the TTL of the SOA record and its MINIMUM
+// field.
+//
+// See https://www.rfc-editor.org/rfc/rfc2308#section-5.
+func negativeTTL(res *dns.Msg) (time.Duration, bool) {
+	for _, rr := range res.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl := min(soa.Hdr.Ttl, soa.Minttl)
+			if ttl == 0 {
+				return 0, false
+			}
+			return time.Duration(ttl) * time.Second, true
+		}
+	}
+
+	return 0, false
+}
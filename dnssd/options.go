@@ -1,6 +1,10 @@
 package dnssd
 
-import "net"
+import (
+	"context"
+	"net"
+	"time"
+)
 
 // AdvertiseOption is an option that changes the behavior of how a service
 // instance is advertised.
@@ -38,3 +42,70 @@ func resolveAdvertiseOptions(options []AdvertiseOption) advertiseOptions {
 
 	return opts
 }
+
+// LookupOption is an option that changes the behavior of a single operation
+// performed by a UnicastResolver.
+type LookupOption func(*lookupOptions)
+
+// WithQueryTimeout is a LookupOption that sets the maximum amount of time to
+// wait for a response to each DNS query made during the operation.
+//
+// It takes precedence over the timeout in the resolver's Config.
+func WithQueryTimeout(d time.Duration) LookupOption {
+	return func(opts *lookupOptions) {
+		opts.QueryTimeout = d
+	}
+}
+
+// WithOverallDeadline is a LookupOption that sets the time by which the
+// entire operation must complete, regardless of how many DNS queries it makes.
+func WithOverallDeadline(t time.Time) LookupOption {
+	return func(opts *lookupOptions) {
+		opts.OverallDeadline = t
+	}
+}
+
+type lookupOptions struct {
+	QueryTimeout    time.Duration
+	OverallDeadline time.Time
+}
+
+func resolveLookupOptions(options []LookupOption) lookupOptions {
+	var opts lookupOptions
+
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	return opts
+}
+
+// queryTimeoutKey is the context key used to store the query timeout set by
+// WithQueryTimeout().
+type queryTimeoutKey struct{}
+
+// withLookupOptions returns a context that applies the given options to all
+// of the queries made using it.
+func withLookupOptions(
+	ctx context.Context,
+	options []LookupOption,
+) (context.Context, context.CancelFunc) {
+	opts := resolveLookupOptions(options)
+	cancel := context.CancelFunc(func() {})
+
+	if !opts.OverallDeadline.IsZero() {
+		ctx, cancel = context.WithDeadline(ctx, opts.OverallDeadline)
+	}
+
+	if opts.QueryTimeout > 0 {
+		ctx = context.WithValue(ctx, queryTimeoutKey{}, opts.QueryTimeout)
+	}
+
+	return ctx, cancel
+}
+
+// queryTimeout returns the query timeout set by WithQueryTimeout(), if any.
+func queryTimeout(ctx context.Context) (time.Duration, bool) {
+	d, ok := ctx.Value(queryTimeoutKey{}).(time.Duration)
+	return d, ok
+}
@@ -0,0 +1,43 @@
+package dnssd
+
+import "fmt"
+
+// ParseMode controls how malformed data is handled when it is received from
+// the network.
+type ParseMode int
+
+const (
+	// LenientParsing skips malformed data, such as an invalid TXT attribute or
+	// an instance name that can not be parsed. Each skipped value is reported
+	// to the logger, if one is configured.
+	//
+	// This is the default mode. It allows software that is exposed to
+	// untrusted networks to make the best use of the valid data it receives.
+	LenientParsing ParseMode = iota
+
+	// StrictParsing fails the entire operation if any malformed data is
+	// encountered.
+	//
+	// It is useful for tooling that needs to verify that DNS-SD records are
+	// correct.
+	StrictParsing
+)
+
+// OutOfDomainError is an error that indicates that a PTR record refers to a
+// name outside of the domain that was queried.
+//
+// Such records are a sign of misconfiguration or an attempt to poison the
+// resolver. They are treated as malformed data, and are therefore skipped
+// under LenientParsing, or cause the operation to fail with this error under
+// StrictParsing.
+type OutOfDomainError struct {
+	// Target is the target of the PTR record.
+	Target string
+
+	// Domain is the domain that the target was expected to be within.
+	Domain string
+}
+
+func (e *OutOfDomainError) Error() string {
+	return fmt.Sprintf("%q is not within the %q domain", e.Target, e.Domain)
+}
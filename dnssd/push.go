@@ -0,0 +1,562 @@
+package dnssd
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DNS Stateful Operations (DSO) constants.
+//
+// See https://www.rfc-editor.org/rfc/rfc8490.
+const (
+	dsoOpcode                  = 6
+	dsoRcodeTypeNotImplemented = 11
+
+	dsoTypeKeepalive   = 0x0001
+	dsoTypeRetryDelay  = 0x0002
+	dsoTypeSubscribe   = 0x0040
+	dsoTypePush        = 0x0041
+	dsoTypeUnsubscribe = 0x0042
+
+	dsoHeaderSize = 12
+)
+
+// pushKeepaliveInterval is the keepalive interval requested from DNS Push
+// Notification servers.
+const pushKeepaliveInterval = 5 * time.Minute
+
+// AbsolutePushServiceName returns the absolute DNS name that is queried to
+// discover the DNS Push Notification server for a zone.
+//
+// See https://www.rfc-editor.org/rfc/rfc8765#section-6.1.
+func AbsolutePushServiceName(zone string) string {
+	return AbsoluteInstanceEnumerationDomain("_dns-push-tls._tcp", zone)
+}
+
+// lookupPushServer returns the address of the DNS Push Notification server
+// for the given zone.
+//
+// ok is false if the zone does not advertise a push server.
+func (r *UnicastResolver) lookupPushServer(
+	ctx context.Context,
+	zone string,
+) (host string, addr string, ok bool, err error) {
+	srv, ok, err := r.lookupSRV(ctx, AbsolutePushServiceName(zone))
+	if !ok || err != nil {
+		return "", "", false, err
+	}
+
+	host = strings.TrimSuffix(srv.Target, ".")
+	return host, net.JoinHostPort(host, strconv.Itoa(int(srv.Port))), true, nil
+}
+
+// pushSession is a client-side DNS Push Notification session.
+//
+// See https://www.rfc-editor.org/rfc/rfc8765.
+type pushSession struct {
+	conn   net.Conn
+	logger *slog.Logger
+
+	// changed receives a value whenever the server pushes a change to any of
+	// the subscribed records. It is buffered such that multiple changes that
+	// arrive before the receiver is ready are coalesced.
+	changed chan struct{}
+
+	// done is closed when the session is terminated.
+	done chan struct{}
+
+	writeM sync.Mutex
+
+	m       sync.Mutex
+	err     error
+	nextID  uint16
+	pending map[uint16]chan dsoMessage
+	subs    map[dns.Question]uint16
+}
+
+var _ changeNotifier = (*pushSession)(nil)
+
+// dsoMessage is a DNS Stateful Operations message.
+type dsoMessage struct {
+	ID       uint16
+	Response bool
+	Rcode    int
+	TLVs     []dsoTLV
+}
+
+// dsoTLV is a type-length-value element within a DSO message.
+type dsoTLV struct {
+	Type uint16
+	Data []byte
+}
+
+// dialPushSession establishes a DNS Push Notification session with the server
+// at addr.
+func dialPushSession(
+	ctx context.Context,
+	config *tls.Config,
+	host, addr string,
+	logger *slog.Logger,
+) (*pushSession, error) {
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName = host
+	}
+
+	dialer := &tls.Dialer{Config: config}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &pushSession{
+		conn:    conn,
+		logger:  logger,
+		changed: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		pending: map[uint16]chan dsoMessage{},
+		subs:    map[dns.Question]uint16{},
+	}
+
+	go s.read()
+
+	// The session is established by the first successful request. We use a
+	// Keepalive request so that we learn the server's keepalive interval.
+	//
+	// See https://www.rfc-editor.org/rfc/rfc8490#section-5.1.
+	res, err := s.request(ctx, newKeepaliveTLV(pushKeepaliveInterval), nil)
+	if err != nil {
+		s.close(err)
+		return nil, err
+	}
+
+	interval := pushKeepaliveInterval
+	for _, tlv := range res.TLVs {
+		if tlv.Type == dsoTypeKeepalive && len(tlv.Data) == 8 {
+			ms := binary.BigEndian.Uint32(tlv.Data[4:])
+			interval = time.Duration(ms) * time.Millisecond
+		}
+	}
+
+	go s.keepalive(interval)
+
+	return s, nil
+}
+
+// Changed returns a channel that receives a value whenever the server pushes
+// a change to any of the subscribed records.
+func (s *pushSession) Changed() <-chan struct{} {
+	return s.changed
+}
+
+// Done returns a channel that is closed when the session is terminated.
+func (s *pushSession) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the error that caused the session to terminate.
+func (s *pushSession) Err() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.err
+}
+
+// Close terminates the session.
+func (s *pushSession) Close() {
+	s.close(errors.New("session closed"))
+}
+
+// Sync subscribes to each of the questions in qs, and unsubscribes from any
+// existing subscriptions that are not in qs.
+func (s *pushSession) Sync(ctx context.Context, qs []dns.Question) error {
+	want := make(map[dns.Question]struct{}, len(qs))
+	for _, q := range qs {
+		want[q] = struct{}{}
+	}
+
+	s.m.Lock()
+	var unsubscribe []uint16
+	for q, id := range s.subs {
+		if _, ok := want[q]; !ok {
+			delete(s.subs, q)
+			unsubscribe = append(unsubscribe, id)
+		}
+	}
+	s.m.Unlock()
+
+	for _, id := range unsubscribe {
+		if err := s.write(dsoMessage{TLVs: []dsoTLV{newUnsubscribeTLV(id)}}); err != nil {
+			return err
+		}
+	}
+
+	for q := range want {
+		s.m.Lock()
+		_, ok := s.subs[q]
+		s.m.Unlock()
+
+		if ok {
+			continue
+		}
+
+		if err := s.subscribe(ctx, q); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// subscribe subscribes to changes to the records that match q.
+//
+// See https://www.rfc-editor.org/rfc/rfc8765#section-6.2.
+func (s *pushSession) subscribe(ctx context.Context, q dns.Question) error {
+	tlv, err := newSubscribeTLV(q)
+	if err != nil {
+		return err
+	}
+
+	// The subscription is recorded before the message ID of the request is
+	// released, such that the ID can not be reused while the subscription is
+	// active.
+	res, err := s.request(ctx, tlv, func(res dsoMessage) {
+		if res.Rcode == dns.RcodeSuccess {
+			s.subs[q] = res.ID
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if res.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf(
+			"DNS push server rejected subscription to %s %s: %s",
+			q.Name,
+			dns.TypeToString[q.Qtype],
+			rcodeString(res.Rcode),
+		)
+	}
+
+	return nil
+}
+
+// request sends a DSO request containing tlv as its primary TLV and waits for
+// the response.
+//
+// If accept is non-nil it is called with the response while s.m is locked,
+// before the message ID of the request is released.
+func (s *pushSession) request(
+	ctx context.Context,
+	tlv dsoTLV,
+	accept func(dsoMessage),
+) (dsoMessage, error) {
+	res := make(chan dsoMessage, 1)
+
+	s.m.Lock()
+	if s.err != nil {
+		s.m.Unlock()
+		return dsoMessage{}, s.err
+	}
+
+	// Message IDs must be non-zero for requests, and must not be reused while
+	// a request is outstanding or a subscription is active.
+	//
+	// See https://www.rfc-editor.org/rfc/rfc8765#section-6.2.
+	s.nextID++
+	for s.nextID == 0 || s.inUse(s.nextID) {
+		s.nextID++
+	}
+	id := s.nextID
+	s.pending[id] = res
+	s.m.Unlock()
+
+	defer func() {
+		s.m.Lock()
+		delete(s.pending, id)
+		s.m.Unlock()
+	}()
+
+	if err := s.write(dsoMessage{ID: id, TLVs: []dsoTLV{tlv}}); err != nil {
+		return dsoMessage{}, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return dsoMessage{}, ctx.Err()
+	case <-s.done:
+		return dsoMessage{}, s.Err()
+	case m := <-res:
+		if accept != nil {
+			s.m.Lock()
+			accept(m)
+			s.m.Unlock()
+		}
+		return m, nil
+	}
+}
+
+// inUse returns true if id is the message ID of an outstanding request or an
+// active subscription. It assumes s.m is already locked.
+func (s *pushSession) inUse(id uint16) bool {
+	if s.pending[id] != nil {
+		return true
+	}
+
+	for _, x := range s.subs {
+		if x == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// write sends a DSO message to the server.
+func (s *pushSession) write(m dsoMessage) error {
+	size := dsoHeaderSize
+	for _, tlv := range m.TLVs {
+		size += 4 + len(tlv.Data)
+	}
+
+	buf := make([]byte, 2+size)
+	binary.BigEndian.PutUint16(buf, uint16(size))
+
+	flags := uint16(dsoOpcode)<<11 | uint16(m.Rcode&0xF)
+	if m.Response {
+		flags |= 1 << 15
+	}
+
+	binary.BigEndian.PutUint16(buf[2:], m.ID)
+	binary.BigEndian.PutUint16(buf[4:], flags)
+
+	n := 2 + dsoHeaderSize
+	for _, tlv := range m.TLVs {
+		binary.BigEndian.PutUint16(buf[n:], tlv.Type)
+		binary.BigEndian.PutUint16(buf[n+2:], uint16(len(tlv.Data)))
+		n += 4 + copy(buf[n+4:], tlv.Data)
+	}
+
+	s.writeM.Lock()
+	defer s.writeM.Unlock()
+
+	if _, err := s.conn.Write(buf); err != nil {
+		s.close(err)
+		return err
+	}
+
+	return nil
+}
+
+// read reads messages from the server until the session is terminated.
+func (s *pushSession) read() {
+	for {
+		m, err := readDSOMessage(s.conn)
+		if err != nil {
+			s.close(err)
+			return
+		}
+
+		if m.Response {
+			s.m.Lock()
+			res := s.pending[m.ID]
+			s.m.Unlock()
+
+			// The channel is buffered to hold the one expected response, so
+			// any duplicate or late response is discarded rather than
+			// blocking the reader.
+			if res != nil {
+				select {
+				case res <- m:
+				default:
+				}
+			}
+
+			continue
+		}
+
+		if m.ID != 0 {
+			// The server has made a request of its own. There are no
+			// server-initiated requests that we support.
+			_ = s.write(dsoMessage{
+				ID:       m.ID,
+				Response: true,
+				Rcode:    dsoRcodeTypeNotImplemented,
+			})
+			continue
+		}
+
+		if len(m.TLVs) == 0 {
+			continue
+		}
+
+		switch m.TLVs[0].Type {
+		case dsoTypePush:
+			logAttrs(
+				s.logger,
+				slog.LevelDebug,
+				"received DNS push notification",
+				slog.String("server", s.conn.RemoteAddr().String()),
+			)
+
+			select {
+			case s.changed <- struct{}{}:
+			default:
+			}
+
+		case dsoTypeRetryDelay:
+			s.close(errors.New("DNS push server requested that the session be closed"))
+			return
+		}
+	}
+}
+
+// keepalive sends a keepalive request at the given interval until the
+// session is terminated.
+func (s *pushSession) keepalive(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			_, err := s.request(ctx, newKeepaliveTLV(interval), nil)
+			cancel()
+
+			if err != nil {
+				s.close(err)
+				return
+			}
+		}
+	}
+}
+
+// close terminates the session with the given error. It is a no-op if the
+// session has already been terminated.
+func (s *pushSession) close(err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.err != nil {
+		return
+	}
+
+	s.err = err
+	close(s.done)
+	s.conn.Close()
+}
+
+// readDSOMessage reads a single length-prefixed DSO message from r.
+func readDSOMessage(r io.Reader) (dsoMessage, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return dsoMessage{}, err
+	}
+
+	buf := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return dsoMessage{}, err
+	}
+
+	if len(buf) < dsoHeaderSize {
+		return dsoMessage{}, errors.New("DSO message is shorter than the DNS header")
+	}
+
+	flags := binary.BigEndian.Uint16(buf[2:])
+	if int(flags>>11)&0xF != dsoOpcode {
+		return dsoMessage{}, errors.New("received a message that is not a DSO message")
+	}
+
+	m := dsoMessage{
+		ID:       binary.BigEndian.Uint16(buf),
+		Response: flags&(1<<15) != 0,
+		Rcode:    int(flags & 0xF),
+	}
+
+	data := buf[dsoHeaderSize:]
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return dsoMessage{}, errors.New("DSO TLV is truncated")
+		}
+
+		t := binary.BigEndian.Uint16(data)
+		n := int(binary.BigEndian.Uint16(data[2:]))
+		data = data[4:]
+
+		if len(data) < n {
+			return dsoMessage{}, errors.New("DSO TLV is truncated")
+		}
+
+		m.TLVs = append(m.TLVs, dsoTLV{t, data[:n]})
+		data = data[n:]
+	}
+
+	return m, nil
+}
+
+// newKeepaliveTLV returns a Keepalive TLV that requests the given keepalive
+// interval.
+//
+// See https://www.rfc-editor.org/rfc/rfc8490#section-7.1.
+func newKeepaliveTLV(interval time.Duration) dsoTLV {
+	ms := uint32(interval.Milliseconds())
+
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint32(data, ms*2) // inactivity timeout
+	binary.BigEndian.PutUint32(data[4:], ms)
+
+	return dsoTLV{dsoTypeKeepalive, data}
+}
+
+// newSubscribeTLV returns a SUBSCRIBE TLV for the given question.
+//
+// See https://www.rfc-editor.org/rfc/rfc8765#section-6.2.
+func newSubscribeTLV(q dns.Question) (dsoTLV, error) {
+	data := make([]byte, 255+4)
+
+	n, err := dns.PackDomainName(q.Name, data, 0, nil, false)
+	if err != nil {
+		return dsoTLV{}, err
+	}
+
+	binary.BigEndian.PutUint16(data[n:], q.Qtype)
+	binary.BigEndian.PutUint16(data[n+2:], q.Qclass)
+
+	return dsoTLV{dsoTypeSubscribe, data[:n+4]}, nil
+}
+
+// newUnsubscribeTLV returns an UNSUBSCRIBE TLV that cancels the subscription
+// that was created by the SUBSCRIBE request with the given message ID.
+//
+// See https://www.rfc-editor.org/rfc/rfc8765#section-6.4.
+func newUnsubscribeTLV(id uint16) dsoTLV {
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, id)
+	return dsoTLV{dsoTypeUnsubscribe, data}
+}
+
+// rcodeString returns a human-readable representation of a DNS response code.
+func rcodeString(rcode int) string {
+	if s, ok := dns.RcodeToString[rcode]; ok {
+		return s
+	}
+	return strconv.Itoa(rcode)
+}
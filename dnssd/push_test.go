@@ -0,0 +1,14 @@
+package dnssd_test
+
+import (
+	. "github.com/dogmatiq/dissolve/dnssd"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("func AbsolutePushServiceName()", func() {
+	It("returns the name that is queried to discover the DNS push server for a zone", func() {
+		d := AbsolutePushServiceName("example.org")
+		Expect(d).To(Equal("_dns-push-tls._tcp.example.org."))
+	})
+})
@@ -0,0 +1,36 @@
+package dnssd
+
+import "github.com/miekg/dns"
+
+// RcodePolicy controls the response code that a UnicastServer uses when it
+// has no records for a queried name.
+type RcodePolicy int
+
+const (
+	// NXDomainPolicy responds with NXDOMAIN to queries for any name that has
+	// no records, regardless of whether the server is authoritative for that
+	// name.
+	//
+	// This is the default policy.
+	NXDomainPolicy RcodePolicy = iota
+
+	// AuthoritativePolicy responds with REFUSED to queries for names that are
+	// not within any of the server's zones, and with NODATA (NOERROR with an
+	// empty answer section) to queries for names that have no records of
+	// their own but do have descendants that have records, such as
+	// "_tcp.example.org" (known as "empty non-terminals").
+	//
+	// NXDOMAIN is only used for names within a zone that do not exist. This
+	// is the behavior expected of an authoritative server. In particular,
+	// resolvers that implement RFC 8020 treat an NXDOMAIN response as proof
+	// that no names exist below the queried name.
+	//
+	// See https://www.rfc-editor.org/rfc/rfc8020.
+	AuthoritativePolicy
+)
+
+// hasDescendants returns true if there are records at any name below name. It
+// assumes s.m is already locked for reading.
+func (s *UnicastServer) hasDescendants(name string) bool {
+	return s.descendants[dns.CanonicalName(name)] != 0
+}
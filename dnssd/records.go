@@ -2,6 +2,7 @@ package dnssd
 
 import (
 	"net"
+	"strings"
 	"time"
 
 	"github.com/dogmatiq/dissolve/internal/domainname"
@@ -25,6 +26,8 @@ func NewRecords(i ServiceInstance, options ...AdvertiseOption) []dns.RR {
 		records = append(records, rr)
 	}
 
+	records = append(records, NewServiceBindingRecords(i)...)
+
 	for _, subType := range opts.ServiceSubTypes {
 		records = append(records, NewServiceSubTypePTRRecord(i, subType))
 	}
@@ -98,7 +101,7 @@ func NewTXTRecords(i ServiceInstance) []*dns.TXT {
 				records,
 				&dns.TXT{
 					Hdr: header,
-					Txt: attrs.ToTXT(),
+					Txt: escapeTXT(attrs.ToTXT()),
 				},
 			)
 		}
@@ -120,6 +123,63 @@ func NewTXTRecords(i ServiceInstance) []*dns.TXT {
 	return records
 }
 
+// NewServiceBindingRecords returns the SVCB and HTTPS records that describe
+// the bindings in i.Bindings.
+//
+// It returns one record for each binding, which is an HTTPS record if
+// binding.HTTPS is true, or an SVCB record otherwise.
+//
+// See https://www.rfc-editor.org/rfc/rfc9460.
+func NewServiceBindingRecords(i ServiceInstance) []dns.RR {
+	var records []dns.RR
+
+	for _, b := range i.Bindings {
+		svcb := dns.SVCB{
+			Hdr: dns.RR_Header{
+				Name:   AbsoluteServiceInstanceName(i.Name, i.ServiceType, i.Domain),
+				Rrtype: dns.TypeSVCB,
+				Class:  dns.ClassINET,
+				Ttl:    ttlInSeconds(i.TTL),
+			},
+			Priority: b.Priority,
+			Target:   ".",
+		}
+
+		if b.Target != "" {
+			svcb.Target = domainname.Absolute(b.Target)
+		}
+
+		// Parameters are meaningless for alias bindings. Keys must be in
+		// ascending order.
+		if b.Priority != 0 {
+			if len(b.ALPN) != 0 {
+				svcb.Value = append(svcb.Value, &dns.SVCBAlpn{Alpn: b.ALPN})
+			}
+
+			if b.Port != 0 {
+				svcb.Value = append(svcb.Value, &dns.SVCBPort{Port: b.Port})
+			}
+
+			if len(b.IPv4Hints) != 0 {
+				svcb.Value = append(svcb.Value, &dns.SVCBIPv4Hint{Hint: b.IPv4Hints})
+			}
+
+			if len(b.IPv6Hints) != 0 {
+				svcb.Value = append(svcb.Value, &dns.SVCBIPv6Hint{Hint: b.IPv6Hints})
+			}
+		}
+
+		if b.HTTPS {
+			svcb.Hdr.Rrtype = dns.TypeHTTPS
+			records = append(records, &dns.HTTPS{SVCB: svcb})
+		} else {
+			records = append(records, &svcb)
+		}
+	}
+
+	return records
+}
+
 // NewServiceSubTypePTRRecord returns a PTR record used to advertise a service
 // was providing a specific service sub-type.
 //
@@ -208,3 +268,15 @@ func ttlInSeconds(ttl time.Duration) uint32 {
 
 	return uint32(ttl.Seconds())
 }
+
+// escapeTXT escapes the backslashes in each of the given TXT strings.
+//
+// The miekg/dns package treats TXT strings as being in "presentation format",
+// in which a backslash introduces an escape sequence, so any literal
+// backslashes must themselves be escaped.
+func escapeTXT(pairs []string) []string {
+	for i, p := range pairs {
+		pairs[i] = strings.ReplaceAll(p, `\`, `\\`)
+	}
+	return pairs
+}
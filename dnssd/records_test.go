@@ -185,6 +185,18 @@ var _ = Context("DNS records", func() {
 			))
 		})
 
+		It("escapes backslashes in attribute values", func() {
+			instance.Attributes = []Attributes{
+				NewAttributes().
+					WithPair("<key>", []byte(`C:\dir`)),
+			}
+
+			rec := NewTXTRecords(instance)
+
+			Expect(rec).To(HaveLen(1))
+			Expect(rec[0].Txt).To(Equal([]string{`<key>=C:\\dir`}))
+		})
+
 		It("ignores empty attribute collections", func() {
 			instance.Attributes = append(instance.Attributes, Attributes{})
 			rec := NewTXTRecords(instance)
@@ -237,6 +249,47 @@ var _ = Context("DNS records", func() {
 		})
 	})
 
+	Describe("func NewServiceBindingRecords()", func() {
+		It("returns the expected SVCB and HTTPS records", func() {
+			instance.Bindings = []ServiceBinding{
+				{
+					HTTPS:     true,
+					Priority:  1,
+					ALPN:      []string{"h3", "h2"},
+					Port:      8443,
+					IPv4Hints: []net.IP{net.IPv4(192, 168, 20, 1)},
+					IPv6Hints: []net.IP{net.ParseIP("fe80::1ce5:3c8b:36f:53cf")},
+				},
+				{
+					Priority: 0,
+					Target:   "alias.example.com",
+					ALPN:     []string{"ignored"},
+				},
+			}
+
+			records := NewServiceBindingRecords(instance)
+
+			var expect []dns.RR
+			for _, r := range []string{
+				`Boardroom\ Printer\.._http._tcp.example.org. 120 IN HTTPS 1 . alpn="h3,h2" port="8443" ipv4hint="192.168.20.1" ipv6hint="fe80::1ce5:3c8b:36f:53cf"`,
+				`Boardroom\ Printer\.._http._tcp.example.org. 120 IN SVCB 0 alias.example.com.`,
+			} {
+				rr, err := dns.NewRR(r)
+				Expect(err).ShouldNot(HaveOccurred())
+				expect = append(expect, rr)
+			}
+
+			Expect(records).To(HaveLen(len(expect)))
+			for i, rr := range records {
+				Expect(dns.IsDuplicate(rr, expect[i])).To(BeTrue(), rr.String())
+			}
+		})
+
+		It("returns no records if there are no bindings", func() {
+			Expect(NewServiceBindingRecords(instance)).To(BeEmpty())
+		})
+	})
+
 	Describe("func NewARecord()", func() {
 		It("returns the expected A record for an IPv4 address", func() {
 			rec := NewARecord(instance, net.IPv4(192, 168, 20, 1).To4())
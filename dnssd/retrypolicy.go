@@ -0,0 +1,96 @@
+package dnssd
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy controls how a UnicastResolver retries queries against a single
+// server when no response is received.
+//
+// The zero value sends each query to each server exactly once.
+type RetryPolicy struct {
+	// Attempts is the maximum number of times that each query is sent to each
+	// server.
+	//
+	// If it is non-positive, each query is sent once.
+	Attempts int
+
+	// Timeout is the amount of time to wait for a response to each attempt.
+	//
+	// If it is non-positive, each attempt is bounded only by the overall
+	// timeout in the resolver's Config, if any.
+	Timeout time.Duration
+
+	// Backoff is the delay before the second attempt. The delay is doubled
+	// for each subsequent attempt.
+	//
+	// If it is non-positive, there is no delay between attempts.
+	Backoff time.Duration
+
+	// MaxBackoff is the maximum delay between attempts.
+	//
+	// If it is non-positive, the delay is not capped.
+	MaxBackoff time.Duration
+
+	// Jitter is the proportion of each delay that is randomized, in the range
+	// [0, 1].
+	//
+	// For example, a Jitter of 0.2 varies each delay by up to 20% in either
+	// direction.
+	Jitter float64
+}
+
+// attempts returns the number of times each query is sent to each server.
+func (p RetryPolicy) attempts() int {
+	if p.Attempts > 0 {
+		return p.Attempts
+	}
+	return 1
+}
+
+// delay returns the delay before the attempt that follows the given
+// (zero-based) attempt.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	if p.Backoff <= 0 {
+		return 0
+	}
+
+	d := p.Backoff
+	for i := 0; i < attempt; i++ {
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			break
+		}
+		d *= 2
+	}
+
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+
+	if j := min(max(p.Jitter, 0), 1); j > 0 {
+		d += time.Duration(float64(d) * j * (2*rand.Float64() - 1))
+	}
+
+	return d
+}
+
+// wait blocks until the delay before the attempt that follows the given
+// (zero-based) attempt has elapsed, or ctx is canceled.
+func (p RetryPolicy) wait(ctx context.Context, attempt int) error {
+	d := p.delay(attempt)
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
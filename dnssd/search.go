@@ -0,0 +1,68 @@
+package dnssd
+
+import (
+	"strings"
+)
+
+// searchDomains returns the domains to query, in order, when the caller
+// supplies the given domain.
+//
+// The domain is expanded using the search list in r.Config, honoring its
+// "ndots" option, in the same way as a conventional stub resolver:
+//
+//   - a domain with a trailing dot is absolute and is never expanded
+//   - an empty domain is expanded to each of the search domains
+//   - a domain with at least Ndots dots is tried as-is before being expanded
+//   - any other domain is expanded before being tried as-is
+//
+// The returned domains never have a trailing dot.
+func (r *UnicastResolver) searchDomains(domain string) []string {
+	if strings.HasSuffix(domain, ".") {
+		return []string{strings.TrimSuffix(domain, ".")}
+	}
+
+	if r.Config == nil || len(r.Config.Search) == 0 {
+		return []string{domain}
+	}
+
+	expanded := make([]string, 0, len(r.Config.Search)+1)
+	for _, s := range r.Config.Search {
+		s = strings.TrimSuffix(s, ".")
+		if domain != "" {
+			s = domain + "." + s
+		}
+		expanded = append(expanded, s)
+	}
+
+	if domain == "" {
+		return expanded
+	}
+
+	if strings.Count(domain, ".") >= r.Config.Ndots {
+		return append([]string{domain}, expanded...)
+	}
+
+	return append(expanded, domain)
+}
+
+// search calls fn with each of the domains returned by r.searchDomains(),
+// stopping at the first domain for which fn returns ok.
+func search[T any](
+	r *UnicastResolver,
+	domain string,
+	fn func(domain string) (T, bool, error),
+) (T, bool, error) {
+	var zero T
+
+	for _, d := range r.searchDomains(domain) {
+		result, ok, err := fn(d)
+		if err != nil {
+			return zero, false, err
+		}
+		if ok {
+			return result, true, nil
+		}
+	}
+
+	return zero, false, nil
+}
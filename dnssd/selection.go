@@ -0,0 +1,57 @@
+package dnssd
+
+import (
+	"math/rand/v2"
+)
+
+// SelectInstance chooses the instance that a client should contact first from
+// a pool of instances that offer the same service.
+//
+// It implements the selection algorithm described in RFC 2782. The instance is
+// chosen from those with the lowest Priority value. Within that priority, the
+// likelihood of each instance being chosen is proportional to its Weight.
+// Instances with a weight of zero are only chosen if all other instances in
+// the same priority also have a weight of zero.
+//
+// ok is false if instances is empty.
+//
+// See https://www.rfc-editor.org/rfc/rfc2782.
+func SelectInstance(instances []ServiceInstance) (_ ServiceInstance, ok bool) {
+	if len(instances) == 0 {
+		return ServiceInstance{}, false
+	}
+
+	var (
+		candidates  []ServiceInstance
+		totalWeight int
+	)
+
+	for _, inst := range instances {
+		if len(candidates) != 0 && inst.Priority > candidates[0].Priority {
+			continue
+		}
+
+		if len(candidates) != 0 && inst.Priority < candidates[0].Priority {
+			candidates = candidates[:0]
+			totalWeight = 0
+		}
+
+		candidates = append(candidates, inst)
+		totalWeight += int(inst.Weight)
+	}
+
+	if totalWeight == 0 {
+		return candidates[rand.IntN(len(candidates))], true
+	}
+
+	n := rand.IntN(totalWeight)
+
+	for _, inst := range candidates {
+		n -= int(inst.Weight)
+		if n < 0 {
+			return inst, true
+		}
+	}
+
+	panic("unreachable")
+}
@@ -0,0 +1,80 @@
+package dnssd_test
+
+import (
+	. "github.com/dogmatiq/dissolve/dnssd"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("func SelectInstance()", func() {
+	instance := func(name string, priority, weight uint16) ServiceInstance {
+		return ServiceInstance{
+			ServiceInstanceName: ServiceInstanceName{
+				Name:        name,
+				ServiceType: "_http._tcp",
+				Domain:      "example.org",
+			},
+			Priority: priority,
+			Weight:   weight,
+		}
+	}
+
+	// selectMany calls SelectInstance() repeatedly and returns the number of
+	// times each instance was selected.
+	selectMany := func(instances ...ServiceInstance) map[string]int {
+		counts := map[string]int{}
+		for range 1000 {
+			i, ok := SelectInstance(instances)
+			Expect(ok).To(BeTrue())
+			counts[i.Name]++
+		}
+		return counts
+	}
+
+	It("selects only from the instances with the lowest priority value", func() {
+		counts := selectMany(
+			instance("A", 20, 100),
+			instance("B", 10, 1),
+			instance("C", 10, 1),
+			instance("D", 30, 100),
+		)
+
+		Expect(counts).To(HaveLen(2))
+		Expect(counts).To(HaveKey("B"))
+		Expect(counts).To(HaveKey("C"))
+	})
+
+	It("selects instances in proportion to their weight", func() {
+		counts := selectMany(
+			instance("A", 10, 90),
+			instance("B", 10, 10),
+		)
+
+		Expect(counts["A"]).To(BeNumerically(">", counts["B"]*3))
+		Expect(counts["B"]).To(BeNumerically(">", 0))
+	})
+
+	It("does not select instances with a weight of zero if others have a non-zero weight", func() {
+		counts := selectMany(
+			instance("A", 10, 0),
+			instance("B", 10, 1),
+		)
+
+		Expect(counts).To(Equal(map[string]int{"B": 1000}))
+	})
+
+	It("selects uniformly if all instances have a weight of zero", func() {
+		counts := selectMany(
+			instance("A", 10, 0),
+			instance("B", 10, 0),
+		)
+
+		Expect(counts["A"]).To(BeNumerically(">", 0))
+		Expect(counts["B"]).To(BeNumerically(">", 0))
+	})
+
+	It("returns false if there are no instances", func() {
+		_, ok := SelectInstance(nil)
+		Expect(ok).To(BeFalse())
+	})
+})
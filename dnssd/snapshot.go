@@ -0,0 +1,272 @@
+package dnssd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// snapshotVersion is the version of the format produced by
+// UnicastServer.Snapshot().
+const snapshotVersion = 1
+
+// Snapshot returns a binary representation of the service instances that are
+// currently advertised by the server, including any records that were added
+// by the options passed to Advertise(), and of the hosts and aliases that are
+// advertised using AdvertiseHost() and AdvertiseAlias().
+//
+// The snapshot can be passed to Restore() to resume advertising the same
+// instances, hosts and aliases, for example after the process restarts.
+//
+// For instances that were added by DNS UPDATE messages, the snapshot also
+// includes the remaining lease and the SIG(0) key that signed the update.
+func (s *UnicastServer) Snapshot() ([]byte, error) {
+	s.rlock()
+	defer s.m.RUnlock()
+
+	data := []byte{snapshotVersion}
+
+	// Each instance is encoded as a DNS message with the instance name in the
+	// question section and its records in the answer section. Hosts and
+	// aliases are encoded in the same way, but are distinguished by the
+	// question type.
+	//
+	// Instances that were added by DNS UPDATE messages have an OPT record in
+	// the additional section, containing an EDNS(0) Update Lease option if
+	// the instance has a lease, and the KEY record that signed the update, if
+	// any, in the authority section.
+	for _, name := range sortedKeys(s.instances) {
+		m := newSnapshotEntry(name, dns.TypeANY, s.instances[name].records)
+
+		if l, ok := s.updated[name]; ok {
+			opt := &dns.OPT{
+				Hdr: dns.RR_Header{
+					Name:   ".",
+					Rrtype: dns.TypeOPT,
+				},
+			}
+
+			if l.Timer != nil {
+				// Round the remaining lease up, so that an instance whose lease
+				// is about to expire is not restored without a lease.
+				remaining := max(time.Until(l.Expires), time.Second)
+				opt.Option = append(
+					opt.Option,
+					&dns.EDNS0_UL{
+						Code:  dns.EDNS0UL,
+						Lease: uint32((remaining + time.Second - 1) / time.Second),
+					},
+				)
+			}
+
+			m.Extra = append(m.Extra, opt)
+
+			if l.Key != nil {
+				m.Ns = append(m.Ns, l.Key)
+			}
+		}
+
+		var err error
+		data, err = appendSnapshotEntry(data, m)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range sortedKeys(s.hosts) {
+		var err error
+		data, err = appendSnapshotEntry(data, newSnapshotEntry(name, dns.TypeA, s.hosts[name]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range sortedKeys(s.aliases) {
+		var err error
+		data, err = appendSnapshotEntry(data, newSnapshotEntry(name, dns.TypeCNAME, []dns.RR{s.aliases[name]}))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// newSnapshotEntry returns the DNS message used to encode the given records
+// in a snapshot.
+func newSnapshotEntry(name string, qtype uint16, records []dns.RR) *dns.Msg {
+	return &dns.Msg{
+		Question: []dns.Question{
+			{
+				Name:   name,
+				Qtype:  qtype,
+				Qclass: dns.ClassINET,
+			},
+		},
+		Answer: records,
+	}
+}
+
+// appendSnapshotEntry appends an entry containing the given message to a
+// snapshot.
+func appendSnapshotEntry(data []byte, m *dns.Msg) ([]byte, error) {
+	buf, err := m.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode records for %q: %w", m.Question[0].Name, err)
+	}
+
+	data = binary.BigEndian.AppendUint32(data, uint32(len(buf)))
+	return append(data, buf...), nil
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Restore starts advertising the service instances, hosts and aliases in a
+// snapshot produced by Snapshot().
+//
+// Instances, hosts and aliases in the snapshot replace any that are already
+// advertised with the same name. Others are unaffected. Instances that were
+// added by DNS UPDATE messages may still be updated by the clients that added
+// them, and are removed when the lease that remained at the time of the
+// snapshot expires, measured from the time they are restored. If the snapshot is
+// invalid, or contains an alias whose name has other advertised records, an
+// error is returned and no instances are advertised.
+func (s *UnicastServer) Restore(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("snapshot is empty")
+	}
+
+	if data[0] != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version: %d", data[0])
+	}
+
+	data = data[1:]
+
+	type snapshotInstance struct {
+		Name    ServiceInstanceName
+		Records []dns.RR
+		Updated bool
+		Lease   time.Duration
+		Key     *dns.KEY
+	}
+
+	var (
+		instances []snapshotInstance
+		hosts     = map[string][]dns.RR{}
+		aliases   []*dns.CNAME
+	)
+
+	for len(data) != 0 {
+		if len(data) < 4 {
+			return errors.New("snapshot is truncated")
+		}
+
+		n := binary.BigEndian.Uint32(data)
+		data = data[4:]
+
+		if uint32(len(data)) < n {
+			return errors.New("snapshot is truncated")
+		}
+
+		m := &dns.Msg{}
+		if err := m.Unpack(data[:n]); err != nil {
+			return fmt.Errorf("unable to decode snapshot: %w", err)
+		}
+		data = data[n:]
+
+		if len(m.Question) != 1 {
+			return errors.New("unable to decode snapshot: expected exactly one question")
+		}
+
+		switch m.Question[0].Qtype {
+		case dns.TypeA:
+			hosts[m.Question[0].Name] = m.Answer
+			continue
+		case dns.TypeCNAME:
+			if len(m.Answer) != 1 || m.Answer[0].Header().Rrtype != dns.TypeCNAME {
+				return fmt.Errorf("unable to decode snapshot: %q does not contain exactly one CNAME record", m.Question[0].Name)
+			}
+			aliases = append(aliases, m.Answer[0].(*dns.CNAME))
+			continue
+		}
+
+		name, ok := parseServiceInstanceName(m.Question[0].Name)
+		if !ok {
+			return fmt.Errorf("unable to decode snapshot: %q is not a service instance name", m.Question[0].Name)
+		}
+
+		i := snapshotInstance{
+			Name:    name,
+			Records: m.Answer,
+		}
+
+		if opt := m.IsEdns0(); opt != nil {
+			i.Updated = true
+
+			for _, o := range opt.Option {
+				if ul, ok := o.(*dns.EDNS0_UL); ok {
+					i.Lease = time.Duration(ul.Lease) * time.Second
+				}
+			}
+
+			for _, rr := range m.Ns {
+				if k, ok := rr.(*dns.KEY); ok {
+					i.Key = k
+				}
+			}
+		}
+
+		instances = append(instances, i)
+	}
+
+	s.lock()
+	defer s.m.Unlock()
+
+	for _, rr := range aliases {
+		if err := s.checkAlias(rr.Hdr.Name); err != nil {
+			return err
+		}
+	}
+
+	for _, i := range instances {
+		if err := s.checkNotAlias(i.Records); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range sortedKeys(hosts) {
+		if err := s.checkNotAlias(hosts[name]); err != nil {
+			return err
+		}
+	}
+
+	for _, i := range instances {
+		s.advertiseRecords(i.Name, i.Records)
+
+		if i.Updated {
+			s.leaseInstance(i.Name, i.Lease, i.Key)
+		}
+	}
+
+	for _, name := range sortedKeys(hosts) {
+		s.advertiseHostRecords(name, hosts[name])
+	}
+
+	for _, rr := range aliases {
+		s.advertiseAliasRecord(rr)
+	}
+
+	return nil
+}
@@ -0,0 +1,357 @@
+package dnssd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/dogmatiq/dissolve/internal/domainname"
+	"github.com/miekg/dns"
+)
+
+const (
+	// DefaultSRPLease is the default lease time requested for service
+	// instances registered via SRP.
+	DefaultSRPLease = 2 * time.Hour
+
+	// DefaultSRPKeyLease is the default lease time requested for the KEY
+	// records that reserve the names registered via SRP.
+	DefaultSRPKeyLease = 14 * 24 * time.Hour
+
+	// DefaultSRPTimeout is the default amount of time to wait for the
+	// registrar to respond to each attempt to send an update.
+	DefaultSRPTimeout = 2 * time.Second
+)
+
+// SRPClient registers service instances with a registrar using the DNS-SD
+// Service Registration Protocol (SRP).
+//
+// Each registration is sent as a DNS UPDATE message that is signed with Key
+// using SIG(0). The registrar associates the registered names with the public
+// key, such that only the holder of the private key can update them.
+//
+// See https://datatracker.ietf.org/doc/draft-ietf-dnssd-srp/.
+type SRPClient struct {
+	// Client is the DNS client used to send updates to the registrar.
+	//
+	// If it is nil, a default UDP client is used. Client.Timeout, or
+	// Client.ReadTimeout if it is not set, is the amount of time to wait for
+	// a response before the update is resent. Updates sent over UDP are sent
+	// up to 3 times. If neither is set, DefaultSRPTimeout is used.
+	Client *dns.Client
+
+	// Key is the private key used to sign updates. It must be an ECDSA key on
+	// the P-256 curve.
+	//
+	// The same key must be used for all updates to the same names.
+	Key *ecdsa.PrivateKey
+
+	// Lease is the lease time to request for registered instances.
+	//
+	// If it is non-positive, DefaultSRPLease is used instead.
+	Lease time.Duration
+
+	// KeyLease is the lease time to request for the KEY records that reserve
+	// the registered names.
+	//
+	// If it is non-positive, DefaultSRPKeyLease is used instead.
+	KeyLease time.Duration
+}
+
+// Register registers a service instance with the SRP registrar at addr.
+//
+// i.Domain is the zone in which the instance is registered, typically
+// "default.service.arpa". i.TargetHost is registered as the instance's host,
+// along with any addresses specified using WithIPAddress(). Registrations must
+// be renewed by calling Register() again before the lease expires.
+func (c *SRPClient) Register(
+	ctx context.Context,
+	addr string,
+	i ServiceInstance,
+	options ...AdvertiseOption,
+) error {
+	return c.update(ctx, addr, i, options, c.lease())
+}
+
+// Remove removes a service instance from the SRP registrar at addr.
+//
+// It sends the same update as Register(), but requests a lease of zero, which
+// the registrar interprets as a request to remove the records. The names remain
+// reserved for Key until the key lease expires.
+func (c *SRPClient) Remove(
+	ctx context.Context,
+	addr string,
+	i ServiceInstance,
+	options ...AdvertiseOption,
+) error {
+	return c.update(ctx, addr, i, options, 0)
+}
+
+// update sends a signed SRP update to the registrar at addr.
+func (c *SRPClient) update(
+	ctx context.Context,
+	addr string,
+	i ServiceInstance,
+	options []AdvertiseOption,
+	lease time.Duration,
+) error {
+	if c.Key == nil || c.Key.Curve != elliptic.P256() {
+		return errors.New("SRP client requires an ECDSA P-256 key")
+	}
+
+	req, key := newSRPUpdate(i, options, &c.Key.PublicKey, lease, c.keyLease())
+
+	now := time.Now()
+	sig := &dns.SIG{
+		RRSIG: dns.RRSIG{
+			Algorithm:  dns.ECDSAP256SHA256,
+			SignerName: key.Hdr.Name,
+			KeyTag:     key.KeyTag(),
+			Inception:  uint32(now.Add(-5 * time.Minute).Unix()),
+			Expiration: uint32(now.Add(5 * time.Minute).Unix()),
+		},
+	}
+
+	buf, err := sig.Sign(c.Key, req)
+	if err != nil {
+		return fmt.Errorf("unable to sign SRP update: %w", err)
+	}
+
+	client := c.Client
+	if client == nil {
+		client = &dns.Client{}
+	}
+
+	conn, err := client.DialContext(ctx, addr)
+	if err != nil {
+		return err
+	}
+
+	// Create a context that is always canceled when we are finished with the
+	// registrar, and close the connection when it is canceled. This terminates
+	// the exchange if the parent ctx is canceled for any reason.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	// UDP messages may be lost, so the update is retransmitted if there is no
+	// response within the timeout. Retransmitting is safe because applying
+	// the same update more than once has the same effect as applying it once.
+	attempts := 1
+	if isUDP(client.Net) {
+		attempts = srpAttempts
+	}
+
+	for n := 1; ; n++ {
+		res, err := c.attempt(ctx, conn, buf, srpTimeout(client), req.Id)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if n < attempts && isTimeout(err) {
+				continue
+			}
+
+			return err
+		}
+
+		if res.Rcode != dns.RcodeSuccess {
+			return fmt.Errorf("SRP registrar rejected update: %s", dns.RcodeToString[res.Rcode])
+		}
+
+		return nil
+	}
+}
+
+// attempt sends the packed update in buf to the registrar and waits up to
+// timeout for the response to the message with the given ID.
+func (c *SRPClient) attempt(
+	ctx context.Context,
+	conn *dns.Conn,
+	buf []byte,
+	timeout time.Duration,
+	id uint16,
+) (*dns.Msg, error) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	for {
+		res, err := conn.ReadMsg()
+		if err != nil {
+			return nil, err
+		}
+
+		if res.Id == id {
+			return res, nil
+		}
+	}
+}
+
+// srpAttempts is the number of times an update is sent over UDP before giving
+// up if no response is received.
+const srpAttempts = 3
+
+// srpTimeout returns the amount of time to wait for the response to each
+// attempt to send an update using client.
+func srpTimeout(client *dns.Client) time.Duration {
+	if client.Timeout > 0 {
+		return client.Timeout
+	}
+
+	if client.ReadTimeout > 0 {
+		return client.ReadTimeout
+	}
+
+	return DefaultSRPTimeout
+}
+
+// isUDP returns true if network, which is the value of dns.Client.Net, refers
+// to UDP.
+func isUDP(network string) bool {
+	return network == "" || strings.HasPrefix(network, "udp")
+}
+
+// isTimeout returns true if err is a network timeout.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// lease returns the instance lease time to request.
+func (c *SRPClient) lease() time.Duration {
+	if c.Lease > 0 {
+		return c.Lease
+	}
+	return DefaultSRPLease
+}
+
+// keyLease returns the key lease time to request.
+func (c *SRPClient) keyLease() time.Duration {
+	if c.KeyLease > 0 {
+		return c.KeyLease
+	}
+	return DefaultSRPKeyLease
+}
+
+// newSRPUpdate returns an unsigned SRP update message that registers i, along
+// with the KEY record that identifies pub.
+//
+// See https://datatracker.ietf.org/doc/html/draft-ietf-dnssd-srp#section-2.3.
+func newSRPUpdate(
+	i ServiceInstance,
+	options []AdvertiseOption,
+	pub *ecdsa.PublicKey,
+	lease, keyLease time.Duration,
+) (*dns.Msg, *dns.KEY) {
+	opts := resolveAdvertiseOptions(options)
+	host := domainname.Absolute(i.TargetHost)
+
+	instanceKey := newSRPKeyRecord(i.Absolute(), pub, i.TTL)
+	hostKey := newSRPKeyRecord(host, pub, i.TTL)
+
+	m := &dns.Msg{}
+	m.SetUpdate(domainname.Absolute(i.Domain))
+
+	// Service Discovery Instruction: add the PTR records that allow the
+	// instance to be found by browsing.
+	discovery := []dns.RR{NewPTRRecord(i)}
+	for _, subType := range opts.ServiceSubTypes {
+		discovery = append(discovery, NewServiceSubTypePTRRecord(i, subType))
+	}
+
+	m.Insert(discovery)
+
+	// Service Description Instruction: replace all records at the service
+	// instance name.
+	description := []dns.RR{NewSRVRecord(i)}
+	for _, rr := range NewTXTRecords(i) {
+		description = append(description, rr)
+	}
+	description = append(description, instanceKey)
+
+	m.RemoveName([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: i.Absolute()}}})
+	m.Insert(description)
+
+	// Host Description Instruction: replace all records at the host name.
+	var hostDescription []dns.RR
+	for _, ip := range opts.IPAddresses {
+		if ip.To4() != nil {
+			hostDescription = append(hostDescription, NewARecord(i, ip))
+		} else if ip.To16() != nil {
+			hostDescription = append(hostDescription, NewAAAARecord(i, ip))
+		}
+	}
+	hostDescription = append(hostDescription, hostKey)
+
+	m.RemoveName([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: host}}})
+	m.Insert(hostDescription)
+
+	// Request the lease times using the EDNS(0) Update Lease option.
+	//
+	// See https://datatracker.ietf.org/doc/draft-ietf-dnssd-update-lease/.
+	opt := &dns.OPT{
+		Hdr: dns.RR_Header{
+			Name:   ".",
+			Rrtype: dns.TypeOPT,
+		},
+	}
+	opt.SetUDPSize(dns.DefaultMsgSize)
+	opt.Option = append(
+		opt.Option,
+		&dns.EDNS0_UL{
+			Code:     dns.EDNS0UL,
+			Lease:    uint32(lease.Seconds()),
+			KeyLease: uint32(keyLease.Seconds()),
+		},
+	)
+	m.Extra = append(m.Extra, opt)
+
+	return m, hostKey
+}
+
+// newSRPKeyRecord returns a KEY record containing the given public key.
+func newSRPKeyRecord(name string, pub *ecdsa.PublicKey, ttl time.Duration) *dns.KEY {
+	// The uncompressed point encoding is a 0x04 byte followed by the X and Y
+	// coordinates. DNSSEC uses only the coordinates.
+	//
+	// See https://www.rfc-editor.org/rfc/rfc6605#section-4.
+	var point []byte
+	if k, err := pub.ECDH(); err == nil {
+		point = k.Bytes()[1:]
+	}
+
+	return &dns.KEY{
+		DNSKEY: dns.DNSKEY{
+			Hdr: dns.RR_Header{
+				Name:   name,
+				Rrtype: dns.TypeKEY,
+				Class:  dns.ClassINET,
+				Ttl:    ttlInSeconds(ttl),
+			},
+			Protocol:  3,
+			Algorithm: dns.ECDSAP256SHA256,
+			PublicKey: base64.StdEncoding.EncodeToString(point),
+		},
+	}
+}
@@ -0,0 +1,235 @@
+package dnssd_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net"
+	"sync/atomic"
+	"time"
+
+	. "github.com/dogmatiq/dissolve/dnssd"
+	"github.com/miekg/dns"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Context("SRPClient", func() {
+	var (
+		ctx       context.Context
+		cancel    context.CancelFunc
+		registrar net.PacketConn
+		rcode     atomic.Int32
+		drop      atomic.Int32
+		requests  chan []byte
+		client    *SRPClient
+		instance  ServiceInstance
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+
+		var err error
+		registrar, err = net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		rcode.Store(dns.RcodeSuccess)
+		drop.Store(0)
+		requests = make(chan []byte, 10)
+
+		go func(conn net.PacketConn, requests chan<- []byte) {
+			buf := make([]byte, 65535)
+
+			for {
+				n, addr, err := conn.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+
+				req := &dns.Msg{}
+				if err := req.Unpack(buf[:n]); err != nil {
+					continue
+				}
+
+				requests <- append([]byte(nil), buf[:n]...)
+
+				// Simulate lost messages by not responding.
+				if drop.Add(-1) >= 0 {
+					continue
+				}
+
+				res := &dns.Msg{}
+				res.SetRcode(req, int(rcode.Load()))
+				data, _ := res.Pack()
+				_, _ = conn.WriteTo(data, addr)
+			}
+		}(registrar, requests)
+
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		client = &SRPClient{
+			Key: key,
+		}
+
+		instance = ServiceInstance{
+			ServiceInstanceName: ServiceInstanceName{
+				Name:        "Instance A",
+				ServiceType: "_http._tcp",
+				Domain:      "default.service.arpa",
+			},
+			TargetHost: "host-a.default.service.arpa",
+			TargetPort: 12345,
+			Priority:   10,
+			Weight:     20,
+			Attributes: AttributeCollection{
+				NewAttributes().
+					WithPair("<key>", []byte("<value>")),
+			},
+		}
+	})
+
+	AfterEach(func() {
+		cancel()
+		registrar.Close()
+	})
+
+	// receive returns the next request received by the registrar, and verifies
+	// its SIG(0) signature.
+	receive := func() *dns.Msg {
+		var buf []byte
+		Eventually(requests).Should(Receive(&buf))
+
+		req := &dns.Msg{}
+		err := req.Unpack(buf)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		sig, ok := req.Extra[len(req.Extra)-1].(*dns.SIG)
+		Expect(ok).To(BeTrue(), "last additional record must be the SIG(0) signature")
+
+		var key *dns.KEY
+		for _, rr := range req.Ns {
+			if k, ok := rr.(*dns.KEY); ok && k.Hdr.Name == sig.SignerName {
+				key = k
+			}
+		}
+		Expect(key).NotTo(BeNil(), "update must contain the signer's KEY record")
+
+		err = sig.Verify(key, buf)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		return req
+	}
+
+	Describe("func Register()", func() {
+		It("sends a signed update containing the instance's records", func() {
+			err := client.Register(
+				ctx,
+				registrar.LocalAddr().String(),
+				instance,
+				WithServiceSubType("_printer"),
+				WithIPAddress(net.IPv4(192, 168, 20, 1)),
+			)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			req := receive()
+			Expect(req.Opcode).To(Equal(dns.OpcodeUpdate))
+			Expect(req.Question[0].Name).To(Equal("default.service.arpa."))
+
+			var updates []string
+			for _, rr := range req.Ns {
+				if _, ok := rr.(*dns.KEY); ok {
+					updates = append(updates, rr.Header().Name+" KEY")
+				} else {
+					updates = append(updates, rr.String())
+				}
+			}
+
+			Expect(updates).To(Equal([]string{
+				`_http._tcp.default.service.arpa.	120	IN	PTR	Instance\ A._http._tcp.default.service.arpa.`,
+				`_printer._sub._http._tcp.default.service.arpa.	120	IN	PTR	Instance\ A._http._tcp.default.service.arpa.`,
+				`Instance\ A._http._tcp.default.service.arpa.	0	CLASS255	ANY	`,
+				`Instance\ A._http._tcp.default.service.arpa.	120	IN	SRV	10 20 12345 host-a.default.service.arpa.`,
+				`Instance\ A._http._tcp.default.service.arpa.	120	IN	TXT	"<key>=<value>"`,
+				`Instance\ A._http._tcp.default.service.arpa. KEY`,
+				`host-a.default.service.arpa.	0	CLASS255	ANY	`,
+				`host-a.default.service.arpa.	120	IN	A	192.168.20.1`,
+				`host-a.default.service.arpa. KEY`,
+			}))
+
+			opt := req.IsEdns0()
+			Expect(opt).NotTo(BeNil())
+			Expect(opt.Option).To(ContainElement(
+				WithTransform(
+					func(o *dns.EDNS0_UL) []uint32 { return []uint32{o.Lease, o.KeyLease} },
+					Equal([]uint32{
+						uint32(DefaultSRPLease.Seconds()),
+						uint32(DefaultSRPKeyLease.Seconds()),
+					}),
+				),
+			))
+		})
+
+		It("returns an error if the registrar rejects the update", func() {
+			rcode.Store(dns.RcodeRefused)
+
+			err := client.Register(ctx, registrar.LocalAddr().String(), instance)
+			Expect(err).To(MatchError("SRP registrar rejected update: REFUSED"))
+		})
+
+		It("resends the update if the registrar does not respond", func() {
+			drop.Store(1)
+			client.Client = &dns.Client{Timeout: 50 * time.Millisecond}
+
+			err := client.Register(ctx, registrar.LocalAddr().String(), instance)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			first := receive()
+			second := receive()
+			Expect(second.Id).To(Equal(first.Id))
+		})
+
+		It("returns an error if the registrar never responds", func() {
+			drop.Store(3)
+			client.Client = &dns.Client{Timeout: 50 * time.Millisecond}
+
+			err := client.Register(context.Background(), registrar.LocalAddr().String(), instance)
+			Expect(err).Should(HaveOccurred())
+			Expect(err.(net.Error).Timeout()).To(BeTrue())
+
+			for range 3 {
+				receive()
+			}
+			Consistently(requests, 100*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("returns an error if the key is not a P-256 key", func() {
+			key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+			Expect(err).ShouldNot(HaveOccurred())
+			client.Key = key
+
+			err = client.Register(ctx, registrar.LocalAddr().String(), instance)
+			Expect(err).To(MatchError("SRP client requires an ECDSA P-256 key"))
+		})
+	})
+
+	Describe("func Remove()", func() {
+		It("sends a signed update with a zero lease", func() {
+			client.KeyLease = 1 * time.Hour
+
+			err := client.Remove(ctx, registrar.LocalAddr().String(), instance)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			req := receive()
+			opt := req.IsEdns0()
+			Expect(opt).NotTo(BeNil())
+			Expect(opt.Option).To(ContainElement(
+				WithTransform(
+					func(o *dns.EDNS0_UL) []uint32 { return []uint32{o.Lease, o.KeyLease} },
+					Equal([]uint32{0, 3600}),
+				),
+			))
+		})
+	})
+})
@@ -0,0 +1,169 @@
+package dnssd
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// StreamServiceTypes finds all of the service types advertised within a single
+// domain, and calls fn for each one.
+//
+// It is equivalent to EnumerateServiceTypes(), except that each service type is
+// passed to fn as soon as it is read from the response, instead of being
+// collected into a slice. If fn returns an error, enumeration stops and that
+// error is returned.
+func (r *UnicastResolver) StreamServiceTypes(
+	ctx context.Context,
+	domain string,
+	fn func(serviceType string) error,
+	options ...LookupOption,
+) error {
+	ctx, cancel := withLookupOptions(ctx, options)
+	defer cancel()
+
+	_, _, err := search(r, domain, func(domain string) (struct{}, bool, error) {
+		n, _, err := r.walkServiceTypes(ctx, domain, fn)
+		return struct{}{}, n != 0, err
+	})
+
+	return err
+}
+
+// StreamInstances finds all of the instances of a given service type that are
+// advertised within a single domain, and calls fn for each one.
+//
+// It is equivalent to EnumerateInstances(), except that each instance name is
+// passed to fn as soon as it is read from the response, instead of being
+// collected into a slice. If fn returns an error, enumeration stops and that
+// error is returned.
+func (r *UnicastResolver) StreamInstances(
+	ctx context.Context,
+	serviceType, domain string,
+	fn func(instance string) error,
+	options ...LookupOption,
+) error {
+	ctx, cancel := withLookupOptions(ctx, options)
+	defer cancel()
+
+	_, _, err := search(r, domain, func(domain string) (struct{}, bool, error) {
+		n, _, err := r.walkInstances(
+			ctx,
+			AbsoluteInstanceEnumerationDomain(serviceType, domain),
+			serviceType,
+			domain,
+			fn,
+		)
+		return struct{}{}, n != 0, err
+	})
+
+	return err
+}
+
+// StreamInstancesBySubType finds all of the instances of a given service
+// sub-type that are advertised within a single domain, and calls fn for each
+// one.
+//
+// It is equivalent to EnumerateInstancesBySubType(), except that each instance
+// name is passed to fn as soon as it is read from the response, instead of
+// being collected into a slice. If fn returns an error, enumeration stops and
+// that error is returned.
+func (r *UnicastResolver) StreamInstancesBySubType(
+	ctx context.Context,
+	subType, serviceType, domain string,
+	fn func(instance string) error,
+	options ...LookupOption,
+) error {
+	ctx, cancel := withLookupOptions(ctx, options)
+	defer cancel()
+
+	_, _, err := search(r, domain, func(domain string) (struct{}, bool, error) {
+		n, _, err := r.walkInstances(
+			ctx,
+			AbsoluteSelectiveInstanceEnumerationDomain(subType, serviceType, domain),
+			serviceType,
+			domain,
+			fn,
+		)
+		return struct{}{}, n != 0, err
+	})
+
+	return err
+}
+
+// StreamInstanceDetails finds all of the instances of a given service type that
+// are advertised within a single domain, looks up the details of each one and
+// calls fn with each instance as soon as its details are available.
+//
+// It is equivalent to EnumerateInstanceDetails(), except that the instances are
+// passed to fn in the order that their lookups complete, instead of being
+// collected into a slice. At most r.LookupConcurrency instances are looked up
+// at once. fn is never called concurrently.
+//
+// If fn returns an error, any pending lookups are canceled and that error is
+// returned.
+func (r *UnicastResolver) StreamInstanceDetails(
+	ctx context.Context,
+	serviceType, domain string,
+	fn func(ServiceInstance) error,
+	options ...LookupOption,
+) error {
+	ctx, cancel := withLookupOptions(ctx, options)
+	defer cancel()
+
+	_, _, err := search(r, domain, func(domain string) (struct{}, bool, error) {
+		instances, _, err := r.enumerateInstances(
+			ctx,
+			AbsoluteInstanceEnumerationDomain(serviceType, domain),
+			serviceType,
+			domain,
+		)
+		if err != nil || len(instances) == 0 {
+			return struct{}{}, false, err
+		}
+
+		return struct{}{}, true, r.streamInstanceDetails(ctx, instances, serviceType, domain, fn)
+	})
+
+	return err
+}
+
+// streamInstanceDetails looks up the details about the given instances
+// concurrently, calling fn with each instance that can be resolved.
+func (r *UnicastResolver) streamInstanceDetails(
+	ctx context.Context,
+	instances []string,
+	serviceType, domain string,
+	fn func(ServiceInstance) error,
+) error {
+	limit := r.LookupConcurrency
+	if limit <= 0 {
+		limit = DefaultLookupConcurrency
+	}
+
+	var m sync.Mutex
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(limit)
+
+	for _, instance := range instances {
+		g.Go(func() error {
+			i, ok, err := r.lookupInstance(ctx, instance, serviceType, domain)
+			if !ok || err != nil {
+				return err
+			}
+
+			m.Lock()
+			defer m.Unlock()
+
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fn(i)
+		})
+	}
+
+	return g.Wait()
+}
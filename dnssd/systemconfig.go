@@ -0,0 +1,21 @@
+package dnssd
+
+import (
+	"fmt"
+)
+
+// NewUnicastResolver returns a UnicastResolver that uses the operating
+// system's DNS configuration.
+//
+// On Unix-like systems the configuration is loaded from /etc/resolv.conf. On
+// Windows it is obtained from the IP Helper API and the registry.
+func NewUnicastResolver() (*UnicastResolver, error) {
+	config, err := systemClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load system DNS configuration: %w", err)
+	}
+
+	return &UnicastResolver{
+		Config: config,
+	}, nil
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package dnssd
+
+import (
+	"github.com/miekg/dns"
+)
+
+// resolvConfPath is the path to the system's resolver configuration file.
+const resolvConfPath = "/etc/resolv.conf"
+
+// systemClientConfig returns the operating system's DNS configuration.
+func systemClientConfig() (*dns.ClientConfig, error) {
+	return dns.ClientConfigFromFile(resolvConfPath)
+}
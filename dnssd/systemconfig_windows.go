@@ -0,0 +1,133 @@
+//go:build windows
+
+package dnssd
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"unsafe"
+
+	"github.com/miekg/dns"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// systemClientConfig returns the operating system's DNS configuration.
+//
+// The DNS servers and per-adapter DNS suffixes are obtained from the IP Helper
+// API. The global search list, if any, is read from the registry.
+func systemClientConfig() (*dns.ClientConfig, error) {
+	adapters, err := adapterAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &dns.ClientConfig{
+		Port:     "53",
+		Ndots:    1,
+		Timeout:  5,
+		Attempts: 2,
+	}
+
+	servers := map[string]struct{}{}
+
+	for a := adapters; a != nil; a = a.Next {
+		if a.OperStatus != windows.IfOperStatusUp {
+			continue
+		}
+
+		for s := a.FirstDnsServerAddress; s != nil; s = s.Next {
+			ip := s.Address.IP()
+			if ip == nil || isUnusableDNSServer(ip) {
+				continue
+			}
+
+			addr := ip.String()
+			if _, ok := servers[addr]; !ok {
+				servers[addr] = struct{}{}
+				config.Servers = append(config.Servers, addr)
+			}
+		}
+
+		if a.DnsSuffix != nil {
+			if suffix := windows.UTF16PtrToString(a.DnsSuffix); suffix != "" {
+				config.Search = appendUnique(config.Search, suffix)
+			}
+		}
+	}
+
+	for _, suffix := range registrySearchList() {
+		config.Search = appendUnique(config.Search, suffix)
+	}
+
+	if len(config.Servers) == 0 {
+		return nil, errors.New("no DNS servers are configured")
+	}
+
+	return config, nil
+}
+
+// adapterAddresses returns the addresses of the system's network adapters.
+func adapterAddresses() (*windows.IpAdapterAddresses, error) {
+	size := uint32(15000) // recommended initial size, see GetAdaptersAddresses docs
+
+	for {
+		buf := make([]byte, size)
+		addrs := (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0]))
+
+		err := windows.GetAdaptersAddresses(
+			windows.AF_UNSPEC,
+			windows.GAA_FLAG_SKIP_UNICAST|windows.GAA_FLAG_SKIP_ANYCAST|windows.GAA_FLAG_SKIP_MULTICAST,
+			0,
+			addrs,
+			&size,
+		)
+		if err == nil {
+			return addrs, nil
+		}
+
+		if !errors.Is(err, windows.ERROR_BUFFER_OVERFLOW) {
+			return nil, err
+		}
+	}
+}
+
+// registrySearchList returns the global DNS search list from the registry.
+func registrySearchList() []string {
+	k, err := registry.OpenKey(
+		registry.LOCAL_MACHINE,
+		`SYSTEM\CurrentControlSet\Services\Tcpip\Parameters`,
+		registry.QUERY_VALUE,
+	)
+	if err != nil {
+		return nil
+	}
+	defer k.Close()
+
+	v, _, err := k.GetStringValue("SearchList")
+	if err != nil {
+		return nil
+	}
+
+	return strings.FieldsFunc(v, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+// isUnusableDNSServer returns true if ip is one of the site-local IPv6
+// addresses that Windows reports for adapters without any configured DNS
+// servers.
+func isUnusableDNSServer(ip net.IP) bool {
+	return ip.To4() == nil && ip[0] == 0xfe && ip[1] == 0xc0
+}
+
+// appendUnique appends s to values if it is not already present.
+func appendUnique(values []string, s string) []string {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return values
+		}
+	}
+	return append(values, s)
+}
@@ -0,0 +1,109 @@
+package dnssd
+
+import (
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// TTLPolicy overrides the TTLs of the records that are served by a
+// UnicastServer.
+//
+// Overrides are applied to the records in the answer and additional sections
+// of each response, in place of the TTL of the instance that the record
+// belongs to. They do not affect the records produced by Snapshot(),
+// WriteZoneFile() or Instances().
+type TTLPolicy struct {
+	// ServiceTypes maps service types, such as "_http._tcp", to the TTL to use
+	// for the records that describe instances of that service type.
+	//
+	// These are the PTR records that enumerate the service type, its instances
+	// and sub-types, and each instance's SRV, TXT, SVCB and HTTPS records.
+	ServiceTypes map[string]time.Duration
+
+	// RecordTypes maps DNS record types, such as dns.TypePTR, to the TTL to
+	// use for all records of that type.
+	//
+	// It takes precedence over ServiceTypes, allowing, for example, short TTLs
+	// for PTR records so that browsers see new instances quickly, while
+	// keeping longer TTLs for the SRV and TXT records of each instance.
+	RecordTypes map[uint16]time.Duration
+}
+
+// ttl returns the TTL to use for rr. It returns false if p does not override
+// the TTL of rr.
+func (p TTLPolicy) ttl(rr dns.RR) (time.Duration, bool) {
+	h := rr.Header()
+
+	if ttl, ok := p.RecordTypes[h.Rrtype]; ok {
+		return ttl, true
+	}
+
+	if len(p.ServiceTypes) == 0 {
+		return 0, false
+	}
+
+	// The target of each DNS-SD PTR record is either a service instance name
+	// or a service type's domain, both of which contain the service type.
+	// Other records are owned by the instance name.
+	name := h.Name
+	if ptr, ok := rr.(*dns.PTR); ok {
+		name = ptr.Ptr
+	}
+
+	serviceType, ok := findServiceType(name)
+	if !ok {
+		return 0, false
+	}
+
+	ttl, ok := p.ServiceTypes[serviceType]
+	return ttl, ok
+}
+
+// apply returns records with the TTLs overridden by p. Records with an
+// overridden TTL are copied, as the originals may be shared with other
+// responses.
+func (p TTLPolicy) apply(records []dns.RR) []dns.RR {
+	for i, rr := range records {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+
+		ttl, ok := p.ttl(rr)
+		if !ok {
+			continue
+		}
+
+		rr = dns.Copy(rr)
+		rr.Header().Ttl = ttlInSeconds(ttl)
+		records[i] = rr
+	}
+
+	return records
+}
+
+// findServiceType returns the service type, such as "_http._tcp", within the
+// given domain name.
+//
+// The service type is identified by its protocol label, which is either
+// "_tcp" or "_udp". The right-most match is used, as the instance name label
+// that precedes the service type may contain any text.
+func findServiceType(name string) (string, bool) {
+	labels := dns.SplitDomainName(name)
+
+	for i := len(labels) - 1; i > 0; i-- {
+		proto := strings.ToLower(labels[i])
+		if proto != "_tcp" && proto != "_udp" {
+			continue
+		}
+
+		if !strings.HasPrefix(labels[i-1], "_") {
+			continue
+		}
+
+		return labels[i-1] + "." + labels[i], true
+	}
+
+	return "", false
+}
@@ -0,0 +1,481 @@
+package dnssd
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"time"
+
+	"github.com/dogmatiq/dissolve/internal/observer"
+	"github.com/miekg/dns"
+)
+
+const (
+	// DefaultMinPollInterval is the default minimum interval at which a
+	// UnicastEnumerator polls for changes.
+	DefaultMinPollInterval = 5 * time.Second
+
+	// DefaultMaxPollInterval is the default maximum interval at which a
+	// UnicastEnumerator polls for changes.
+	DefaultMaxPollInterval = DefaultTTL
+)
+
+// UnicastEnumerator is an implementation of Enumerator that discovers services
+// by periodically polling a UnicastResolver.
+//
+// Each poll queries for the complete set of service types or instances and
+// compares it to the result of the previous poll. The interval between polls
+// is the smallest TTL of the records returned by the previous poll, such that
+// changes are observed at about the time that a caching resolver would observe
+// them.
+//
+// If the domain has a DNS Push Notification server or DNS Long-Lived Query
+// server, and the corresponding protocol is enabled, the enumerator instead
+// subscribes to changes to the relevant records and polls only when it is
+// notified of a change.
+type UnicastEnumerator struct {
+	// Resolver is the resolver used to perform the DNS-SD queries.
+	Resolver *UnicastResolver
+
+	// MinPollInterval is the minimum amount of time to wait between polls,
+	// regardless of the TTLs of the discovered records.
+	//
+	// If it is non-positive, DefaultMinPollInterval is used instead.
+	MinPollInterval time.Duration
+
+	// MaxPollInterval is the maximum amount of time to wait between polls,
+	// regardless of the TTLs of the discovered records. It is also used when
+	// the previous poll did not discover any records.
+	//
+	// If it is non-positive, DefaultMaxPollInterval is used instead.
+	MaxPollInterval time.Duration
+
+	// PushTLSConfig is the TLS configuration used to connect to DNS Push
+	// Notification servers.
+	//
+	// If it is non-nil, the enumerator looks up the push server for the domain
+	// being enumerated. If there is no push server, or the push session fails,
+	// the enumerator falls back to polling.
+	//
+	// If PushTLSConfig.ServerName is empty, the push server's hostname is used.
+	//
+	// See https://www.rfc-editor.org/rfc/rfc8765.
+	PushTLSConfig *tls.Config
+
+	// UseLLQ enables DNS Long-Lived Queries.
+	//
+	// If it is true, the enumerator looks up the LLQ server for the domain
+	// being enumerated. DNS Push Notifications are preferred if both protocols
+	// are available. If there is no LLQ server, or any of the queries fail,
+	// the enumerator falls back to polling.
+	//
+	// See https://www.rfc-editor.org/rfc/rfc8764.
+	UseLLQ bool
+}
+
+// changeNotifier is an interface for a session with a server that notifies the
+// client of changes to DNS records.
+type changeNotifier interface {
+	// Changed returns a channel that receives a value whenever any of the
+	// records matching the questions passed to Sync() change.
+	Changed() <-chan struct{}
+
+	// Done returns a channel that is closed when the session is terminated.
+	Done() <-chan struct{}
+
+	// Err returns the error that caused the session to terminate.
+	Err() error
+
+	// Sync changes the set of questions for which the client is notified of
+	// changes.
+	Sync(ctx context.Context, qs []dns.Question) error
+
+	// Close terminates the session.
+	Close()
+}
+
+var _ Enumerator = (*UnicastEnumerator)(nil)
+
+// EnumerateServiceTypes finds all of the service types advertised within a
+// single domain.
+//
+// See Enumerator.
+func (e *UnicastEnumerator) EnumerateServiceTypes(
+	ctx context.Context,
+	domain string,
+	obs func(ctx context.Context, serviceType string) error,
+) error {
+	return poll(
+		ctx,
+		e,
+		domain,
+		func(ctx context.Context) (map[string]string, time.Duration, error) {
+			serviceTypes, ttl, err := e.Resolver.enumerateServiceTypes(ctx, domain)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			values := make(map[string]string, len(serviceTypes))
+			for _, t := range serviceTypes {
+				values[t] = t
+			}
+
+			return values, ttl, nil
+		},
+		func(map[string]string) []dns.Question {
+			return []dns.Question{
+				{
+					Name:   AbsoluteTypeEnumerationDomain(domain),
+					Qtype:  dns.TypePTR,
+					Qclass: dns.ClassINET,
+				},
+			}
+		},
+		func(a, b string) bool { return a == b },
+		obs,
+	)
+}
+
+// EnumerateInstances finds all of the instances of a specific service type
+// that are advertised within a single domain.
+//
+// See Enumerator.
+func (e *UnicastEnumerator) EnumerateInstances(
+	ctx context.Context,
+	serviceType, domain string,
+	obs func(ctx context.Context, i ServiceInstance) error,
+) error {
+	return e.pollInstances(
+		ctx,
+		AbsoluteInstanceEnumerationDomain(serviceType, domain),
+		serviceType,
+		domain,
+		obs,
+	)
+}
+
+// EnumerateInstancesSelectively finds all of the instances of a specific
+// service type that are advertised within a single domain where those services
+// have a specific service sub-type.
+//
+// See Enumerator.
+func (e *UnicastEnumerator) EnumerateInstancesSelectively(
+	ctx context.Context,
+	subType, serviceType, domain string,
+	obs func(ctx context.Context, i ServiceInstance) error,
+) error {
+	return e.pollInstances(
+		ctx,
+		AbsoluteSelectiveInstanceEnumerationDomain(subType, serviceType, domain),
+		serviceType,
+		domain,
+		obs,
+	)
+}
+
+// pollInstances polls for the instances named by the PTR records at
+// queryName.
+func (e *UnicastEnumerator) pollInstances(
+	ctx context.Context,
+	queryName, serviceType, domain string,
+	obs func(ctx context.Context, i ServiceInstance) error,
+) error {
+	return poll(
+		ctx,
+		e,
+		domain,
+		func(ctx context.Context) (map[string]ServiceInstance, time.Duration, error) {
+			instances, ttl, err := e.Resolver.enumerateInstanceDetails(ctx, queryName, serviceType, domain)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			values := make(map[string]ServiceInstance, len(instances))
+
+			for _, i := range instances {
+				values[i.Absolute()] = i
+
+				if ttl == 0 || i.TTL < ttl {
+					ttl = i.TTL
+				}
+			}
+
+			return values, ttl, nil
+		},
+		func(values map[string]ServiceInstance) []dns.Question {
+			questions := []dns.Question{
+				{
+					Name:   queryName,
+					Qtype:  dns.TypePTR,
+					Qclass: dns.ClassINET,
+				},
+			}
+
+			for name := range values {
+				questions = append(
+					questions,
+					dns.Question{
+						Name:   name,
+						Qtype:  dns.TypeANY,
+						Qclass: dns.ClassINET,
+					},
+				)
+			}
+
+			return questions
+		},
+		equalIgnoringTTL,
+		obs,
+	)
+}
+
+// interval returns the amount of time to wait before the next poll, given the
+// smallest TTL of the records discovered by the previous poll.
+func (e *UnicastEnumerator) interval(ttl time.Duration) time.Duration {
+	minInterval := e.minInterval()
+	maxInterval := e.maxInterval()
+
+	if ttl <= 0 || ttl > maxInterval {
+		ttl = maxInterval
+	}
+
+	if ttl < minInterval {
+		ttl = minInterval
+	}
+
+	return ttl
+}
+
+// minInterval returns the minimum amount of time to wait between polls.
+func (e *UnicastEnumerator) minInterval() time.Duration {
+	if e.MinPollInterval > 0 {
+		return e.MinPollInterval
+	}
+	return DefaultMinPollInterval
+}
+
+// maxInterval returns the maximum amount of time to wait between polls.
+func (e *UnicastEnumerator) maxInterval() time.Duration {
+	if e.MaxPollInterval > 0 {
+		return e.MaxPollInterval
+	}
+	return DefaultMaxPollInterval
+}
+
+// retryDelay returns the amount of time to wait before retrying a fetch that
+// has failed the given number of consecutive times.
+//
+// The delay starts at the minimum poll interval and doubles with each failure,
+// up to the maximum poll interval.
+func (e *UnicastEnumerator) retryDelay(failures int) time.Duration {
+	p := RetryPolicy{
+		Backoff:    e.minInterval(),
+		MaxBackoff: max(e.minInterval(), e.maxInterval()),
+		Jitter:     0.1,
+	}
+
+	return p.delay(failures - 1)
+}
+
+// dialNotifier establishes a session with a server that notifies the
+// enumerator of changes to the records within the given domain.
+//
+// It returns nil if no such server can be used.
+func (e *UnicastEnumerator) dialNotifier(ctx context.Context, domain string) changeNotifier {
+	logger := e.Resolver.Logger
+
+	if e.PushTLSConfig != nil {
+		host, addr, ok, err := e.Resolver.lookupPushServer(ctx, domain)
+		if err != nil {
+			return nil
+		}
+
+		if ok {
+			s, err := dialPushSession(ctx, e.PushTLSConfig, host, addr, logger)
+			if err == nil {
+				return s
+			}
+
+			logAttrs(
+				logger,
+				slog.LevelWarn,
+				"unable to establish DNS push session",
+				slog.String("domain", domain),
+				slog.String("server", addr),
+				slog.Any("error", err),
+			)
+		}
+	}
+
+	if e.UseLLQ {
+		addr, ok, err := e.Resolver.lookupLLQServer(ctx, domain)
+		if err != nil {
+			return nil
+		}
+
+		if ok {
+			s, err := dialLLQSession(ctx, addr, logger)
+			if err == nil {
+				return s
+			}
+
+			logAttrs(
+				logger,
+				slog.LevelWarn,
+				"unable to establish DNS long-lived query session",
+				slog.String("domain", domain),
+				slog.String("server", addr),
+				slog.Any("error", err),
+			)
+		}
+	}
+
+	if e.PushTLSConfig != nil || e.UseLLQ {
+		logAttrs(
+			logger,
+			slog.LevelDebug,
+			"no change notification server available, falling back to polling",
+			slog.String("domain", domain),
+		)
+	}
+
+	return nil
+}
+
+// poll calls fetch repeatedly until ctx is canceled or an observer function
+// returns an error, starting and stopping observer functions as values appear
+// and disappear.
+//
+// If fetch fails, the failure is logged and fetch is retried after a delay
+// that increases with each consecutive failure.
+//
+// fetch returns the current set of values, keyed by a unique identifier, and
+// the smallest TTL of the records that describe them. subscriptions returns
+// the DNS questions to subscribe to when using change notifications.
+func poll[T any](
+	ctx context.Context,
+	e *UnicastEnumerator,
+	domain string,
+	fetch func(context.Context) (map[string]T, time.Duration, error),
+	subscriptions func(map[string]T) []dns.Question,
+	equal func(T, T) bool,
+	obs func(context.Context, T) error,
+) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	observers := &observer.Set[T]{
+		Context:  ctx,
+		Cancel:   cancel,
+		Observer: obs,
+		Equal:    equal,
+	}
+	defer observers.Stop()
+
+	notifier := e.dialNotifier(ctx, domain)
+	defer func() {
+		if notifier != nil {
+			notifier.Close()
+		}
+	}()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	// failures is the number of consecutive failed fetches.
+	failures := 0
+
+	for {
+		// If notifier is nil, these channels are nil and therefore block
+		// forever.
+		var changed, done <-chan struct{}
+		if notifier != nil {
+			changed = notifier.Changed()
+			done = notifier.Done()
+		}
+
+		fetchCtx := ctx
+
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-timer.C:
+		case <-changed:
+			// The server has told us that the records have changed, so any
+			// negative responses that are cached for them are out of date.
+			fetchCtx = withoutNegativeCache(ctx)
+		case <-done:
+			notifier = e.notifierFailed(notifier, domain, notifier.Err())
+		}
+
+		values, ttl, err := fetch(fetchCtx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return context.Cause(ctx)
+			}
+
+			// The previously observed values remain active, as a transient
+			// failure does not mean that they have gone away.
+			failures++
+			delay := e.retryDelay(failures)
+
+			logAttrs(
+				e.Resolver.Logger,
+				slog.LevelWarn,
+				"unable to fetch DNS-SD records, retrying",
+				slog.String("domain", domain),
+				slog.Int("failures", failures),
+				slog.Duration("delay", delay),
+				slog.Any("error", err),
+			)
+
+			timer.Reset(delay)
+			continue
+		}
+
+		failures = 0
+		observers.Sync(values)
+
+		if notifier != nil {
+			if err := notifier.Sync(ctx, subscriptions(values)); err != nil {
+				if ctx.Err() != nil {
+					return context.Cause(ctx)
+				}
+				notifier = e.notifierFailed(notifier, domain, err)
+			}
+		}
+
+		// While the notifier is active there is no need to poll, as the server
+		// notifies us of any changes.
+		if notifier == nil {
+			timer.Reset(e.interval(ttl))
+		}
+	}
+}
+
+// notifierFailed closes a change notification session that has failed. It
+// always returns nil, for assignment to the variable that holds the session.
+func (e *UnicastEnumerator) notifierFailed(n changeNotifier, domain string, err error) changeNotifier {
+	n.Close()
+
+	logAttrs(
+		e.Resolver.Logger,
+		slog.LevelWarn,
+		"change notification session failed, falling back to polling",
+		slog.String("domain", domain),
+		slog.Any("error", err),
+	)
+
+	return nil
+}
+
+// equalIgnoringTTL returns true if a and b are equal, without comparing their
+// TTLs.
+//
+// The TTLs returned by caching resolvers decrease over time, which would
+// otherwise cause an instance to be considered "changed" on every poll.
+func equalIgnoringTTL(a, b ServiceInstance) bool {
+	a.TTL = 0
+	b.TTL = 0
+	return a.Equal(b)
+}
@@ -0,0 +1,1117 @@
+package dnssd_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/dogmatiq/dissolve/dnssd"
+	"github.com/miekg/dns"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Context("UnicastEnumerator", func() {
+	var (
+		ctx                  context.Context
+		cancel               context.CancelFunc
+		instanceA, instanceB ServiceInstance
+		server               *UnicastServer
+		serverResult         chan error
+		enumerator           *UnicastEnumerator
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+
+		instanceA = ServiceInstance{
+			ServiceInstanceName: ServiceInstanceName{
+				Name:        "Instance A",
+				ServiceType: "_http._tcp",
+				Domain:      "example.org",
+			},
+			TargetHost: "a.example.com",
+			TargetPort: 12345,
+			TTL:        DefaultTTL,
+		}
+
+		instanceB = ServiceInstance{
+			ServiceInstanceName: ServiceInstanceName{
+				Name:        "Instance B",
+				ServiceType: "_http._tcp",
+				Domain:      "example.org",
+			},
+			TargetHost: "b.example.com",
+			TargetPort: 12345,
+			TTL:        DefaultTTL,
+		}
+
+		// Include an SOA record in negative responses so that the resolver
+		// caches them.
+		soa, err := dns.NewRR(`example.org. 120 IN SOA ns.example.org. admin.example.org. 1 3600 600 86400 3600`)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		server = &UnicastServer{
+			Zones: []Zone{{SOA: soa.(*dns.SOA)}},
+		}
+		Expect(server.Advertise(instanceA, WithServiceSubType("_printer"))).To(Succeed())
+		Expect(server.Advertise(instanceB)).To(Succeed())
+
+		serverResult = make(chan error, 1)
+
+		// Bind the socket before starting the server so that it is ready to
+		// receive queries as soon as the test begins.
+		conn, err := net.ListenPacket("udp", "127.0.0.1:65353")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		go func() {
+			serverResult <- server.RunWithPacketConn(ctx, conn)
+		}()
+
+		enumerator = &UnicastEnumerator{
+			Resolver: &UnicastResolver{
+				Config: &dns.ClientConfig{
+					Servers: []string{"127.0.0.1"},
+					Port:    "65353",
+				},
+			},
+			MinPollInterval: 10 * time.Millisecond,
+			MaxPollInterval: 10 * time.Millisecond,
+		}
+	})
+
+	AfterEach(func() {
+		cancel()
+		Expect(<-serverResult).To(Equal(context.Canceled))
+	})
+
+	// enumerate runs fn in a separate goroutine and returns a channel that
+	// receives its result.
+	enumerate := func(fn func() error) <-chan error {
+		result := make(chan error, 1)
+		go func() {
+			result <- fn()
+		}()
+		return result
+	}
+
+	Describe("func EnumerateServiceTypes()", func() {
+		It("calls the observer for each service type", func() {
+			serviceTypes := make(chan string, 10)
+
+			result := enumerate(func() error {
+				return enumerator.EnumerateServiceTypes(
+					ctx,
+					"example.org",
+					func(ctx context.Context, serviceType string) error {
+						serviceTypes <- serviceType
+						return nil
+					},
+				)
+			})
+
+			Eventually(serviceTypes).Should(Receive(Equal("_http._tcp")))
+			Consistently(serviceTypes, 50*time.Millisecond).ShouldNot(Receive())
+
+			cancel()
+			Expect(<-result).To(Equal(context.Canceled))
+		})
+	})
+
+	Describe("func EnumerateInstances()", func() {
+		It("calls the observer for each instance", func() {
+			instances := make(chan ServiceInstance, 10)
+
+			result := enumerate(func() error {
+				return enumerator.EnumerateInstances(
+					ctx,
+					"_http._tcp",
+					"example.org",
+					func(ctx context.Context, i ServiceInstance) error {
+						instances <- i
+						return nil
+					},
+				)
+			})
+
+			var a, b ServiceInstance
+			Eventually(instances).Should(Receive(&a))
+			Eventually(instances).Should(Receive(&b))
+			Expect([]ServiceInstance{a, b}).To(ConsistOf(instanceA, instanceB))
+
+			Consistently(instances, 50*time.Millisecond).ShouldNot(Receive())
+
+			cancel()
+			Expect(<-result).To(Equal(context.Canceled))
+		})
+
+		It("cancels the observer's context when the instance goes away", func() {
+			observed := make(chan context.Context, 10)
+
+			result := enumerate(func() error {
+				return enumerator.EnumerateInstances(
+					ctx,
+					"_http._tcp",
+					"example.org",
+					func(ctx context.Context, i ServiceInstance) error {
+						if i.Name == instanceA.Name {
+							observed <- ctx
+						}
+						<-ctx.Done()
+						return nil
+					},
+				)
+			})
+
+			var obsCtx context.Context
+			Eventually(observed).Should(Receive(&obsCtx))
+			Consistently(obsCtx.Done(), 50*time.Millisecond).ShouldNot(BeClosed())
+
+			server.Remove(instanceA)
+			Eventually(obsCtx.Done()).Should(BeClosed())
+
+			cancel()
+			Expect(<-result).To(Equal(context.Canceled))
+		})
+
+		It("observes the instance again when it changes", func() {
+			observed := make(chan ServiceInstance, 10)
+
+			result := enumerate(func() error {
+				return enumerator.EnumerateInstances(
+					ctx,
+					"_http._tcp",
+					"example.org",
+					func(ctx context.Context, i ServiceInstance) error {
+						if i.Name == instanceA.Name {
+							observed <- i
+						}
+						<-ctx.Done()
+						return nil
+					},
+				)
+			})
+
+			Eventually(observed).Should(Receive(Equal(instanceA)))
+
+			instanceA.TargetPort = 54321
+			Expect(server.Advertise(instanceA)).To(Succeed())
+
+			Eventually(observed).Should(Receive(Equal(instanceA)))
+
+			cancel()
+			Expect(<-result).To(Equal(context.Canceled))
+		})
+
+		It("returns an error if the observer returns an error", func() {
+			err := enumerator.EnumerateInstances(
+				ctx,
+				"_http._tcp",
+				"example.org",
+				func(ctx context.Context, i ServiceInstance) error {
+					return errors.New("<error>")
+				},
+			)
+			Expect(err).To(MatchError("<error>"))
+		})
+	})
+
+	Describe("failures", func() {
+		It("retries after a failed poll instead of returning an error", func() {
+			var (
+				m        sync.Mutex
+				failures = 3
+			)
+
+			enumerator.Resolver.Middleware = []QueryMiddleware{
+				func(next QueryFunc) QueryFunc {
+					return func(ctx context.Context, q dns.Question) (*dns.Msg, error) {
+						m.Lock()
+						defer m.Unlock()
+
+						if failures > 0 {
+							failures--
+							return nil, errors.New("<error>")
+						}
+
+						return next(ctx, q)
+					}
+				},
+			}
+
+			instances := make(chan ServiceInstance, 10)
+
+			result := enumerate(func() error {
+				return enumerator.EnumerateInstances(
+					ctx,
+					"_http._tcp",
+					"example.org",
+					func(ctx context.Context, i ServiceInstance) error {
+						instances <- i
+						<-ctx.Done()
+						return nil
+					},
+				)
+			})
+
+			var a, b ServiceInstance
+			Eventually(instances).Should(Receive(&a))
+			Eventually(instances).Should(Receive(&b))
+			Expect([]ServiceInstance{a, b}).To(ConsistOf(instanceA, instanceB))
+
+			cancel()
+			Expect(<-result).To(Equal(context.Canceled))
+		})
+
+		It("keeps observing the existing instances while polls fail", func() {
+			var failing atomic.Bool
+
+			enumerator.Resolver.Middleware = []QueryMiddleware{
+				func(next QueryFunc) QueryFunc {
+					return func(ctx context.Context, q dns.Question) (*dns.Msg, error) {
+						if failing.Load() {
+							return nil, errors.New("<error>")
+						}
+						return next(ctx, q)
+					}
+				},
+			}
+
+			observed := make(chan context.Context, 10)
+
+			result := enumerate(func() error {
+				return enumerator.EnumerateInstances(
+					ctx,
+					"_http._tcp",
+					"example.org",
+					func(ctx context.Context, i ServiceInstance) error {
+						if i.Name == instanceA.Name {
+							observed <- ctx
+						}
+						<-ctx.Done()
+						return nil
+					},
+				)
+			})
+
+			var obsCtx context.Context
+			Eventually(observed).Should(Receive(&obsCtx))
+
+			failing.Store(true)
+			Consistently(obsCtx.Done(), 100*time.Millisecond).ShouldNot(BeClosed())
+			Consistently(result, 100*time.Millisecond).ShouldNot(Receive())
+
+			failing.Store(false)
+			server.Remove(instanceA)
+			Eventually(obsCtx.Done()).Should(BeClosed())
+
+			cancel()
+			Expect(<-result).To(Equal(context.Canceled))
+		})
+	})
+
+	Describe("func EnumerateInstancesSelectively()", func() {
+		It("calls the observer for each instance with the sub-type", func() {
+			instances := make(chan ServiceInstance, 10)
+
+			result := enumerate(func() error {
+				return enumerator.EnumerateInstancesSelectively(
+					ctx,
+					"_printer",
+					"_http._tcp",
+					"example.org",
+					func(ctx context.Context, i ServiceInstance) error {
+						instances <- i
+						return nil
+					},
+				)
+			})
+
+			Eventually(instances).Should(Receive(Equal(instanceA)))
+			Consistently(instances, 50*time.Millisecond).ShouldNot(Receive())
+
+			cancel()
+			Expect(<-result).To(Equal(context.Canceled))
+		})
+	})
+
+	Describe("DNS push notifications", func() {
+		var push *pushServer
+
+		BeforeEach(func() {
+			push = startPushServer(ctx)
+
+			startServiceLocator(ctx, server, AbsolutePushServiceName("example.org"), push.Addr())
+
+			enumerator.Resolver.Config.Port = "65354"
+			enumerator.PushTLSConfig = push.ClientConfig()
+
+			// Use a poll interval that is long enough that any change observed
+			// during the test must be due to a push notification.
+			enumerator.MinPollInterval = time.Hour
+			enumerator.MaxPollInterval = time.Hour
+		})
+
+		It("subscribes to the records that describe the observed instances", func() {
+			result := enumerate(func() error {
+				return enumerator.EnumerateInstances(
+					ctx,
+					"_http._tcp",
+					"example.org",
+					func(ctx context.Context, i ServiceInstance) error {
+						return nil
+					},
+				)
+			})
+
+			Eventually(push.Subscriptions).Should(ConsistOf(
+				dns.Question{Name: "_http._tcp.example.org.", Qtype: dns.TypePTR, Qclass: dns.ClassINET},
+				dns.Question{Name: instanceA.Absolute(), Qtype: dns.TypeANY, Qclass: dns.ClassINET},
+				dns.Question{Name: instanceB.Absolute(), Qtype: dns.TypeANY, Qclass: dns.ClassINET},
+			))
+
+			cancel()
+			Expect(<-result).To(Equal(context.Canceled))
+		})
+
+		It("polls for changes when the server sends a push notification", func() {
+			instances := make(chan ServiceInstance, 10)
+
+			result := enumerate(func() error {
+				return enumerator.EnumerateInstances(
+					ctx,
+					"_http._tcp",
+					"example.org",
+					func(ctx context.Context, i ServiceInstance) error {
+						instances <- i
+						return nil
+					},
+				)
+			})
+
+			Eventually(instances).Should(Receive())
+			Eventually(instances).Should(Receive())
+			Eventually(push.Subscriptions).Should(HaveLen(3))
+
+			instanceC := instanceA
+			instanceC.Name = "Instance C"
+			Expect(server.Advertise(instanceC)).To(Succeed())
+
+			Consistently(instances, 100*time.Millisecond).ShouldNot(Receive())
+
+			push.Notify()
+
+			Eventually(instances).Should(Receive(Equal(instanceC)))
+
+			cancel()
+			Expect(<-result).To(Equal(context.Canceled))
+		})
+
+		It("ignores cached negative responses when the server sends a push notification", func() {
+			instances := make(chan ServiceInstance, 10)
+
+			result := enumerate(func() error {
+				return enumerator.EnumerateInstances(
+					ctx,
+					"_ftp._tcp",
+					"example.org",
+					func(ctx context.Context, i ServiceInstance) error {
+						instances <- i
+						return nil
+					},
+				)
+			})
+
+			Eventually(push.Subscriptions).Should(HaveLen(1))
+
+			instanceC := instanceA
+			instanceC.Name = "Instance C"
+			instanceC.ServiceType = "_ftp._tcp"
+			Expect(server.Advertise(instanceC)).To(Succeed())
+
+			push.Notify()
+
+			Eventually(instances).Should(Receive(Equal(instanceC)))
+
+			cancel()
+			Expect(<-result).To(Equal(context.Canceled))
+		})
+
+		It("ignores duplicate responses from the server", func() {
+			push.duplicates.Store(10)
+
+			instances := make(chan ServiceInstance, 10)
+
+			result := enumerate(func() error {
+				return enumerator.EnumerateInstances(
+					ctx,
+					"_http._tcp",
+					"example.org",
+					func(ctx context.Context, i ServiceInstance) error {
+						instances <- i
+						return nil
+					},
+				)
+			})
+
+			Eventually(instances).Should(Receive())
+			Eventually(instances).Should(Receive())
+			Eventually(push.Subscriptions).Should(HaveLen(3))
+
+			instanceC := instanceA
+			instanceC.Name = "Instance C"
+			Expect(server.Advertise(instanceC)).To(Succeed())
+
+			push.Notify()
+
+			Eventually(instances).Should(Receive(Equal(instanceC)))
+
+			cancel()
+			Expect(<-result).To(Equal(context.Canceled))
+		})
+
+		It("unsubscribes from instances that go away", func() {
+			result := enumerate(func() error {
+				return enumerator.EnumerateInstances(
+					ctx,
+					"_http._tcp",
+					"example.org",
+					func(ctx context.Context, i ServiceInstance) error {
+						return nil
+					},
+				)
+			})
+
+			Eventually(push.Subscriptions).Should(HaveLen(3))
+
+			server.Remove(instanceA)
+			push.Notify()
+
+			Eventually(push.Subscriptions).Should(ConsistOf(
+				dns.Question{Name: "_http._tcp.example.org.", Qtype: dns.TypePTR, Qclass: dns.ClassINET},
+				dns.Question{Name: instanceB.Absolute(), Qtype: dns.TypeANY, Qclass: dns.ClassINET},
+			))
+
+			cancel()
+			Expect(<-result).To(Equal(context.Canceled))
+		})
+
+		It("falls back to polling if the push session fails", func() {
+			instances := make(chan ServiceInstance, 10)
+
+			result := enumerate(func() error {
+				return enumerator.EnumerateInstances(
+					ctx,
+					"_http._tcp",
+					"example.org",
+					func(ctx context.Context, i ServiceInstance) error {
+						instances <- i
+						return nil
+					},
+				)
+			})
+
+			Eventually(instances).Should(Receive())
+			Eventually(instances).Should(Receive())
+			Eventually(push.Subscriptions).Should(HaveLen(3))
+
+			instanceC := instanceA
+			instanceC.Name = "Instance C"
+			Expect(server.Advertise(instanceC)).To(Succeed())
+
+			push.Disconnect()
+
+			Eventually(instances).Should(Receive(Equal(instanceC)))
+
+			cancel()
+			Expect(<-result).To(Equal(context.Canceled))
+		})
+
+		It("falls back to polling if there is no push server", func() {
+			enumerator.Resolver.Config.Port = "65353"
+			enumerator.MinPollInterval = 10 * time.Millisecond
+			enumerator.MaxPollInterval = 10 * time.Millisecond
+
+			instances := make(chan ServiceInstance, 10)
+
+			result := enumerate(func() error {
+				return enumerator.EnumerateInstances(
+					ctx,
+					"_http._tcp",
+					"example.org",
+					func(ctx context.Context, i ServiceInstance) error {
+						instances <- i
+						return nil
+					},
+				)
+			})
+
+			Eventually(instances).Should(Receive())
+			Eventually(instances).Should(Receive())
+
+			instanceC := instanceA
+			instanceC.Name = "Instance C"
+			Expect(server.Advertise(instanceC)).To(Succeed())
+
+			Eventually(instances).Should(Receive(Equal(instanceC)))
+
+			cancel()
+			Expect(<-result).To(Equal(context.Canceled))
+		})
+	})
+
+	Describe("DNS long-lived queries", func() {
+		var llq *llqServer
+
+		BeforeEach(func() {
+			llq = startLLQServer(ctx)
+
+			startServiceLocator(ctx, server, AbsoluteLLQServiceName("example.org"), llq.Addr())
+
+			enumerator.Resolver.Config.Port = "65354"
+			enumerator.UseLLQ = true
+
+			// Use a poll interval that is long enough that any change observed
+			// during the test must be due to an LLQ event.
+			enumerator.MinPollInterval = time.Hour
+			enumerator.MaxPollInterval = time.Hour
+		})
+
+		It("sets up a long-lived query for the records that describe the observed instances", func() {
+			result := enumerate(func() error {
+				return enumerator.EnumerateInstances(
+					ctx,
+					"_http._tcp",
+					"example.org",
+					func(ctx context.Context, i ServiceInstance) error {
+						return nil
+					},
+				)
+			})
+
+			Eventually(llq.Queries).Should(ConsistOf(
+				dns.Question{Name: "_http._tcp.example.org.", Qtype: dns.TypePTR, Qclass: dns.ClassINET},
+				dns.Question{Name: instanceA.Absolute(), Qtype: dns.TypeANY, Qclass: dns.ClassINET},
+				dns.Question{Name: instanceB.Absolute(), Qtype: dns.TypeANY, Qclass: dns.ClassINET},
+			))
+
+			cancel()
+			Expect(<-result).To(Equal(context.Canceled))
+		})
+
+		It("polls for changes when the server sends an event", func() {
+			instances := make(chan ServiceInstance, 10)
+
+			result := enumerate(func() error {
+				return enumerator.EnumerateInstances(
+					ctx,
+					"_http._tcp",
+					"example.org",
+					func(ctx context.Context, i ServiceInstance) error {
+						instances <- i
+						return nil
+					},
+				)
+			})
+
+			Eventually(instances).Should(Receive())
+			Eventually(instances).Should(Receive())
+			Eventually(llq.Queries).Should(HaveLen(3))
+
+			instanceC := instanceA
+			instanceC.Name = "Instance C"
+			Expect(server.Advertise(instanceC)).To(Succeed())
+
+			Consistently(instances, 100*time.Millisecond).ShouldNot(Receive())
+
+			llq.Notify(dns.Question{Name: "_http._tcp.example.org.", Qtype: dns.TypePTR, Qclass: dns.ClassINET})
+
+			Eventually(instances).Should(Receive(Equal(instanceC)))
+			Eventually(llq.Acknowledged).Should(BeTrue())
+
+			cancel()
+			Expect(<-result).To(Equal(context.Canceled))
+		})
+
+		DescribeTable(
+			"falls back to polling if the server grants a lease that is too short",
+			func(lease time.Duration) {
+				llq.SetLease(lease)
+				enumerator.MinPollInterval = 10 * time.Millisecond
+				enumerator.MaxPollInterval = 10 * time.Millisecond
+
+				instances := make(chan ServiceInstance, 10)
+
+				result := enumerate(func() error {
+					return enumerator.EnumerateInstances(
+						ctx,
+						"_http._tcp",
+						"example.org",
+						func(ctx context.Context, i ServiceInstance) error {
+							instances <- i
+							return nil
+						},
+					)
+				})
+
+				Eventually(instances).Should(Receive())
+				Eventually(instances).Should(Receive())
+
+				instanceC := instanceA
+				instanceC.Name = "Instance C"
+				Expect(server.Advertise(instanceC)).To(Succeed())
+
+				Eventually(instances).Should(Receive(Equal(instanceC)))
+				Consistently(llq.Refreshes, 200*time.Millisecond).Should(BeZero())
+
+				cancel()
+				Expect(<-result).To(Equal(context.Canceled))
+			},
+			Entry("zero", time.Duration(0)),
+			Entry("one second", time.Second),
+		)
+
+		It("cancels long-lived queries for instances that go away", func() {
+			result := enumerate(func() error {
+				return enumerator.EnumerateInstances(
+					ctx,
+					"_http._tcp",
+					"example.org",
+					func(ctx context.Context, i ServiceInstance) error {
+						return nil
+					},
+				)
+			})
+
+			Eventually(llq.Queries).Should(HaveLen(3))
+
+			server.Remove(instanceA)
+			llq.Notify(dns.Question{Name: "_http._tcp.example.org.", Qtype: dns.TypePTR, Qclass: dns.ClassINET})
+
+			Eventually(llq.Queries).Should(ConsistOf(
+				dns.Question{Name: "_http._tcp.example.org.", Qtype: dns.TypePTR, Qclass: dns.ClassINET},
+				dns.Question{Name: instanceB.Absolute(), Qtype: dns.TypeANY, Qclass: dns.ClassINET},
+			))
+
+			cancel()
+			Expect(<-result).To(Equal(context.Canceled))
+		})
+	})
+})
+
+// pushServer is a minimal DNS Push Notification server, as per RFC 8765.
+type pushServer struct {
+	listener net.Listener
+	cert     *x509.Certificate
+
+	conns         chan net.Conn
+	subscriptions chan []dns.Question
+	current       []dns.Question
+
+	// duplicates is the number of additional copies of each response to a
+	// SUBSCRIBE request that the server sends.
+	duplicates atomic.Int32
+}
+
+// startPushServer starts a push server on a random port. It stops when ctx is
+// canceled.
+func startPushServer(ctx context.Context) *pushServer {
+	cert := newCertificate()
+
+	listener, err := tls.Listen(
+		"tcp",
+		"127.0.0.1:0",
+		&tls.Config{
+			Certificates: []tls.Certificate{cert},
+		},
+	)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	s := &pushServer{
+		listener:      listener,
+		cert:          cert.Leaf,
+		conns:         make(chan net.Conn, 1),
+		subscriptions: make(chan []dns.Question, 100),
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	go func() {
+		defer GinkgoRecover()
+
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				<-ctx.Done()
+				conn.Close()
+			}()
+
+			s.conns <- conn
+			s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+// Addr returns the address on which the server is listening.
+func (s *pushServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// ClientConfig returns the TLS configuration that clients must use to connect
+// to the server.
+func (s *pushServer) ClientConfig() *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.cert)
+	return &tls.Config{RootCAs: pool}
+}
+
+// Subscriptions returns the questions that the client is currently subscribed
+// to.
+func (s *pushServer) Subscriptions() []dns.Question {
+	for {
+		select {
+		case qs := <-s.subscriptions:
+			s.current = qs
+		default:
+			return s.current
+		}
+	}
+}
+
+// Notify sends a push notification to the connected client.
+func (s *pushServer) Notify() {
+	conn := <-s.conns
+	s.conns <- conn
+
+	rr, err := dns.NewRR("_http._tcp.example.org. 120 IN PTR Instance\\ C._http._tcp.example.org.")
+	Expect(err).ShouldNot(HaveOccurred())
+
+	data := make([]byte, 512)
+	n, err := dns.PackRR(rr, data, 0, nil, false)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	writePushMessage(conn, 0, false, 0x0041, data[:n])
+}
+
+// Disconnect closes the connection to the client.
+func (s *pushServer) Disconnect() {
+	conn := <-s.conns
+	conn.Close()
+}
+
+// serve handles DSO messages received on conn until it is closed.
+func (s *pushServer) serve(conn net.Conn) {
+	subscriptions := map[uint16]dns.Question{}
+
+	for {
+		var size [2]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+
+		buf := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+
+		id := binary.BigEndian.Uint16(buf)
+		tlvType := binary.BigEndian.Uint16(buf[12:])
+		tlvData := buf[16:]
+
+		switch tlvType {
+		case 0x0001: // Keepalive
+			writePushMessage(conn, id, true, tlvType, tlvData)
+		case 0x0040: // SUBSCRIBE
+			name, n, err := dns.UnpackDomainName(tlvData, 0)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			// The message ID of an active subscription must not be reused.
+			//
+			// See https://www.rfc-editor.org/rfc/rfc8765#section-6.2.
+			Expect(subscriptions).NotTo(HaveKey(id))
+
+			subscriptions[id] = dns.Question{
+				Name:   name,
+				Qtype:  binary.BigEndian.Uint16(tlvData[n:]),
+				Qclass: binary.BigEndian.Uint16(tlvData[n+2:]),
+			}
+
+			for range 1 + s.duplicates.Load() {
+				writePushMessage(conn, id, true, 0, nil)
+			}
+		case 0x0042: // UNSUBSCRIBE
+			delete(subscriptions, binary.BigEndian.Uint16(tlvData))
+		}
+
+		var qs []dns.Question
+		for _, q := range subscriptions {
+			qs = append(qs, q)
+		}
+		s.subscriptions <- qs
+	}
+}
+
+// writePushMessage writes a DSO message to conn. If tlvType is zero, the
+// message has no TLVs.
+func writePushMessage(
+	conn net.Conn,
+	id uint16,
+	response bool,
+	tlvType uint16,
+	tlvData []byte,
+) {
+	var msg []byte
+	msg = binary.BigEndian.AppendUint16(msg, id)
+
+	flags := uint16(6) << 11
+	if response {
+		flags |= 1 << 15
+	}
+	msg = binary.BigEndian.AppendUint16(msg, flags)
+	msg = append(msg, make([]byte, 8)...)
+
+	if tlvType != 0 {
+		msg = binary.BigEndian.AppendUint16(msg, tlvType)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(tlvData)))
+		msg = append(msg, tlvData...)
+	}
+
+	buf := binary.BigEndian.AppendUint16(nil, uint16(len(msg)))
+	_, _ = conn.Write(append(buf, msg...))
+}
+
+// startServiceLocator starts a DNS server on 127.0.0.1:65354 that serves the
+// records from server, along with a SRV record at name that refers to addr.
+func startServiceLocator(
+	ctx context.Context,
+	server *UnicastServer,
+	name, addr string,
+) {
+	host, port, err := net.SplitHostPort(addr)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	srv, err := dns.NewRR(name + " 120 IN SRV 0 0 " + port + " " + host + ".")
+	Expect(err).ShouldNot(HaveOccurred())
+
+	mux := dns.NewServeMux()
+	mux.Handle(".", server)
+	mux.HandleFunc(
+		name,
+		func(w dns.ResponseWriter, req *dns.Msg) {
+			res := &dns.Msg{}
+			res.SetReply(req)
+			res.Answer = append(res.Answer, srv)
+			_ = w.WriteMsg(res)
+		},
+	)
+
+	started := make(chan struct{})
+	dnsServer := &dns.Server{
+		Net:               "udp",
+		Addr:              "127.0.0.1:65354",
+		Handler:           mux,
+		NotifyStartedFunc: func() { close(started) },
+	}
+
+	go func() {
+		_ = dnsServer.ListenAndServe()
+	}()
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		_ = dnsServer.Shutdown()
+	}()
+
+	// Wait for the server to release its port before the next test.
+	DeferCleanup(func() {
+		<-stopped
+	})
+
+	Eventually(started).Should(BeClosed())
+}
+
+// llqServer is a minimal DNS Long-Lived Query server, as per RFC 8764.
+type llqServer struct {
+	conn net.PacketConn
+
+	m            sync.Mutex
+	client       net.Addr
+	queries      map[uint64]dns.Question
+	acknowledged bool
+	lease        time.Duration
+	refreshes    int
+}
+
+// startLLQServer starts an LLQ server on a random port. It stops when ctx is
+// canceled.
+func startLLQServer(ctx context.Context) *llqServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	Expect(err).ShouldNot(HaveOccurred())
+
+	s := &llqServer{
+		conn:    conn,
+		queries: map[uint64]dns.Question{},
+		lease:   time.Hour,
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	go s.serve()
+
+	return s
+}
+
+// Addr returns the address on which the server is listening.
+func (s *llqServer) Addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+// Queries returns the questions for which the client currently has LLQs.
+func (s *llqServer) Queries() []dns.Question {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var qs []dns.Question
+	for _, q := range s.queries {
+		qs = append(qs, q)
+	}
+	return qs
+}
+
+// SetLease sets the lease that the server grants to each LLQ.
+func (s *llqServer) SetLease(d time.Duration) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.lease = d
+}
+
+// Refreshes returns the number of refresh requests that the server has
+// received.
+func (s *llqServer) Refreshes() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.refreshes
+}
+
+// Acknowledged returns true if the client has acknowledged an event.
+func (s *llqServer) Acknowledged() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.acknowledged
+}
+
+// Notify sends an LLQ event for the LLQ with the given question.
+func (s *llqServer) Notify(q dns.Question) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for id, x := range s.queries {
+		if x == q {
+			m := &dns.Msg{}
+			m.Id = dns.Id()
+			m.Response = true
+			m.Question = []dns.Question{q}
+			m.Extra = []dns.RR{newLLQOPT(3, id, time.Hour)}
+			s.write(m, s.client)
+		}
+	}
+}
+
+// serve handles LLQ requests until the connection is closed.
+func (s *llqServer) serve() {
+	buf := make([]byte, dns.MaxMsgSize)
+
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		req := &dns.Msg{}
+		if err := req.Unpack(buf[:n]); err != nil {
+			continue
+		}
+
+		var opt *dns.EDNS0_LLQ
+		for _, o := range req.IsEdns0().Option {
+			opt = o.(*dns.EDNS0_LLQ)
+		}
+
+		s.m.Lock()
+		s.client = addr
+
+		switch {
+		case req.Response:
+			// The client is acknowledging an event.
+			s.acknowledged = true
+			s.m.Unlock()
+			continue
+		case opt.Opcode == 1 && opt.Id == 0:
+			// Initial setup request, respond with a challenge.
+			opt.Id = uint64(dns.Id()) + 1
+		case opt.Opcode == 1:
+			// Challenge response, the LLQ is now established.
+			s.queries[opt.Id] = req.Question[0]
+		case opt.Opcode == 2 && opt.LeaseLife == 0:
+			// Cancellation.
+			delete(s.queries, opt.Id)
+		case opt.Opcode == 2:
+			s.refreshes++
+		}
+
+		res := &dns.Msg{}
+		res.SetReply(req)
+		res.Extra = []dns.RR{newLLQOPT(opt.Opcode, opt.Id, s.lease)}
+		s.write(res, addr)
+		s.m.Unlock()
+	}
+}
+
+// write sends m to addr.
+func (s *llqServer) write(m *dns.Msg, addr net.Addr) {
+	data, err := m.Pack()
+	Expect(err).ShouldNot(HaveOccurred())
+	_, _ = s.conn.WriteTo(data, addr)
+}
+
+// newLLQOPT returns an OPT record containing an LLQ option.
+func newLLQOPT(opcode uint16, id uint64, lease time.Duration) *dns.OPT {
+	opt := &dns.OPT{
+		Hdr: dns.RR_Header{
+			Name:   ".",
+			Rrtype: dns.TypeOPT,
+		},
+	}
+	opt.Option = append(
+		opt.Option,
+		&dns.EDNS0_LLQ{
+			Code:      dns.EDNS0LLQ,
+			Version:   1,
+			Opcode:    opcode,
+			Id:        id,
+			LeaseLife: uint32(lease.Seconds()),
+		},
+	)
+	return opt
+}
@@ -1,10 +1,13 @@
 package dnssd
 
 import (
+	"cmp"
 	"context"
 	"fmt"
+	"log/slog"
 	"math"
 	"net"
+	"slices"
 	"strings"
 	"time"
 
@@ -12,13 +15,112 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+// DefaultLookupConcurrency is the default maximum number of service instances
+// that are looked up concurrently by UnicastResolver.LookupInstances().
+const DefaultLookupConcurrency = 10
+
 // UnicastResolver makes DNS-SD queries using unicast DNS requests.
 //
 // This is a relatively low-level interface that allows performing each type of
 // DNS-SD query type separately.
+//
+// The domain passed to each method is expanded using the search list in
+// Config, in the same way as a conventional stub resolver. A domain with a
+// trailing dot is never expanded, and an empty domain is expanded to each of
+// the search domains in turn. Domains with fewer than Config.Ndots dots are
+// expanded before being tried as-is.
+//
+// Responses indicating that a name or record does not exist are cached for the
+// negative TTL given by the SOA record in the response, if any, as per RFC 2308.
 type UnicastResolver struct {
 	Client *dns.Client
 	Config *dns.ClientConfig
+
+	// Logger is the target for log messages about the queries that are
+	// performed.
+	//
+	// If it is nil, no logging is performed.
+	Logger *slog.Logger
+
+	// ParseMode controls how malformed records in DNS responses are handled.
+	//
+	// The zero value is LenientParsing, which skips malformed records.
+	ParseMode ParseMode
+
+	// DoT, if non-nil, causes all queries to be made using DNS-over-TLS.
+	//
+	// Queries are made against the servers in Config, but on DoT.Port instead
+	// of Config.Port.
+	DoT *DoTConfig
+
+	// DoH, if non-nil, causes all queries to be made using DNS-over-HTTPS.
+	//
+	// Queries are made against the endpoints in DoH.URLs instead of the
+	// servers in Config, and Config may be nil. DoH takes precedence over DoT.
+	DoH *DoHConfig
+
+	// DialContext, if non-nil, is used to establish connections to the DNS
+	// servers in place of the dialer in Client.
+	//
+	// It has the same signature as net.Dialer.DialContext(), and can be used to
+	// route queries through a proxy, VPN or network namespace. It is not used
+	// for DoH queries, which are made using DoH.Client.
+	DialContext func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// RetryPolicy controls how queries are retried against each server when
+	// no response is received.
+	//
+	// The zero value sends each query to each server once.
+	RetryPolicy RetryPolicy
+
+	// LookupConcurrency is the maximum number of service instances that are
+	// looked up concurrently by LookupInstances().
+	//
+	// If it is non-positive, DefaultLookupConcurrency is used.
+	LookupConcurrency int
+
+	// UDPSize is the maximum UDP payload size that is advertised to servers
+	// using an EDNS(0) OPT record.
+	//
+	// If it is zero, DefaultUDPSize is used.
+	UDPSize uint16
+
+	// DisableEDNS0, if true, prevents the resolver from adding an EDNS(0) OPT
+	// record to its queries.
+	//
+	// By default, queries are repeated without EDNS(0) if the server rejects
+	// them, so it is rarely necessary to set this field.
+	DisableEDNS0 bool
+
+	// LookupServiceBindings, if true, causes LookupInstance() and
+	// LookupInstances() to query for the SVCB and HTTPS records of each
+	// instance, in addition to its SRV and TXT records.
+	//
+	// See https://www.rfc-editor.org/rfc/rfc9460.
+	LookupServiceBindings bool
+
+	// Middleware is a set of functions that are invoked around every query
+	// that is sent to the DNS servers.
+	//
+	// The first middleware is the outermost, that is, it is the first to be
+	// invoked for each query.
+	Middleware []QueryMiddleware
+
+	// RandomizeCase, if true, randomizes the case of the letters in the name
+	// of each query, and discards any response that does not repeat the name
+	// exactly. This technique is known as "DNS 0x20".
+	//
+	// It makes it harder for an off-path attacker to spoof responses to plain
+	// DNS queries. It has no effect on DoT or DoH queries. It must not be used
+	// with servers that do not preserve the case of the query name.
+	RandomizeCase bool
+
+	// Metrics, if non-nil, is notified of the outcome and latency of each
+	// query that is sent to the DNS servers.
+	Metrics ResolverMetrics
+
+	negative   negativeCache
+	additional additionalRecords
 }
 
 // EnumerateServiceTypes finds all of the service types advertised within a
@@ -32,29 +134,16 @@ type UnicastResolver struct {
 func (r *UnicastResolver) EnumerateServiceTypes(
 	ctx context.Context,
 	domain string,
+	options ...LookupOption,
 ) ([]string, error) {
-	res, ok, err := r.query(
-		ctx,
-		AbsoluteTypeEnumerationDomain(domain),
-		dns.TypePTR,
-	)
-	if !ok || err != nil {
-		return nil, err
-	}
-
-	suffix := "." + domain + "."
-	serviceTypes := make([]string, 0, len(res.Answer))
-
-	for _, rr := range res.Answer {
-		if ptr, ok := rr.(*dns.PTR); ok {
-			serviceType := strings.TrimSuffix(ptr.Ptr, suffix)
-			if serviceType != ptr.Ptr {
-				serviceTypes = append(serviceTypes, serviceType)
-			}
-		}
-	}
+	ctx, cancel := withLookupOptions(ctx, options)
+	defer cancel()
 
-	return serviceTypes, nil
+	serviceTypes, _, err := search(r, domain, func(domain string) ([]string, bool, error) {
+		serviceTypes, _, err := r.enumerateServiceTypes(ctx, domain)
+		return serviceTypes, len(serviceTypes) != 0, err
+	})
+	return serviceTypes, err
 }
 
 // EnumerateInstances finds all of the instances of a given service type that
@@ -71,28 +160,49 @@ func (r *UnicastResolver) EnumerateServiceTypes(
 func (r *UnicastResolver) EnumerateInstances(
 	ctx context.Context,
 	serviceType, domain string,
+	options ...LookupOption,
 ) ([]string, error) {
-	res, ok, err := r.query(
-		ctx,
-		AbsoluteInstanceEnumerationDomain(serviceType, domain),
-		dns.TypePTR,
-	)
-	if !ok || err != nil {
-		return nil, err
-	}
+	ctx, cancel := withLookupOptions(ctx, options)
+	defer cancel()
 
-	instances := make([]string, 0, len(res.Answer))
+	instances, _, err := search(r, domain, func(domain string) ([]string, bool, error) {
+		instances, _, err := r.enumerateInstances(
+			ctx,
+			AbsoluteInstanceEnumerationDomain(serviceType, domain),
+			serviceType,
+			domain,
+		)
+		return instances, len(instances) != 0, err
+	})
+	return instances, err
+}
 
-	for _, rr := range res.Answer {
-		if ptr, ok := rr.(*dns.PTR); ok {
-			instance, _, err := ParseInstance(ptr.Ptr)
-			if err == nil {
-				instances = append(instances, instance)
-			}
-		}
-	}
+// EnumerateInstanceDetails finds all of the instances of a given service type
+// that are advertised within a single domain, and looks up the details of each
+// one.
+//
+// It is equivalent to calling EnumerateInstances() followed by
+// LookupInstances(), except that the search list is only applied once.
+// Instances that are removed between the two steps are omitted from the
+// result.
+func (r *UnicastResolver) EnumerateInstanceDetails(
+	ctx context.Context,
+	serviceType, domain string,
+	options ...LookupOption,
+) ([]ServiceInstance, error) {
+	ctx, cancel := withLookupOptions(ctx, options)
+	defer cancel()
 
-	return instances, nil
+	instances, _, err := search(r, domain, func(domain string) ([]ServiceInstance, bool, error) {
+		instances, _, err := r.enumerateInstanceDetails(
+			ctx,
+			AbsoluteInstanceEnumerationDomain(serviceType, domain),
+			serviceType,
+			domain,
+		)
+		return instances, len(instances) != 0, err
+	})
+	return instances, err
 }
 
 // EnumerateInstancesBySubType finds all of the instances of a given service
@@ -111,28 +221,111 @@ func (r *UnicastResolver) EnumerateInstances(
 func (r *UnicastResolver) EnumerateInstancesBySubType(
 	ctx context.Context,
 	subType, serviceType, domain string,
+	options ...LookupOption,
+) ([]string, error) {
+	ctx, cancel := withLookupOptions(ctx, options)
+	defer cancel()
+
+	instances, _, err := search(r, domain, func(domain string) ([]string, bool, error) {
+		instances, _, err := r.enumerateInstances(
+			ctx,
+			AbsoluteSelectiveInstanceEnumerationDomain(subType, serviceType, domain),
+			serviceType,
+			domain,
+		)
+		return instances, len(instances) != 0, err
+	})
+	return instances, err
+}
+
+// EnumerateDomains finds the domains that are recommended for browsing and
+// registering services, as advertised within a single domain.
+//
+// domain is the domain that is queried. It may be a domain learned from some
+// other source, such as DHCP, or the reverse-mapping domain of an IP address.
+//
+// See https://www.rfc-editor.org/rfc/rfc6763#section-11.
+func (r *UnicastResolver) EnumerateDomains(
+	ctx context.Context,
+	domain string,
+	options ...LookupOption,
+) (Domains, error) {
+	ctx, cancel := withLookupOptions(ctx, options)
+	defer cancel()
+
+	var result Domains
+
+	g, ctx := errgroup.WithContext(ctx)
+
+	for t, domains := range map[DomainEnumerationType]*[]string{
+		BrowsingDomains:           &result.Browsing,
+		DefaultBrowsingDomain:     &result.DefaultBrowsing,
+		LegacyBrowsingDomains:     &result.LegacyBrowsing,
+		RegistrationDomains:       &result.Registration,
+		DefaultRegistrationDomain: &result.DefaultRegistration,
+	} {
+		g.Go(func() error {
+			var err error
+			*domains, err = r.EnumerateDomainsByType(ctx, t, domain)
+			return err
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return Domains{}, err
+	}
+
+	return result, nil
+}
+
+// EnumerateDomainsByType finds the domains of a specific domain enumeration
+// type that are advertised within a single domain.
+//
+// It returns a slice containing the discovered domains, without the trailing
+// dot.
+//
+// See https://www.rfc-editor.org/rfc/rfc6763#section-11.
+func (r *UnicastResolver) EnumerateDomainsByType(
+	ctx context.Context,
+	t DomainEnumerationType,
+	domain string,
+	options ...LookupOption,
+) ([]string, error) {
+	ctx, cancel := withLookupOptions(ctx, options)
+	defer cancel()
+
+	domains, _, err := search(r, domain, func(domain string) ([]string, bool, error) {
+		domains, err := r.enumerateDomains(ctx, t, domain)
+		return domains, len(domains) != 0, err
+	})
+	return domains, err
+}
+
+// enumerateDomains finds the domains of a specific domain enumeration type
+// that are advertised within a single domain, without search list expansion.
+func (r *UnicastResolver) enumerateDomains(
+	ctx context.Context,
+	t DomainEnumerationType,
+	domain string,
 ) ([]string, error) {
 	res, ok, err := r.query(
 		ctx,
-		AbsoluteSelectiveInstanceEnumerationDomain(subType, serviceType, domain),
+		AbsoluteDomainEnumerationDomain(t, domain),
 		dns.TypePTR,
 	)
 	if !ok || err != nil {
 		return nil, err
 	}
 
-	instances := make([]string, 0, len(res.Answer))
+	var domains []string
 
 	for _, rr := range res.Answer {
 		if ptr, ok := rr.(*dns.PTR); ok {
-			instance, _, err := ParseInstance(ptr.Ptr)
-			if err == nil {
-				instances = append(instances, instance)
-			}
+			domains = append(domains, strings.TrimSuffix(ptr.Ptr, "."))
 		}
 	}
 
-	return instances, nil
+	return domains, nil
 }
 
 // LookupInstance looks up the details about a specific service instance.
@@ -142,13 +335,99 @@ func (r *UnicastResolver) EnumerateInstancesBySubType(
 //
 // ok is false if the instance can not be respolved.
 //
+// If the instance name or the target host of its SRV record is an alias, the
+// chain of CNAME records is followed. The returned TargetHost is always the
+// canonical name of the target.
+//
+// If the server included the instance's records in the additional section of
+// the response to a recent call to EnumerateInstances(), those records are
+// used instead of querying for them again.
+//
 // See https://www.rfc-editor.org/rfc/rfc6763#section-4.1.
 func (r *UnicastResolver) LookupInstance(
 	ctx context.Context,
 	instance, serviceType, domain string,
+	options ...LookupOption,
+) (_ ServiceInstance, ok bool, _ error) {
+	ctx, cancel := withLookupOptions(ctx, options)
+	defer cancel()
+
+	return search(r, domain, func(domain string) (ServiceInstance, bool, error) {
+		return r.lookupInstance(ctx, instance, serviceType, domain)
+	})
+}
+
+// LookupInstances looks up the details about many service instances
+// concurrently.
+//
+// It returns the instances that could be resolved, in the same order as names.
+// Instances that can not be resolved are omitted. At most r.LookupConcurrency
+// instances are looked up at once.
+func (r *UnicastResolver) LookupInstances(
+	ctx context.Context,
+	names []ServiceInstanceName,
+	options ...LookupOption,
+) ([]ServiceInstance, error) {
+	ctx, cancel := withLookupOptions(ctx, options)
+	defer cancel()
+
+	return r.lookupInstances(
+		ctx,
+		names,
+		func(ctx context.Context, instance, serviceType, domain string) (ServiceInstance, bool, error) {
+			return r.LookupInstance(ctx, instance, serviceType, domain)
+		},
+	)
+}
+
+// lookupInstances looks up the details about many service instances
+// concurrently, using the given function to look up each instance.
+func (r *UnicastResolver) lookupInstances(
+	ctx context.Context,
+	names []ServiceInstanceName,
+	lookup func(ctx context.Context, instance, serviceType, domain string) (ServiceInstance, bool, error),
+) ([]ServiceInstance, error) {
+	limit := r.LookupConcurrency
+	if limit <= 0 {
+		limit = DefaultLookupConcurrency
+	}
+
+	instances := make([]ServiceInstance, len(names))
+	found := make([]bool, len(names))
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(limit)
+
+	for index, n := range names {
+		g.Go(func() error {
+			i, ok, err := lookup(ctx, n.Name, n.ServiceType, n.Domain)
+			instances[index], found[index] = i, ok
+			return err
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	result := instances[:0]
+	for index, i := range instances {
+		if found[index] {
+			result = append(result, i)
+		}
+	}
+
+	return result, nil
+}
+
+// lookupInstance looks up the details about a specific service instance,
+// without search list expansion.
+func (r *UnicastResolver) lookupInstance(
+	ctx context.Context,
+	instance, serviceType, domain string,
 ) (_ ServiceInstance, ok bool, _ error) {
 	queryName := AbsoluteServiceInstanceName(instance, serviceType, domain)
-	responses := make(chan *dns.Msg, 2)
+	responses := make(chan *dns.Msg, 4)
 
 	// Note that we make separate queries for SRV and TXT records. We do this
 	// (rather than using an ANY query) as there is no requirement within the
@@ -157,10 +436,10 @@ func (r *UnicastResolver) LookupInstance(
 	//
 	// This common misconception is explained in the Multicast DNS RFC at
 	// https://www.rfc-editor.org/rfc/rfc6762#section-6.5.
-	g, ctx := errgroup.WithContext(ctx)
+	g, gctx := errgroup.WithContext(ctx)
 
 	g.Go(func() error {
-		res, ok, err := r.query(ctx, queryName, dns.TypeSRV)
+		res, ok, err := r.queryCanonical(gctx, queryName, dns.TypeSRV)
 		if ok {
 			responses <- res
 		}
@@ -168,13 +447,25 @@ func (r *UnicastResolver) LookupInstance(
 	})
 
 	g.Go(func() error {
-		res, ok, err := r.query(ctx, queryName, dns.TypeTXT)
+		res, ok, err := r.queryCanonical(gctx, queryName, dns.TypeTXT)
 		if ok {
 			responses <- res
 		}
 		return err
 	})
 
+	if r.LookupServiceBindings {
+		for _, t := range []uint16{dns.TypeSVCB, dns.TypeHTTPS} {
+			g.Go(func() error {
+				res, ok, err := r.queryCanonical(gctx, queryName, t)
+				if ok {
+					responses <- res
+				}
+				return err
+			})
+		}
+	}
+
 	if err := g.Wait(); err != nil {
 		return ServiceInstance{}, false, err
 	}
@@ -190,7 +481,10 @@ func (r *UnicastResolver) LookupInstance(
 		TTL: math.MaxInt64,
 	}
 
-	var hasSRV, hasTXT bool
+	var (
+		hasSRV, hasTXT bool
+		targetRecords  []dns.RR
+	)
 
 	for res := range responses {
 		for _, rr := range res.Answer {
@@ -202,17 +496,289 @@ func (r *UnicastResolver) LookupInstance(
 			switch rr := rr.(type) {
 			case *dns.SRV:
 				hasSRV = true
+				targetRecords = res.Extra
 				unpackSRV(&i, rr)
 			case *dns.TXT:
 				hasTXT = true
-				if err := unpackTXT(&i, rr); err != nil {
+				if err := r.unpackTXT(&i, rr); err != nil {
 					return ServiceInstance{}, false, err
 				}
+			case *dns.SVCB:
+				i.Bindings = append(i.Bindings, unpackSVCB(rr, false))
+			case *dns.HTTPS:
+				i.Bindings = append(i.Bindings, unpackSVCB(&rr.SVCB, true))
 			}
 		}
 	}
 
-	return i, hasSRV && hasTXT, nil
+	// The responses arrive in no particular order, so we sort the bindings
+	// to produce a consistent result, SVCB before HTTPS, then by priority.
+	slices.SortStableFunc(i.Bindings, func(a, b ServiceBinding) int {
+		if a.HTTPS == b.HTTPS {
+			return cmp.Compare(a.Priority, b.Priority)
+		}
+		if b.HTTPS {
+			return -1
+		}
+		return 1
+	})
+
+	if !hasSRV || !hasTXT {
+		return i, false, nil
+	}
+
+	i.TargetHost = r.canonicalTarget(i.TargetHost, targetRecords)
+
+	return i, true, nil
+}
+
+// enumerateServiceTypes returns the service types advertised within a single
+// domain, along with the smallest TTL of the PTR records that describe them.
+func (r *UnicastResolver) enumerateServiceTypes(
+	ctx context.Context,
+	domain string,
+) ([]string, time.Duration, error) {
+	var serviceTypes []string
+
+	_, ttl, err := r.walkServiceTypes(
+		ctx,
+		domain,
+		func(serviceType string) error {
+			serviceTypes = append(serviceTypes, serviceType)
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return serviceTypes, ttl, nil
+}
+
+// walkServiceTypes calls fn for each service type advertised within a single
+// domain, as the PTR records that describe them are read from the response.
+//
+// It returns the number of service types passed to fn, along with the smallest
+// TTL of the PTR records. If fn returns an error, the walk stops and that error
+// is returned.
+func (r *UnicastResolver) walkServiceTypes(
+	ctx context.Context,
+	domain string,
+	fn func(serviceType string) error,
+) (int, time.Duration, error) {
+	res, ok, err := r.query(
+		ctx,
+		AbsoluteTypeEnumerationDomain(domain),
+		dns.TypePTR,
+	)
+	if !ok || err != nil {
+		return 0, 0, err
+	}
+
+	suffix := "." + domain + "."
+	count := 0
+
+	for _, rr := range res.Answer {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			serviceType := strings.TrimSuffix(ptr.Ptr, suffix)
+
+			if serviceType == ptr.Ptr {
+				err := fmt.Errorf("service type %w", &OutOfDomainError{ptr.Ptr, domain})
+				if err := r.malformed(rr, err); err != nil {
+					return 0, 0, err
+				}
+				continue
+			}
+
+			count++
+			if err := fn(serviceType); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+
+	return count, minTTL(res.Answer), nil
+}
+
+// enumerateInstanceDetails looks up the details of each of the instances
+// named by the PTR records at queryName.
+//
+// It returns the instances that could be resolved and the TTL of the PTR
+// records.
+func (r *UnicastResolver) enumerateInstanceDetails(
+	ctx context.Context,
+	queryName, serviceType, domain string,
+) ([]ServiceInstance, time.Duration, error) {
+	names, ttl, err := r.enumerateInstances(ctx, queryName, serviceType, domain)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	instanceNames := make([]ServiceInstanceName, len(names))
+	for index, n := range names {
+		instanceNames[index] = ServiceInstanceName{
+			Name:        n,
+			ServiceType: serviceType,
+			Domain:      domain,
+		}
+	}
+
+	// Note that any instance that has gone away between the PTR query and the
+	// SRV/TXT queries is simply not included.
+	instances, err := r.lookupInstances(ctx, instanceNames, r.lookupInstance)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return instances, ttl, nil
+}
+
+// enumerateInstances returns the instance names from the PTR records at
+// queryName, along with the smallest TTL of those records.
+//
+// PTR records that refer to instances of some type other than serviceType, or
+// in some domain other than domain, are treated as malformed.
+func (r *UnicastResolver) enumerateInstances(
+	ctx context.Context,
+	queryName, serviceType, domain string,
+) ([]string, time.Duration, error) {
+	var instances []string
+
+	_, ttl, err := r.walkInstances(
+		ctx,
+		queryName,
+		serviceType,
+		domain,
+		func(instance string) error {
+			instances = append(instances, instance)
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return instances, ttl, nil
+}
+
+// walkInstances calls fn for each instance name from the PTR records at
+// queryName, as those records are read from the response.
+//
+// It returns the number of instances passed to fn, along with the smallest TTL
+// of the PTR records. If fn returns an error, the walk stops and that error is
+// returned.
+//
+// PTR records that refer to instances of some type other than serviceType, or
+// in some domain other than domain, are treated as malformed.
+func (r *UnicastResolver) walkInstances(
+	ctx context.Context,
+	queryName, serviceType, domain string,
+	fn func(instance string) error,
+) (int, time.Duration, error) {
+	res, ok, err := r.query(ctx, queryName, dns.TypePTR)
+	if !ok || err != nil {
+		return 0, 0, err
+	}
+
+	count := 0
+
+	for _, rr := range res.Answer {
+		if ptr, ok := rr.(*dns.PTR); ok {
+			instance, tail, err := ParseInstance(ptr.Ptr)
+			if err != nil {
+				err = fmt.Errorf("unable to parse instance name: %w", err)
+				if err := r.malformed(rr, err); err != nil {
+					return 0, 0, err
+				}
+				continue
+			}
+
+			// A PTR record that refers to an instance of some other service
+			// type or domain is a sign of misconfiguration or poisoning.
+			if parent := AbsoluteInstanceEnumerationDomain(serviceType, domain); !equalNames(tail, parent) {
+				err := fmt.Errorf("service instance %w", &OutOfDomainError{ptr.Ptr, parent})
+				if err := r.malformed(rr, err); err != nil {
+					return 0, 0, err
+				}
+				continue
+			}
+
+			// Keep any SRV and TXT records for this instance that the server
+			// included in its response, to avoid querying for them separately.
+			// This is done before calling fn, which may look up the instance.
+			r.additional.Add([]string{ptr.Ptr}, res)
+
+			count++
+			if err := fn(instance); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+
+	return count, minTTL(res.Answer), nil
+}
+
+// lookupSRV returns the SRV record at name with the lowest priority.
+//
+// ok is false if there are no SRV records at name.
+func (r *UnicastResolver) lookupSRV(
+	ctx context.Context,
+	name string,
+) (_ *dns.SRV, ok bool, _ error) {
+	res, ok, err := r.query(ctx, name, dns.TypeSRV)
+	if !ok || err != nil {
+		return nil, false, err
+	}
+
+	var srv *dns.SRV
+
+	for _, rr := range res.Answer {
+		if rr, ok := rr.(*dns.SRV); ok {
+			if srv == nil || rr.Priority < srv.Priority {
+				srv = rr
+			}
+		}
+	}
+
+	return srv, srv != nil, nil
+}
+
+// minTTL returns the smallest TTL of the given records, or zero if there are
+// no records.
+func minTTL(records []dns.RR) time.Duration {
+	var ttl time.Duration
+
+	for i, rr := range records {
+		t := time.Duration(rr.Header().Ttl) * time.Second
+		if i == 0 || t < ttl {
+			ttl = t
+		}
+	}
+
+	return ttl
+}
+
+// malformed handles a malformed record according to r.ParseMode.
+//
+// In strict mode it returns err. Otherwise, it logs err and returns nil,
+// indicating that the record should be skipped.
+func (r *UnicastResolver) malformed(rr dns.RR, err error) error {
+	if r.ParseMode == StrictParsing {
+		return err
+	}
+
+	h := rr.Header()
+
+	logAttrs(
+		r.Logger,
+		slog.LevelWarn,
+		"skipped malformed DNS record",
+		slog.String("name", h.Name),
+		slog.String("type", dns.TypeToString[h.Rrtype]),
+		slog.Any("error", err),
+	)
+
+	return nil
 }
 
 // unpackSRV unpacks information from a SRV record into i.
@@ -223,16 +789,46 @@ func unpackSRV(i *ServiceInstance, rr *dns.SRV) {
 	i.Weight = rr.Weight
 }
 
-// unpackSRV unpacks information from a TXT record into i.
-func unpackTXT(i *ServiceInstance, rr *dns.TXT) error {
+// unpackSVCB unpacks information from an SVCB or HTTPS record into a
+// ServiceBinding.
+func unpackSVCB(rr *dns.SVCB, https bool) ServiceBinding {
+	b := ServiceBinding{
+		HTTPS:    https,
+		Priority: rr.Priority,
+		Target:   strings.TrimSuffix(rr.Target, "."),
+	}
+
+	for _, kv := range rr.Value {
+		switch kv := kv.(type) {
+		case *dns.SVCBAlpn:
+			b.ALPN = kv.Alpn
+		case *dns.SVCBPort:
+			b.Port = kv.Port
+		case *dns.SVCBIPv4Hint:
+			b.IPv4Hints = kv.Hint
+		case *dns.SVCBIPv6Hint:
+			b.IPv6Hints = kv.Hint
+		}
+	}
+
+	return b
+}
+
+// unpackTXT unpacks information from a TXT record into i.
+func (r *UnicastResolver) unpackTXT(i *ServiceInstance, rr *dns.TXT) error {
 	var attrs Attributes
 
 	for _, pair := range rr.Txt {
-		var err error
-		attrs, _, err = attrs.WithTXT(pair)
+		a, err := withEscapedTXT(attrs, pair)
 		if err != nil {
-			return fmt.Errorf("unable to parse TXT record: %w", err)
+			err = fmt.Errorf("unable to parse TXT record: %w", err)
+			if err := r.malformed(rr, err); err != nil {
+				return err
+			}
+			continue
 		}
+
+		attrs = a
 	}
 
 	if !attrs.IsEmpty() {
@@ -242,28 +838,153 @@ func unpackTXT(i *ServiceInstance, rr *dns.TXT) error {
 	return nil
 }
 
-// query performs a DNS query against all of the servers in r.Config.
+// withEscapedTXT returns a copy of attrs with the addition of the attribute
+// encoded by the TXT string s, which is in presentation format.
+func withEscapedTXT(attrs Attributes, s string) (Attributes, error) {
+	pair, err := unescapeTXT(s)
+	if err != nil {
+		return Attributes{}, err
+	}
+
+	attrs, _, err = attrs.WithTXT(pair)
+	return attrs, err
+}
+
+// unescapeTXT decodes the escape sequences in a TXT string that has been
+// unpacked by the miekg/dns package, which represents TXT strings in
+// "presentation format".
+func unescapeTXT(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+
+	var w strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			w.WriteByte(s[i])
+			continue
+		}
+
+		b, n, err := unescape(s[i+1:])
+		if err != nil {
+			return "", err
+		}
+
+		w.WriteByte(b)
+		i += n
+	}
+
+	return w.String(), nil
+}
+
+// query performs a DNS query against all of the servers in r.Config, via the
+// middleware in r.Middleware.
 func (r *UnicastResolver) query(
 	ctx context.Context,
 	name string,
 	questionType uint16,
 ) (*dns.Msg, bool, error) {
-	if r.Config.Timeout > 0 {
+	q := dns.Question{
+		Name:   name,
+		Qtype:  questionType,
+		Qclass: dns.ClassINET,
+	}
+
+	if !bypassNegativeCache(ctx) && r.negative.Has(name, questionType) {
+		logAttrs(
+			r.Logger,
+			slog.LevelDebug,
+			"using cached negative response",
+			questionAttrs(q)...,
+		)
+		return nil, false, nil
+	}
+
+	if records, ok := r.additional.Take(name, questionType); ok {
+		logAttrs(
+			r.Logger,
+			slog.LevelDebug,
+			"using records from the additional section of a previous response",
+			append(
+				questionAttrs(q),
+				slog.Int("answers", len(records)),
+			)...,
+		)
+
+		res := &dns.Msg{}
+		res.SetQuestion(name, questionType)
+		res.Response = true
+		res.Answer = records
+
+		return res, true, nil
+	}
+
+	res, err := withMiddleware(r.queryServers, r.Middleware)(ctx, q)
+	if res == nil || err != nil {
+		return nil, false, err
+	}
+
+	// The server responded authoratatively, but only to indicate that this
+	// domain does not exist.
+	if res.Rcode == dns.RcodeNameError {
+		r.negative.Add(name, questionType, res)
+		return nil, false, nil
+	}
+
+	// Middleware may produce responses with other response codes, which are
+	// treated as though no server had an answer.
+	if res.Rcode != dns.RcodeSuccess {
+		return nil, false, nil
+	}
+
+	if len(res.Answer) == 0 {
+		r.negative.Add(name, questionType, res)
+	}
+
+	return res, true, nil
+}
+
+// queryServers is a QueryFunc that performs a DNS query against all of the
+// servers in r.Config.
+func (r *UnicastResolver) queryServers(
+	ctx context.Context,
+	q dns.Question,
+) (*dns.Msg, error) {
+	timeout, ok := queryTimeout(ctx)
+	if !ok && r.Config != nil {
+		timeout = time.Duration(r.Config.Timeout) * time.Second
+	}
+
+	if timeout > 0 {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, time.Duration(r.Config.Timeout)*time.Second)
+		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
+	name := q.Name
+	if r.randomizesCase() {
+		name = randomizeCase(name)
+	}
+
 	req := &dns.Msg{}
-	req.SetQuestion(name, questionType)
+	req.SetQuestion(name, q.Qtype)
+	req.Question[0].Qclass = q.Qclass
+	r.setEDNS0(req)
+
+	var servers []string
+	if r.DoH != nil {
+		servers = r.DoH.URLs
+	} else {
+		servers = r.Config.Servers
+	}
 
-	for _, s := range r.Config.Servers {
+	for _, s := range servers {
 		if ctx.Err() != nil {
-			return nil, false, ctx.Err()
+			return nil, ctx.Err()
 		}
 
-		addr := net.JoinHostPort(s, r.Config.Port)
-		res, ok := r.queryServer(ctx, addr, req)
+		res, ok := r.queryServerWithEDNS0Fallback(ctx, s, req)
 
 		// Server was not contactable or had no response for this query.
 		if !ok {
@@ -272,33 +993,133 @@ func (r *UnicastResolver) query(
 
 		// The server responded authoratatively, even if it was only to indicate
 		// that this domain or record type does not exist.
-		if res.Rcode == dns.RcodeNameError {
-			break
-		}
-
-		// The server had an answer to this query.
-		if res.Rcode == dns.RcodeSuccess {
-			return res, true, nil
+		if res.Rcode == dns.RcodeNameError || res.Rcode == dns.RcodeSuccess {
+			if r.randomizesCase() {
+				res.Question[0].Name = q.Name
+			}
+			return res, nil
 		}
 	}
 
 	// None of the servers had a result for this query.
-	return nil, false, nil
+	return nil, nil
 }
 
-// query performs a DNS query against all of the servers in r.Config.
+// queryServer performs a DNS query against a single server, retrying
+// according to r.RetryPolicy if no response is received.
+//
+// If the server truncates a UDP response the query is repeated over TCP.
 func (r *UnicastResolver) queryServer(
 	ctx context.Context,
-	addr string,
+	server string,
 	req *dns.Msg,
 ) (*dns.Msg, bool) {
+	attempts := r.RetryPolicy.attempts()
+
+	for attempt := 0; ; attempt++ {
+		res, ok := r.attemptQuery(ctx, server, req, false)
+
+		if ok && res.Truncated && r.usesUDP() {
+			logAttrs(
+				r.Logger,
+				slog.LevelDebug,
+				"DNS response was truncated, retrying over TCP",
+				append(
+					questionAttrs(req.Question[0]),
+					slog.String("server", server),
+				)...,
+			)
+
+			res, ok = r.attemptQuery(ctx, server, req, true)
+		}
+
+		if ok || attempt+1 == attempts {
+			return res, ok
+		}
+
+		if r.RetryPolicy.wait(ctx, attempt) != nil {
+			return nil, false
+		}
+
+		logAttrs(
+			r.Logger,
+			slog.LevelDebug,
+			"retrying DNS query",
+			append(
+				questionAttrs(req.Question[0]),
+				slog.String("server", server),
+				slog.Int("attempt", attempt+2),
+			)...,
+		)
+	}
+}
+
+// usesUDP returns true if queries are sent to servers over UDP.
+func (r *UnicastResolver) usesUDP() bool {
+	if r.DoH != nil || r.DoT != nil {
+		return false
+	}
+
+	return r.Client == nil || r.Client.Net == "" || strings.HasPrefix(r.Client.Net, "udp")
+}
+
+// attemptQuery makes a single attempt at performing a DNS query against a
+// single server. If overTCP is true the query is sent over TCP regardless of
+// the network used by r.Client.
+func (r *UnicastResolver) attemptQuery(
+	ctx context.Context,
+	server string,
+	req *dns.Msg,
+	overTCP bool,
+) (*dns.Msg, bool) {
+	if r.RetryPolicy.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.RetryPolicy.Timeout)
+		defer cancel()
+	}
+
+	if r.DoH != nil {
+		return r.queryDoH(ctx, server, req)
+	}
+
 	client := r.Client
 	if client == nil {
 		client = &dns.Client{}
 	}
 
-	conn, err := client.Dial(addr)
+	addr := net.JoinHostPort(server, r.Config.Port)
+
+	if overTCP {
+		c := *client
+		c.Net = "tcp" + strings.TrimPrefix(c.Net, "udp")
+		client = &c
+	}
+
+	if r.DoT != nil {
+		c := *client
+		c.Net = "tcp-tls"
+		c.TLSConfig = r.DoT.tlsConfig(server)
+		client = &c
+
+		addr = r.DoT.address(server)
+	}
+
+	attrs := append(
+		questionAttrs(req.Question[0]),
+		slog.String("server", addr),
+	)
+
+	start := time.Now()
+
+	conn, err := r.dial(ctx, client, addr)
 	if err != nil {
+		r.observeResponse(ctx, addr, req.Question[0], start, nil, err)
+		logAttrs(
+			r.Logger,
+			slog.LevelDebug,
+			"unable to connect to DNS server",
+			append(attrs, slog.Any("error", err))...,
+		)
 		return nil, false
 	}
 
@@ -316,6 +1137,39 @@ func (r *UnicastResolver) queryServer(
 		conn.Close()
 	}()
 
-	res, _, _ := client.ExchangeWithConn(req, conn)
-	return res, res != nil
+	res, _, err := client.ExchangeWithConn(req, conn)
+	r.observeResponse(ctx, addr, req.Question[0], start, res, err)
+
+	if res == nil {
+		logAttrs(
+			r.Logger,
+			slog.LevelDebug,
+			"unable to query DNS server",
+			append(attrs, slog.Any("error", err))...,
+		)
+		return nil, false
+	}
+
+	if r.randomizesCase() && !questionMatches(req, res) {
+		logAttrs(
+			r.Logger,
+			slog.LevelWarn,
+			"discarding DNS response that does not match the query",
+			attrs...,
+		)
+		return nil, false
+	}
+
+	logAttrs(
+		r.Logger,
+		slog.LevelDebug,
+		"received DNS response",
+		append(
+			attrs,
+			rcodeAttr(res.Rcode),
+			slog.Int("answers", len(res.Answer)),
+		)...,
+	)
+
+	return res, true
 }
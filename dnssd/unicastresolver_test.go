@@ -1,8 +1,27 @@
 package dnssd_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	. "github.com/dogmatiq/dissolve/dnssd"
@@ -73,28 +92,30 @@ var _ = Context("UnicastResolver", func() {
 
 		server = &UnicastServer{}
 
-		server.Advertise(
+		Expect(server.Advertise(
 			instanceA,
 			WithServiceSubType("_printer"),
-		)
+		)).To(Succeed())
 
-		server.Advertise(
+		Expect(server.Advertise(
 			instanceB,
 			WithIPAddress(net.IPv4(192, 168, 20, 1)),
 			WithIPAddress(net.ParseIP("fe80::1ce5:3c8b:36f:53cf")),
-		)
+		)).To(Succeed())
 
-		server.Advertise(instanceC)
+		Expect(server.Advertise(instanceC)).To(Succeed())
 
 		serverResult = make(chan error, 1)
 
+		// Bind the socket before starting the server so that it is ready to
+		// receive queries as soon as the test begins.
+		conn, err := net.ListenPacket("udp", "127.0.0.1:65353")
+		Expect(err).ShouldNot(HaveOccurred())
+
 		go func() {
-			serverResult <- server.Run(ctx, "udp", "127.0.0.1:65353")
+			serverResult <- server.RunWithPacketConn(ctx, conn)
 		}()
 
-		// Fudge-factor to allow the server time to start.
-		time.Sleep(100 * time.Millisecond)
-
 		resolver = &UnicastResolver{
 			Config: &dns.ClientConfig{
 				Servers: []string{"127.0.0.1"},
@@ -108,6 +129,23 @@ var _ = Context("UnicastResolver", func() {
 		Expect(<-serverResult).To(Equal(context.Canceled))
 	})
 
+	Describe("func NewUnicastResolver()", func() {
+		It("returns a resolver that uses the system's DNS configuration", func() {
+			if runtime.GOOS == "windows" {
+				Skip("the system configuration is not loaded from a file on Windows")
+			}
+
+			expect, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+			if err != nil {
+				Skip("the system does not have a readable /etc/resolv.conf")
+			}
+
+			r, err := NewUnicastResolver()
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(r.Config).To(Equal(expect))
+		})
+	})
+
 	Describe("func EnumerateServiceTypes()", func() {
 		It("returns the distinct service types advertised within the domain", func() {
 			serviceTypes, err := resolver.EnumerateServiceTypes(ctx, "example.org")
@@ -140,6 +178,254 @@ var _ = Context("UnicastResolver", func() {
 		})
 	})
 
+	Describe("func EnumerateInstanceDetails()", func() {
+		BeforeEach(func() {
+			// The server advertises instances without a TTL using the default.
+			instanceB.TTL = DefaultTTL
+		})
+
+		It("returns the details of the instances of the service type that are advertised within the domain", func() {
+			instances, err := resolver.EnumerateInstanceDetails(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(ConsistOf(instanceA, instanceB))
+		})
+
+		It("returns an empty slice if there are no instances of the service type", func() {
+			instances, err := resolver.EnumerateInstanceDetails(ctx, "_other._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(BeEmpty())
+		})
+	})
+
+	Describe("func StreamServiceTypes()", func() {
+		It("calls the function for each service type advertised within the domain", func() {
+			var serviceTypes []string
+			err := resolver.StreamServiceTypes(
+				ctx,
+				"example.org",
+				func(serviceType string) error {
+					serviceTypes = append(serviceTypes, serviceType)
+					return nil
+				},
+			)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(serviceTypes).To(ContainElements(
+				"_http._tcp",
+				"_other._udp",
+			))
+		})
+
+		It("stops enumerating and returns the error if the function returns an error", func() {
+			calls := 0
+			err := resolver.StreamServiceTypes(
+				ctx,
+				"example.org",
+				func(string) error {
+					calls++
+					return errors.New("<error>")
+				},
+			)
+			Expect(err).To(MatchError("<error>"))
+			Expect(calls).To(Equal(1))
+		})
+	})
+
+	Describe("func StreamInstances()", func() {
+		It("calls the function for each instance of the service type that is advertised within the domain", func() {
+			var instances []string
+			err := resolver.StreamInstances(
+				ctx,
+				"_http._tcp",
+				"example.org",
+				func(instance string) error {
+					instances = append(instances, instance)
+					return nil
+				},
+			)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(ConsistOf("Instance A", "Instance B"))
+		})
+
+		It("stops enumerating and returns the error if the function returns an error", func() {
+			calls := 0
+			err := resolver.StreamInstances(
+				ctx,
+				"_http._tcp",
+				"example.org",
+				func(string) error {
+					calls++
+					return errors.New("<error>")
+				},
+			)
+			Expect(err).To(MatchError("<error>"))
+			Expect(calls).To(Equal(1))
+		})
+	})
+
+	Describe("func StreamInstancesBySubType()", func() {
+		It("calls the function for each instance of the sub-type that is advertised within the domain", func() {
+			var instances []string
+			err := resolver.StreamInstancesBySubType(
+				ctx,
+				"_printer",
+				"_http._tcp",
+				"example.org",
+				func(instance string) error {
+					instances = append(instances, instance)
+					return nil
+				},
+			)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(ConsistOf("Instance A"))
+		})
+
+		It("stops enumerating and returns the error if the function returns an error", func() {
+			calls := 0
+			err := resolver.StreamInstancesBySubType(
+				ctx,
+				"_printer",
+				"_http._tcp",
+				"example.org",
+				func(string) error {
+					calls++
+					return errors.New("<error>")
+				},
+			)
+			Expect(err).To(MatchError("<error>"))
+			Expect(calls).To(Equal(1))
+		})
+	})
+
+	Describe("func StreamInstanceDetails()", func() {
+		BeforeEach(func() {
+			// The server advertises instances without a TTL using the default.
+			instanceB.TTL = DefaultTTL
+		})
+
+		It("calls the function with the details of each instance of the service type that is advertised within the domain", func() {
+			var instances []ServiceInstance
+			err := resolver.StreamInstanceDetails(
+				ctx,
+				"_http._tcp",
+				"example.org",
+				func(i ServiceInstance) error {
+					instances = append(instances, i)
+					return nil
+				},
+			)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(ConsistOf(instanceA, instanceB))
+		})
+
+		It("stops looking up instances and returns the error if the function returns an error", func() {
+			resolver.LookupConcurrency = 1
+
+			calls := 0
+			err := resolver.StreamInstanceDetails(
+				ctx,
+				"_http._tcp",
+				"example.org",
+				func(ServiceInstance) error {
+					calls++
+					return errors.New("<error>")
+				},
+			)
+			Expect(err).To(MatchError("<error>"))
+			Expect(calls).To(Equal(1))
+		})
+	})
+
+	Describe("func LookupInstances()", func() {
+		BeforeEach(func() {
+			// The server advertises instances without a TTL using the default.
+			instanceB.TTL = DefaultTTL
+			instanceC.TTL = DefaultTTL
+		})
+
+		It("returns the instances that can be resolved, in order", func() {
+			instances, err := resolver.LookupInstances(
+				ctx,
+				[]ServiceInstanceName{
+					instanceB.ServiceInstanceName,
+					{Name: "Instance X", ServiceType: "_http._tcp", Domain: "example.org"},
+					instanceC.ServiceInstanceName,
+					instanceA.ServiceInstanceName,
+				},
+			)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(Equal([]ServiceInstance{instanceB, instanceC, instanceA}))
+		})
+
+		It("honors the concurrency limit", func() {
+			resolver.LookupConcurrency = 1
+
+			instances, err := resolver.LookupInstances(
+				ctx,
+				[]ServiceInstanceName{
+					instanceA.ServiceInstanceName,
+					instanceB.ServiceInstanceName,
+				},
+			)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(Equal([]ServiceInstance{instanceA, instanceB}))
+		})
+
+		It("returns an error if the context is canceled", func() {
+			cancel()
+
+			_, err := resolver.LookupInstances(
+				ctx,
+				[]ServiceInstanceName{
+					instanceA.ServiceInstanceName,
+				},
+			)
+			Expect(err).To(Equal(context.Canceled))
+		})
+	})
+
+	Describe("func EnumerateDomains()", func() {
+		BeforeEach(func() {
+			startServer(
+				ctx,
+				"127.0.0.1:65354",
+				`b._dns-sd._udp.example.org. 120 IN PTR example.org.`,
+				`b._dns-sd._udp.example.org. 120 IN PTR printers.example.org.`,
+				`db._dns-sd._udp.example.org. 120 IN PTR example.org.`,
+				`r._dns-sd._udp.example.org. 120 IN PTR services.example.org.`,
+			)
+
+			resolver.Config.Port = "65354"
+		})
+
+		It("returns the domains recommended for browsing and registration", func() {
+			domains, err := resolver.EnumerateDomains(ctx, "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(domains.Browsing).To(ConsistOf("example.org", "printers.example.org"))
+			Expect(domains.DefaultBrowsing).To(ConsistOf("example.org"))
+			Expect(domains.LegacyBrowsing).To(BeEmpty())
+			Expect(domains.Registration).To(ConsistOf("services.example.org"))
+			Expect(domains.DefaultRegistration).To(BeEmpty())
+		})
+	})
+
+	Describe("func EnumerateDomainsByType()", func() {
+		BeforeEach(func() {
+			startServer(
+				ctx,
+				"127.0.0.1:65354",
+				`lb._dns-sd._udp.example.org. 120 IN PTR legacy.example.org.`,
+			)
+
+			resolver.Config.Port = "65354"
+		})
+
+		It("returns the domains of the given type", func() {
+			domains, err := resolver.EnumerateDomainsByType(ctx, LegacyBrowsingDomains, "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(domains).To(ConsistOf("legacy.example.org"))
+		})
+	})
+
 	Describe("func LookupServiceInstance()", func() {
 		It("returns complete information about the service instance", func() {
 			i, ok, err := resolver.LookupInstance(ctx, "Instance A", "_http._tcp", "example.org")
@@ -153,5 +439,1207 @@ var _ = Context("UnicastResolver", func() {
 			Expect(err).ShouldNot(HaveOccurred())
 			Expect(ok).To(BeFalse())
 		})
+
+		It("returns the service bindings if LookupServiceBindings is true", func() {
+			instanceA.Bindings = []ServiceBinding{
+				{
+					HTTPS:     true,
+					Priority:  1,
+					ALPN:      []string{"h3", "h2"},
+					IPv4Hints: []net.IP{net.IPv4(192, 168, 20, 1).To4()},
+				},
+				{
+					Priority: 2,
+					Target:   "alt.example.com",
+					Port:     8080,
+				},
+				{
+					Priority:  1,
+					IPv6Hints: []net.IP{net.ParseIP("fe80::1ce5:3c8b:36f:53cf")},
+				},
+			}
+			Expect(server.Advertise(instanceA)).To(Succeed())
+
+			i, ok, err := resolver.LookupInstance(ctx, "Instance A", "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(i.Bindings).To(BeEmpty())
+
+			resolver.LookupServiceBindings = true
+
+			i, ok, err = resolver.LookupInstance(ctx, "Instance A", "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(i.Bindings).To(Equal([]ServiceBinding{
+				instanceA.Bindings[2],
+				instanceA.Bindings[1],
+				instanceA.Bindings[0],
+			}))
+		})
+	})
+
+	Describe("additional records", func() {
+		var (
+			buf      *bytes.Buffer
+			harvest  *UnicastResolver
+			received = `msg="received DNS response"`
+		)
+
+		BeforeEach(func() {
+			startServer(
+				ctx,
+				"127.0.0.1:65354",
+				`_http._tcp.example.org. 120 IN PTR Instance\ A._http._tcp.example.org.`,
+				`Instance\ A._http._tcp.example.org. 120 IN SRV 10 20 12345 a.example.com.`,
+				`Instance\ A._http._tcp.example.org. 120 IN TXT "<key>=<instance-a>"`,
+			)
+
+			buf = &bytes.Buffer{}
+			harvest = &UnicastResolver{
+				Config: &dns.ClientConfig{
+					Servers: []string{"127.0.0.1"},
+					Port:    "65354",
+				},
+				Logger: slog.New(
+					slog.NewTextHandler(
+						buf,
+						&slog.HandlerOptions{Level: slog.LevelDebug},
+					),
+				),
+			}
+		})
+
+		It("uses the additional records from an enumeration response instead of querying again", func() {
+			instances, err := harvest.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(ConsistOf("Instance A"))
+			Expect(strings.Count(buf.String(), received)).To(Equal(1))
+
+			i, ok, err := harvest.LookupInstance(ctx, "Instance A", "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(i.TargetHost).To(Equal("a.example.com"))
+			Expect(i.Attributes).To(HaveLen(1))
+
+			// No further queries are sent.
+			Expect(strings.Count(buf.String(), received)).To(Equal(1))
+			Expect(buf.String()).To(ContainSubstring(`msg="using records from the additional section of a previous response" qname="Instance\\ A._http._tcp.example.org." qtype=SRV answers=1`))
+		})
+
+		It("uses the additional records only once", func() {
+			_, err := harvest.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			for range 2 {
+				_, ok, err := harvest.LookupInstance(ctx, "Instance A", "_http._tcp", "example.org")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(ok).To(BeTrue())
+			}
+
+			// 1 PTR, then SRV and TXT.
+			Expect(strings.Count(buf.String(), received)).To(Equal(3))
+		})
+	})
+
+	Describe("aliases", func() {
+		var aliased *UnicastResolver
+
+		BeforeEach(func() {
+			startServer(
+				ctx,
+				"127.0.0.1:65354",
+				`alias._http._tcp.example.org. 120 IN CNAME other.example.org.`,
+				`other.example.org. 120 IN CNAME Instance\ A._http._tcp.example.org.`,
+				`Instance\ A._http._tcp.example.org. 120 IN SRV 10 20 12345 a.example.com.`,
+				`Instance\ A._http._tcp.example.org. 120 IN TXT "<key>=<instance-a>"`,
+				`a.example.com. 120 IN CNAME host.example.net.`,
+				`loop._http._tcp.example.org. 120 IN CNAME loop.example.org.`,
+				`loop.example.org. 120 IN CNAME loop._http._tcp.example.org.`,
+			)
+
+			aliased = &UnicastResolver{
+				Config: &dns.ClientConfig{
+					Servers: []string{"127.0.0.1"},
+					Port:    "65354",
+				},
+			}
+		})
+
+		It("follows CNAME records for the instance name and the SRV target", func() {
+			i, ok, err := aliased.LookupInstance(ctx, "alias", "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(i.Name).To(Equal("alias"))
+			Expect(i.TargetHost).To(Equal("host.example.net"))
+			Expect(i.TargetPort).To(BeEquivalentTo(12345))
+			Expect(i.Attributes).To(HaveLen(1))
+		})
+
+		It("does not resolve an instance with a CNAME loop", func() {
+			_, ok, err := aliased.LookupInstance(ctx, "loop", "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("SRV target aliases", func() {
+		var (
+			buf      *bytes.Buffer
+			targeted *UnicastResolver
+			received = `msg="received DNS response"`
+		)
+
+		BeforeEach(func() {
+			startServer(
+				ctx,
+				"127.0.0.1:65354",
+				`Instance\ A._http._tcp.example.org. 120 IN SRV 10 20 12345 a.example.com.`,
+				`Instance\ A._http._tcp.example.org. 120 IN TXT "<key>=<instance-a>"`,
+				`Instance\ B._http._tcp.example.org. 120 IN SRV 10 20 12345 b.example.com.`,
+				`Instance\ B._http._tcp.example.org. 120 IN TXT "<key>=<instance-b>"`,
+				`a.example.com. 120 IN A 192.0.2.1`,
+				`b.example.com. 120 IN CNAME host.example.net.`,
+				`host.example.net. 120 IN A 192.0.2.2`,
+			)
+
+			buf = &bytes.Buffer{}
+			targeted = &UnicastResolver{
+				Config: &dns.ClientConfig{
+					Servers: []string{"127.0.0.1"},
+					Port:    "65354",
+				},
+				Logger: slog.New(
+					slog.NewTextHandler(
+						buf,
+						&slog.HandlerOptions{Level: slog.LevelDebug},
+					),
+				),
+			}
+		})
+
+		It("does not query for CNAME records if the SRV target is not an alias", func() {
+			i, ok, err := targeted.LookupInstance(ctx, "Instance A", "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(i.TargetHost).To(Equal("a.example.com"))
+
+			// Only the SRV and TXT queries are sent.
+			Expect(strings.Count(buf.String(), received)).To(Equal(2))
+			Expect(buf.String()).NotTo(ContainSubstring(`qtype=CNAME`))
+		})
+
+		It("follows CNAME records in the additional section of the SRV response", func() {
+			i, ok, err := targeted.LookupInstance(ctx, "Instance B", "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(i.TargetHost).To(Equal("host.example.net"))
+
+			// Only the SRV and TXT queries are sent.
+			Expect(strings.Count(buf.String(), received)).To(Equal(2))
+			Expect(buf.String()).NotTo(ContainSubstring(`qtype=CNAME`))
+		})
+	})
+
+	Describe("search domains", func() {
+		BeforeEach(func() {
+			resolver.Config.Search = []string{"example.com", "org"}
+			resolver.Config.Ndots = 1
+		})
+
+		It("tries each search domain when the domain is empty", func() {
+			resolver.Config.Search = []string{"example.com", "example.org"}
+
+			instances, err := resolver.EnumerateInstances(ctx, "_http._tcp", "")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(ConsistOf("Instance A", "Instance B"))
+		})
+
+		It("appends the search domains to a domain with fewer than ndots dots", func() {
+			serviceTypes, err := resolver.EnumerateServiceTypes(ctx, "example")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(serviceTypes).To(ConsistOf("_http._tcp", "_other._udp"))
+		})
+
+		It("tries a domain with at least ndots dots before appending the search domains", func() {
+			resolver.Config.Search = []string{"com"}
+
+			instances, err := resolver.EnumerateInstancesBySubType(ctx, "_printer", "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(ConsistOf("Instance A"))
+		})
+
+		It("does not expand a domain with a trailing dot", func() {
+			instances, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(BeEmpty())
+
+			instances, err = resolver.EnumerateInstances(ctx, "_http._tcp", "example.org.")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(ConsistOf("Instance A", "Instance B"))
+		})
+
+		It("returns the instance within the domain in which it was found", func() {
+			i, ok, err := resolver.LookupInstance(ctx, "Instance A", "_http._tcp", "example")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(i).To(Equal(instanceA))
+		})
+	})
+
+	Describe("parse modes", func() {
+		var malformed *UnicastResolver
+
+		BeforeEach(func() {
+			startServer(
+				ctx,
+				"127.0.0.1:65354",
+				`_services._dns-sd._udp.example.org. 120 IN PTR _http._tcp.example.org.`,
+				`_services._dns-sd._udp.example.org. 120 IN PTR _http._tcp.example.com.`,
+				`_http._tcp.example.org. 120 IN PTR Instance\ A._http._tcp.example.org.`,
+				`_http._tcp.example.org. 120 IN PTR Caf\195\169._http._tcp.example.org.`,
+				`_http._tcp.example.org. 120 IN PTR Instance\ B._other._udp.example.org.`,
+				`_http._tcp.example.org. 120 IN PTR Instance\ C._http._tcp.example.com.`,
+				`Instance\ A._http._tcp.example.org. 120 IN SRV 10 20 12345 a.example.com.`,
+				`Instance\ A._http._tcp.example.org. 120 IN TXT "<key>=<instance-a>" "\009=<invalid>"`,
+				`Caf\195\169._http._tcp.example.org. 120 IN SRV 10 20 12345 b.example.com.`,
+				`Caf\195\169._http._tcp.example.org. 120 IN TXT "path=C:\\Menu\"s\""`,
+			)
+
+			malformed = &UnicastResolver{
+				Config: &dns.ClientConfig{
+					Servers: []string{"127.0.0.1"},
+					Port:    "65354",
+				},
+			}
+		})
+
+		It("decodes escape sequences in instance names and TXT records", func() {
+			instances, err := malformed.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(ConsistOf("Instance A", "Café"))
+
+			i, ok, err := malformed.LookupInstance(ctx, "Café", "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(i.Attributes).To(HaveLen(1))
+
+			v, ok := i.Attributes[0].Get("path")
+			Expect(ok).To(BeTrue())
+			Expect(string(v)).To(Equal(`C:\Menu"s"`))
+		})
+
+		When("using lenient parsing", func() {
+			It("skips service types outside the domain", func() {
+				serviceTypes, err := malformed.EnumerateServiceTypes(ctx, "example.org")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(serviceTypes).To(ConsistOf("_http._tcp"))
+			})
+
+			It("skips instances outside the service type and domain", func() {
+				buf := &bytes.Buffer{}
+				malformed.Logger = slog.New(slog.NewTextHandler(buf, nil))
+
+				instances, err := malformed.EnumerateInstances(ctx, "_http._tcp", "example.org")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(instances).To(ConsistOf("Instance A", "Café"))
+				Expect(buf.String()).To(ContainSubstring(`error="service instance \"Instance\\\\ B._other._udp.example.org.\" is not within the \"_http._tcp.example.org.\" domain"`))
+			})
+
+			It("skips malformed TXT record values", func() {
+				buf := &bytes.Buffer{}
+				malformed.Logger = slog.New(slog.NewTextHandler(buf, nil))
+
+				i, ok, err := malformed.LookupInstance(ctx, "Instance A", "_http._tcp", "example.org")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(ok).To(BeTrue())
+				Expect(i.Attributes).To(Equal(instanceA.Attributes))
+				Expect(buf.String()).To(ContainSubstring(`msg="skipped malformed DNS record"`))
+			})
+		})
+
+		When("using strict parsing", func() {
+			BeforeEach(func() {
+				malformed.ParseMode = StrictParsing
+			})
+
+			It("returns an error if there are service types outside the domain", func() {
+				_, err := malformed.EnumerateServiceTypes(ctx, "example.org")
+				Expect(err).To(MatchError(`service type "_http._tcp.example.com." is not within the "example.org" domain`))
+			})
+
+			It("returns an error if there are instances outside the service type and domain", func() {
+				_, err := malformed.EnumerateInstances(ctx, "_http._tcp", "example.org")
+
+				var target *OutOfDomainError
+				Expect(errors.As(err, &target)).To(BeTrue())
+				Expect(target.Domain).To(Equal("_http._tcp.example.org."))
+			})
+
+			It("returns an error if a TXT record value is malformed", func() {
+				_, _, err := malformed.LookupInstance(ctx, "Instance A", "_http._tcp", "example.org")
+				Expect(err).To(MatchError(ContainSubstring("unable to parse TXT record")))
+			})
+		})
+	})
+
+	Describe("negative caching", func() {
+		var (
+			buf    *bytes.Buffer
+			cached *UnicastResolver
+		)
+
+		BeforeEach(func() {
+			startServer(
+				ctx,
+				"127.0.0.1:65354",
+				`example.org. 120 IN SOA ns.example.org. admin.example.org. 1 3600 600 86400 60`,
+				`Instance\ A._http._tcp.example.org. 120 IN TXT "<key>=<instance-a>"`,
+			)
+
+			buf = &bytes.Buffer{}
+			cached = &UnicastResolver{
+				Config: &dns.ClientConfig{
+					Servers: []string{"127.0.0.1"},
+					Port:    "65354",
+				},
+				Logger: slog.New(
+					slog.NewTextHandler(
+						buf,
+						&slog.HandlerOptions{Level: slog.LevelDebug},
+					),
+				),
+			}
+		})
+
+		It("caches NXDOMAIN responses", func() {
+			for range 2 {
+				instances, err := cached.EnumerateInstances(ctx, "_http._tcp", "example.org")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(instances).To(BeEmpty())
+			}
+
+			Expect(strings.Count(buf.String(), `msg="received DNS response"`)).To(Equal(1))
+			Expect(buf.String()).To(ContainSubstring(`msg="using cached negative response" qname=_http._tcp.example.org. qtype=PTR`))
+		})
+
+		It("applies cached NXDOMAIN responses to all record types", func() {
+			_, ok, err := cached.LookupInstance(ctx, "Instance B", "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ok).To(BeFalse())
+
+			Expect(strings.Count(buf.String(), `msg="received DNS response"`)).To(Equal(2))
+
+			_, ok, err = cached.LookupInstance(ctx, "Instance B", "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ok).To(BeFalse())
+
+			Expect(strings.Count(buf.String(), `msg="received DNS response"`)).To(Equal(2))
+		})
+
+		It("caches NODATA responses for the queried record type only", func() {
+			for range 2 {
+				_, ok, err := cached.LookupInstance(ctx, "Instance A", "_http._tcp", "example.org")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(ok).To(BeFalse())
+			}
+
+			// The TXT query is made each time, the SRV query is only made once.
+			Expect(strings.Count(buf.String(), `msg="received DNS response"`)).To(Equal(3))
+			Expect(buf.String()).To(ContainSubstring(`msg="using cached negative response" qname="Instance\\ A._http._tcp.example.org." qtype=SRV`))
+		})
+
+		It("does not cache negative responses without an SOA record", func() {
+			resolver.Logger = cached.Logger
+
+			for range 2 {
+				_, ok, err := resolver.LookupInstance(ctx, "Instance X", "_http._tcp", "example.org")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(ok).To(BeFalse())
+			}
+
+			Expect(strings.Count(buf.String(), `msg="received DNS response"`)).To(Equal(4))
+			Expect(buf.String()).NotTo(ContainSubstring(`msg="using cached negative response"`))
+		})
+	})
+
+	Describe("retry policy", func() {
+		var (
+			drop, requests *atomic.Int32
+		)
+
+		BeforeEach(func() {
+			drop = &atomic.Int32{}
+			requests = &atomic.Int32{}
+
+			// Capture the variables used by the handler so that it does not
+			// race with the setup of subsequent tests.
+			upstream, dropped, count := server, drop, requests
+
+			started := make(chan struct{})
+			lossy := &dns.Server{
+				Net:               "udp",
+				Addr:              "127.0.0.1:65354",
+				NotifyStartedFunc: func() { close(started) },
+				Handler: dns.HandlerFunc(
+					func(w dns.ResponseWriter, req *dns.Msg) {
+						if count.Add(1) <= dropped.Load() {
+							return
+						}
+						upstream.ServeDNS(w, req)
+					},
+				),
+			}
+
+			go func() {
+				_ = lossy.ListenAndServe()
+			}()
+
+			done := ctx.Done()
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				<-done
+				_ = lossy.Shutdown()
+			}()
+
+			// Wait for the server to release its port before the next test.
+			DeferCleanup(func() {
+				<-stopped
+			})
+
+			Eventually(started).Should(BeClosed())
+
+			resolver.Config.Port = "65354"
+			resolver.RetryPolicy = RetryPolicy{
+				Attempts: 3,
+				Timeout:  100 * time.Millisecond,
+			}
+		})
+
+		It("retries queries that receive no response", func() {
+			drop.Store(2)
+
+			instances, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(ConsistOf("Instance A", "Instance B"))
+			Expect(requests.Load()).To(BeEquivalentTo(3))
+		})
+
+		It("gives up after the maximum number of attempts", func() {
+			drop.Store(3)
+
+			instances, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(BeEmpty())
+			Expect(requests.Load()).To(BeEquivalentTo(3))
+		})
+
+		It("does not retry queries that receive a response", func() {
+			_, ok, err := resolver.LookupInstance(ctx, "Instance X", "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ok).To(BeFalse())
+			Expect(requests.Load()).To(BeEquivalentTo(2)) // SRV and TXT
+		})
+
+		It("waits between attempts", func() {
+			drop.Store(2)
+			resolver.RetryPolicy.Backoff = 100 * time.Millisecond
+
+			start := time.Now()
+			instances, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(ConsistOf("Instance A", "Instance B"))
+
+			// 2 timeouts of 100ms, plus delays of 100ms and 200ms.
+			Expect(time.Since(start)).To(BeNumerically(">=", 500*time.Millisecond))
+		})
+
+		It("uses the query timeout given by WithQueryTimeout() in place of the timeout in the config", func() {
+			drop.Store(1)
+			resolver.Config.Timeout = 5
+			resolver.RetryPolicy = RetryPolicy{}
+
+			start := time.Now()
+			instances, err := resolver.EnumerateInstances(
+				ctx,
+				"_http._tcp",
+				"example.org",
+				WithQueryTimeout(100*time.Millisecond),
+			)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(BeEmpty())
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		})
+
+		It("stops retrying when the deadline given by WithOverallDeadline() is reached", func() {
+			drop.Store(100)
+			resolver.RetryPolicy.Attempts = 100
+
+			start := time.Now()
+			_, ok, err := resolver.LookupInstance(
+				ctx,
+				"Instance A",
+				"_http._tcp",
+				"example.org",
+				WithOverallDeadline(start.Add(250*time.Millisecond)),
+			)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ok).To(BeFalse())
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		})
+	})
+
+	Describe("metrics", func() {
+		var metrics *resolverMetrics
+
+		BeforeEach(func() {
+			metrics = &resolverMetrics{}
+			resolver.Metrics = metrics
+		})
+
+		It("reports the response code of each response", func() {
+			_, ok, err := resolver.LookupInstance(ctx, "Instance X", "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ok).To(BeFalse())
+
+			Expect(metrics.responses()).To(ConsistOf(
+				"127.0.0.1:65353 Instance\\ X._http._tcp.example.org. SRV NXDOMAIN",
+				"127.0.0.1:65353 Instance\\ X._http._tcp.example.org. TXT NXDOMAIN",
+			))
+		})
+
+		It("reports queries that time out", func() {
+			resolver.Config.Port = "65354"
+			resolver.RetryPolicy.Timeout = 100 * time.Millisecond
+
+			conn, err := net.ListenPacket("udp", "127.0.0.1:65354")
+			Expect(err).ShouldNot(HaveOccurred())
+			defer conn.Close()
+
+			_, err = resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(metrics.failures()).To(ConsistOf(
+				"127.0.0.1:65354 _http._tcp.example.org. PTR context deadline exceeded",
+			))
+		})
+	})
+
+	Describe("EDNS(0)", func() {
+		var (
+			rejectEDNS0 *atomic.Bool
+			udpSizes    chan uint16
+		)
+
+		BeforeEach(func() {
+			rejectEDNS0 = &atomic.Bool{}
+			udpSizes = make(chan uint16, 10)
+
+			// Capture the variables used by the handler so that it does not
+			// race with the setup of subsequent tests.
+			upstream, reject, sizes := server, rejectEDNS0, udpSizes
+
+			started := make(chan struct{})
+			edns := &dns.Server{
+				Net:               "udp",
+				Addr:              "127.0.0.1:65354",
+				NotifyStartedFunc: func() { close(started) },
+				Handler: dns.HandlerFunc(
+					func(w dns.ResponseWriter, req *dns.Msg) {
+						opt := req.IsEdns0()
+						if opt == nil {
+							sizes <- 0
+						} else {
+							sizes <- opt.UDPSize()
+
+							if reject.Load() {
+								res := &dns.Msg{}
+								res.SetRcode(req, dns.RcodeFormatError)
+								_ = w.WriteMsg(res)
+								return
+							}
+						}
+
+						upstream.ServeDNS(w, req)
+					},
+				),
+			}
+
+			go func() {
+				_ = edns.ListenAndServe()
+			}()
+
+			done := ctx.Done()
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				<-done
+				_ = edns.Shutdown()
+			}()
+
+			// Wait for the server to release its port before the next test.
+			DeferCleanup(func() {
+				<-stopped
+			})
+
+			Eventually(started).Should(BeClosed())
+
+			resolver.Config.Port = "65354"
+		})
+
+		It("advertises the default UDP payload size", func() {
+			_, err := resolver.EnumerateServiceTypes(ctx, "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(udpSizes).To(Receive(BeEquivalentTo(DefaultUDPSize)))
+		})
+
+		It("advertises the configured UDP payload size", func() {
+			resolver.UDPSize = 4096
+
+			_, err := resolver.EnumerateServiceTypes(ctx, "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(udpSizes).To(Receive(BeEquivalentTo(4096)))
+		})
+
+		It("does not use EDNS(0) if it is disabled", func() {
+			resolver.DisableEDNS0 = true
+
+			_, err := resolver.EnumerateServiceTypes(ctx, "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(udpSizes).To(Receive(BeZero()))
+		})
+
+		It("retries without EDNS(0) if the server rejects it", func() {
+			rejectEDNS0.Store(true)
+
+			instances, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(ConsistOf("Instance A", "Instance B"))
+			Expect(udpSizes).To(Receive(BeEquivalentTo(DefaultUDPSize)))
+			Expect(udpSizes).To(Receive(BeZero()))
+		})
+	})
+
+	Describe("case randomization", func() {
+		var (
+			lowercase *atomic.Bool
+			names     chan string
+		)
+
+		BeforeEach(func() {
+			lowercase = &atomic.Bool{}
+			names = make(chan string, 10)
+
+			// Capture the variables used by the middleware so that it does not
+			// race with the setup of subsequent tests.
+			lower, received := lowercase, names
+
+			// The names are observed by middleware on a separate server,
+			// which is configured before it starts so that the middleware
+			// does not race with the server's request handling.
+			cased := &UnicastServer{
+				Middleware: []ResponseMiddleware{
+					func(next ResponseFunc) ResponseFunc {
+						return func(ctx context.Context, client net.Addr, req *dns.Msg) *dns.Msg {
+							select {
+							case received <- req.Question[0].Name:
+							default:
+							}
+
+							res := next(ctx, client, req)
+							if res != nil && lower.Load() {
+								res.Question[0].Name = strings.ToLower(res.Question[0].Name)
+							}
+
+							return res
+						}
+					},
+				},
+			}
+
+			Expect(cased.Advertise(instanceA)).To(Succeed())
+			Expect(cased.Advertise(instanceB)).To(Succeed())
+
+			conn, err := net.ListenPacket("udp", "127.0.0.1:65354")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				_ = cased.RunWithPacketConn(ctx, conn)
+			}()
+
+			// Wait for the server to release its port before the next test.
+			DeferCleanup(func() {
+				<-stopped
+			})
+
+			resolver.Config.Port = "65354"
+			resolver.RandomizeCase = true
+		})
+
+		It("randomizes the case of the query name", func() {
+			instances, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(ConsistOf("Instance A", "Instance B"))
+
+			var name string
+			Expect(names).To(Receive(&name))
+			Expect(strings.ToLower(name)).To(Equal("_http._tcp.example.org."))
+			Expect(name).NotTo(Equal("_http._tcp.example.org."))
+		})
+
+		It("discards responses that do not repeat the query name exactly", func() {
+			lowercase.Store(true)
+
+			instances, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(BeEmpty())
+		})
+
+		It("does not randomize the case of the query name if disabled", func() {
+			resolver.RandomizeCase = false
+			lowercase.Store(true)
+
+			instances, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(ConsistOf("Instance A", "Instance B"))
+			Expect(names).To(Receive(Equal("_http._tcp.example.org.")))
+		})
+	})
+
+	Describe("middleware", func() {
+		It("invokes each middleware around every query, outermost first", func() {
+			var calls []string
+
+			record := func(label string) QueryMiddleware {
+				return func(next QueryFunc) QueryFunc {
+					return func(ctx context.Context, q dns.Question) (*dns.Msg, error) {
+						calls = append(calls, label+" before "+q.Name)
+						res, err := next(ctx, q)
+						calls = append(calls, fmt.Sprintf("%s after %s (%d answers)", label, q.Name, len(res.Answer)))
+						return res, err
+					}
+				}
+			}
+
+			resolver.Middleware = []QueryMiddleware{
+				record("outer"),
+				record("inner"),
+			}
+
+			_, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(calls).To(Equal([]string{
+				"outer before _http._tcp.example.org.",
+				"inner before _http._tcp.example.org.",
+				"inner after _http._tcp.example.org. (2 answers)",
+				"outer after _http._tcp.example.org. (2 answers)",
+			}))
+		})
+
+		It("allows middleware to respond without querying the servers", func() {
+			resolver.Config.Servers = nil
+			resolver.Middleware = []QueryMiddleware{
+				func(next QueryFunc) QueryFunc {
+					return func(ctx context.Context, q dns.Question) (*dns.Msg, error) {
+						res := &dns.Msg{}
+						res.SetQuestion(q.Name, q.Qtype)
+						res.Answer = append(res.Answer, &dns.PTR{
+							Hdr: dns.RR_Header{
+								Name:   q.Name,
+								Rrtype: dns.TypePTR,
+								Class:  dns.ClassINET,
+							},
+							Ptr: "Instance\\ Z._http._tcp.example.org.",
+						})
+						return res, nil
+					}
+				},
+			}
+
+			instances, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(ConsistOf("Instance Z"))
+		})
+
+		It("returns errors produced by middleware", func() {
+			resolver.Middleware = []QueryMiddleware{
+				func(next QueryFunc) QueryFunc {
+					return func(ctx context.Context, q dns.Question) (*dns.Msg, error) {
+						return nil, errors.New("<error>")
+					}
+				},
+			}
+
+			_, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).To(MatchError("<error>"))
+		})
+	})
+
+	Describe("custom dialer", func() {
+		It("connects to the servers using the dialer", func() {
+			var dialed []string
+			dialer := &net.Dialer{}
+
+			resolver.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+				dialed = append(dialed, network+" "+address)
+				return dialer.DialContext(ctx, network, address)
+			}
+
+			instances, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(ConsistOf("Instance A", "Instance B"))
+			Expect(dialed).To(Equal([]string{"udp 127.0.0.1:65353"}))
+		})
+
+		It("does not return results if the dialer fails", func() {
+			resolver.DialContext = func(context.Context, string, string) (net.Conn, error) {
+				return nil, errors.New("<error>")
+			}
+
+			instances, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(BeEmpty())
+		})
+	})
+
+	Describe("DNS-over-TLS", func() {
+		var cert tls.Certificate
+
+		BeforeEach(func() {
+			cert = newCertificate()
+
+			started := make(chan struct{})
+			dotServer := &dns.Server{
+				Net:               "tcp-tls",
+				Addr:              "127.0.0.1:65355",
+				Handler:           server,
+				TLSConfig:         &tls.Config{Certificates: []tls.Certificate{cert}},
+				NotifyStartedFunc: func() { close(started) },
+			}
+
+			go func() {
+				_ = dotServer.ListenAndServe()
+			}()
+
+			done := ctx.Done()
+			go func() {
+				<-done
+				_ = dotServer.Shutdown()
+			}()
+
+			Eventually(started).Should(BeClosed())
+
+			pool := x509.NewCertPool()
+			pool.AddCert(cert.Leaf)
+
+			resolver.DoT = &DoTConfig{
+				TLSConfig: &tls.Config{RootCAs: pool},
+				Port:      "65355",
+			}
+		})
+
+		It("makes queries using DNS-over-TLS", func() {
+			instances, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(ConsistOf("Instance A", "Instance B"))
+		})
+
+		It("makes queries using DNS-over-TLS over connections from a custom dialer", func() {
+			var dialed []string
+			dialer := &net.Dialer{}
+
+			resolver.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+				dialed = append(dialed, network+" "+address)
+				return dialer.DialContext(ctx, network, address)
+			}
+
+			instances, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(ConsistOf("Instance A", "Instance B"))
+			Expect(dialed).To(Equal([]string{"tcp 127.0.0.1:65355"}))
+		})
+
+		It("does not fall back to plain DNS if the server's certificate can not be verified", func() {
+			resolver.DoT.TLSConfig = nil
+
+			instances, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(BeEmpty())
+		})
+
+		It("accepts a server certificate with a pinned key", func() {
+			digest := sha256.Sum256(cert.Leaf.RawSubjectPublicKeyInfo)
+			resolver.DoT.PinnedKeys = [][]byte{digest[:]}
+
+			instances, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(ConsistOf("Instance A", "Instance B"))
+		})
+
+		It("rejects a server certificate without a pinned key", func() {
+			digest := sha256.Sum256([]byte("<other key>"))
+			resolver.DoT.PinnedKeys = [][]byte{digest[:]}
+
+			instances, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(BeEmpty())
+		})
+	})
+
+	Describe("DNS-over-HTTPS", func() {
+		var endpoint *httptest.Server
+
+		BeforeEach(func() {
+			endpoint = httptest.NewTLSServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					defer GinkgoRecover()
+
+					if r.URL.Path == "/unavailable" {
+						w.WriteHeader(http.StatusServiceUnavailable)
+						return
+					}
+
+					data, err := io.ReadAll(r.Body)
+					Expect(err).ShouldNot(HaveOccurred())
+
+					req := &dns.Msg{}
+					err = req.Unpack(data)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(req.Id).To(BeZero())
+
+					rw := &responseWriter{}
+					server.ServeDNS(rw, req)
+
+					data, err = rw.Messages[0].Pack()
+					Expect(err).ShouldNot(HaveOccurred())
+
+					w.Header().Set("Content-Type", "application/dns-message")
+					_, _ = w.Write(data)
+				}),
+			)
+			DeferCleanup(endpoint.Close)
+
+			resolver.DoH = &DoHConfig{
+				URLs:   []string{endpoint.URL + "/dns-query"},
+				Client: endpoint.Client(),
+			}
+		})
+
+		It("makes queries using DNS-over-HTTPS", func() {
+			resolver.Config = nil
+
+			instances, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(ConsistOf("Instance A", "Instance B"))
+		})
+
+		It("tries the next URL if an endpoint fails", func() {
+			resolver.DoH.URLs = append(
+				[]string{endpoint.URL + "/unavailable"},
+				resolver.DoH.URLs...,
+			)
+
+			instances, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(ConsistOf("Instance A", "Instance B"))
+		})
+
+		It("does not fall back to plain DNS if the server's certificate can not be verified", func() {
+			resolver.DoH.Client = nil
+
+			instances, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(BeEmpty())
+		})
+	})
+
+	Describe("logging", func() {
+		It("logs the responses received from each server", func() {
+			buf := &bytes.Buffer{}
+			resolver.Logger = slog.New(
+				slog.NewTextHandler(
+					buf,
+					&slog.HandlerOptions{Level: slog.LevelDebug},
+				),
+			)
+
+			_, err := resolver.EnumerateInstances(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(buf.String()).To(ContainSubstring(`msg="received DNS response" qname=_http._tcp.example.org. qtype=PTR server=127.0.0.1:65353 rcode=NOERROR answers=2`))
+		})
 	})
 })
+
+// startServer starts a DNS server on addr that responds with the given
+// records, which are expressed in zone file format. It stops when ctx is
+// canceled.
+//
+// The records owned by the target of any PTR record, and the A, AAAA and CNAME
+// records owned by the target of any SRV record, are included in the
+// additional section of the response. Any SOA records are included in the
+// authority section of negative responses.
+// CNAME records are included in responses to queries for any type, but the
+// chain is not followed.
+func startServer(ctx context.Context, addr string, records ...string) {
+	var rrs []dns.RR
+	for _, r := range records {
+		rr, err := dns.NewRR(r)
+		Expect(err).ShouldNot(HaveOccurred())
+		rrs = append(rrs, rr)
+	}
+
+	started := make(chan struct{})
+	server := &dns.Server{
+		Net:               "udp",
+		Addr:              addr,
+		NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(
+			func(w dns.ResponseWriter, req *dns.Msg) {
+				res := &dns.Msg{}
+				res.SetReply(req)
+
+				q := req.Question[0]
+				exists := false
+				for _, rr := range rrs {
+					h := rr.Header()
+					if h.Name == q.Name {
+						exists = true
+						if h.Rrtype == q.Qtype || h.Rrtype == dns.TypeCNAME || q.Qtype == dns.TypeANY {
+							res.Answer = append(res.Answer, rr)
+						}
+					}
+				}
+
+				// Include the records owned by the target of each PTR record in
+				// the additional section, as per RFC 6763 section 12.1.
+				for _, ans := range res.Answer {
+					if ptr, ok := ans.(*dns.PTR); ok {
+						for _, rr := range rrs {
+							if rr.Header().Name == ptr.Ptr {
+								res.Extra = append(res.Extra, rr)
+							}
+						}
+					}
+				}
+
+				// Include the address and CNAME records owned by the target of
+				// each SRV record, as per RFC 6763 section 12.2.
+				for _, ans := range res.Answer {
+					if srv, ok := ans.(*dns.SRV); ok {
+						for _, rr := range rrs {
+							h := rr.Header()
+							if h.Name != srv.Target {
+								continue
+							}
+
+							switch h.Rrtype {
+							case dns.TypeA, dns.TypeAAAA, dns.TypeCNAME:
+								res.Extra = append(res.Extra, rr)
+							}
+						}
+					}
+				}
+
+				if len(res.Answer) == 0 {
+					if !exists {
+						res.Rcode = dns.RcodeNameError
+					}
+
+					for _, rr := range rrs {
+						if rr.Header().Rrtype == dns.TypeSOA {
+							res.Ns = append(res.Ns, rr)
+						}
+					}
+				}
+
+				_ = w.WriteMsg(res)
+			},
+		),
+	}
+
+	go func() {
+		defer GinkgoRecover()
+		_ = server.ListenAndServe()
+	}()
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		_ = server.Shutdown()
+	}()
+
+	// Wait for the server to release its port before the next test.
+	DeferCleanup(func() {
+		<-stopped
+	})
+
+	Eventually(started).Should(BeClosed())
+}
+
+// newCertificate returns a self-signed certificate that is valid for
+// 127.0.0.1.
+func newCertificate() tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	leaf, err := x509.ParseCertificate(der)
+	Expect(err).ShouldNot(HaveOccurred())
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+}
+
+// resolverMetrics is an implementation of ResolverMetrics that records the
+// outcome of each query.
+type resolverMetrics struct {
+	m        sync.Mutex
+	received []string
+	failed   []string
+}
+
+func (m *resolverMetrics) ResponseReceived(server string, q dns.Question, rcode int, _ time.Duration) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.received = append(
+		m.received,
+		fmt.Sprintf("%s %s %s %s", server, q.Name, dns.TypeToString[q.Qtype], dns.RcodeToString[rcode]),
+	)
+}
+
+func (m *resolverMetrics) QueryFailed(server string, q dns.Question, err error, _ time.Duration) {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	m.failed = append(
+		m.failed,
+		fmt.Sprintf("%s %s %s %s", server, q.Name, dns.TypeToString[q.Qtype], err),
+	)
+}
+
+func (m *resolverMetrics) responses() []string {
+	m.m.Lock()
+	defer m.m.Unlock()
+	return slices.Clone(m.received)
+}
+
+func (m *resolverMetrics) failures() []string {
+	m.m.Lock()
+	defer m.m.Unlock()
+	return slices.Clone(m.failed)
+}
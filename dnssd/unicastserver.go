@@ -2,10 +2,16 @@ package dnssd
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/miekg/dns"
+	"golang.org/x/sync/errgroup"
 )
 
 // DefaultUnicastQueryTimeout is the default time to allow for unicast DNS
@@ -23,8 +29,121 @@ type UnicastServer struct {
 	// If it is non-positive, DefaultUnicastQueryTimeout is used instead.
 	Timeout time.Duration
 
+	// Logger is the target for log messages about the instances that are
+	// advertised and the queries that are served.
+	//
+	// If it is nil, no logging is performed.
+	Logger *slog.Logger
+
+	// ParseMode controls how malformed queries are handled.
+	//
+	// In LenientParsing mode (the default), queries that the server can not
+	// answer because they do not contain exactly one question are ignored. In
+	// StrictParsing mode, the server responds to such queries with a FORMERR
+	// response code.
+	ParseMode ParseMode
+
+	// RcodePolicy controls the response code used for queries for names that
+	// have no records. The default is NXDomainPolicy.
+	RcodePolicy RcodePolicy
+
+	// TTLPolicy overrides the TTLs of the records that are served, by service
+	// type or record type. By default, the TTL of each instance is used.
+	TTLPolicy TTLPolicy
+
+	// Zones is the set of zones that the server is authoritative for.
+	//
+	// The SOA record of the zone that contains the queried name is included
+	// in the authority section of negative (NXDOMAIN and NODATA) responses,
+	// allowing resolvers to cache them as per RFC 2308.
+	Zones []Zone
+
+	// AllowUpdates, if true, causes the server to accept RFC 2136 DNS UPDATE
+	// messages that add and remove service instances, such as those sent by
+	// an SRPClient.
+	//
+	// All of the changes in an update are applied atomically. If the update
+	// requests a lease using the EDNS(0) Update Lease option, the instances it
+	// adds are removed when the lease expires, unless they are updated again
+	// before then.
+	//
+	// If it is false, all updates are rejected. When the server is used as a
+	// dns.Handler via ServeDNS(), the dns.Server must also be configured with
+	// a MsgAcceptFunc that accepts updates.
+	AllowUpdates bool
+
+	// TSIGSecrets is a map of TSIG key names to their base64-encoded secrets.
+	//
+	// If it is non-empty, DNS UPDATE messages are refused unless they are
+	// signed with one of these keys. When the server is used as a dns.Handler
+	// via ServeDNS(), the same secrets must be configured on the dns.Server.
+	//
+	// If it is empty, updates may instead be signed using SIG(0), as they are
+	// by an SRPClient. Any client may add an instance that is not already
+	// advertised, but an instance that was added by a signed update may only be
+	// replaced or removed by an update signed with the same key. Updates that
+	// would replace or remove any other instance, including one added by an
+	// unsigned update or advertised using Advertise(), are refused. Updates
+	// with invalid signatures are always refused.
+	TSIGSecrets map[string]string
+
+	// AllowedNetworks is the set of networks that clients may connect from.
+	//
+	// If it is non-empty, requests from clients with addresses outside of
+	// these networks are refused with a REFUSED response code.
+	AllowedNetworks []*net.IPNet
+
+	// DeniedNetworks is the set of networks that clients may not connect
+	// from. Requests from clients with addresses within these networks are
+	// refused, even if they are also within AllowedNetworks.
+	DeniedNetworks []*net.IPNet
+
+	// Authorize, if non-nil, is called to determine whether the client at the
+	// given address is permitted to make a request. If it returns false, the
+	// request is refused.
+	//
+	// It is only called for requests from clients that are permitted by
+	// AllowedNetworks and DeniedNetworks. It may be called concurrently.
+	Authorize func(client net.Addr, req *dns.Msg) bool
+
+	// Middleware is a set of functions that are invoked around the building
+	// of the response to every DNS query, such as to filter or rewrite the
+	// response for particular clients.
+	//
+	// The first middleware is the outermost, that is, it is the first to be
+	// invoked for each query. It is not invoked for DNS UPDATE messages, nor
+	// for requests that are refused by AllowedNetworks, DeniedNetworks or
+	// Authorize.
+	Middleware []ResponseMiddleware
+
+	// Views is a set of alternative record sets that are served to clients
+	// within particular networks, instead of the records advertised by this
+	// server.
+	//
+	// The first view with a network that contains the client's address is
+	// used. Clients that are not within any view, and queries made using
+	// Exchange(), are answered using this server's records.
+	Views []View
+
+	// UDPSize is the maximum UDP payload size of the responses sent to clients
+	// that support EDNS(0). It is advertised in the OPT record of each response
+	// to a query that contains one.
+	//
+	// Clients that advertise a smaller payload size receive responses no
+	// larger than that size. If it is zero, DefaultUDPSize is used.
+	UDPSize uint16
+
+	// Metrics, if non-nil, is notified of each query that is served, of
+	// contention for the server's internal lock and of changes to the number
+	// of advertised instances.
+	Metrics ServerMetrics
+
 	m sync.RWMutex
 
+	// serial is the number of times that the advertised records have changed.
+	// It is added to the serial number of each zone's SOA record.
+	serial uint32
+
 	// services store information about the records related to a specific
 	// service type.
 	//
@@ -38,9 +157,36 @@ type UnicastServer struct {
 	// The key is the fully-qualified service name.
 	instances map[string]*instanceRecords
 
+	// hosts stores the address records of the hosts advertised using
+	// AdvertiseHost().
+	//
+	// The key is the fully-qualified hostname.
+	hosts map[string][]dns.RR
+
+	// aliases stores the CNAME records advertised using AdvertiseAlias().
+	//
+	// The key is the fully-qualified alias.
+	aliases map[string]*dns.CNAME
+
+	// updated is the set of instances that were added by DNS UPDATE messages.
+	//
+	// The key is the fully-qualified service name.
+	updated map[string]*updateLease
+
 	// records is a map of domain to the records within that domain. The inner
 	// map maps record type to the records of that type.
+	//
+	// The key is the canonical (lowercase) form of the domain name, as names
+	// are matched case-insensitively. Use recordsAt() to look up records.
+	//
+	// See https://www.rfc-editor.org/rfc/rfc4343.
 	records map[string]map[uint16][]dns.RR
+
+	// descendants is the number of names in records that are below each name,
+	// which identifies empty non-terminals without scanning every record.
+	//
+	// The key is the canonical (lowercase) form of the ancestor's name.
+	descendants map[string]int
 }
 
 type serviceRecords struct {
@@ -61,29 +207,46 @@ type instanceRecords struct {
 //
 // Typically, these records would be served by a separate domain name server
 // that is authoratative for the internet domain name used in i.TargetHost.
-func (s *UnicastServer) Advertise(i ServiceInstance, options ...AdvertiseOption) {
-	name := AbsoluteServiceInstanceName(i.Name, i.ServiceType, i.Domain)
+//
+// It returns an error if any of the instance's records would be owned by the
+// name of an alias advertised using AdvertiseAlias(), as a name that has a
+// CNAME record must not have any other records.
+func (s *UnicastServer) Advertise(i ServiceInstance, options ...AdvertiseOption) error {
 	records := NewRecords(i, options...)
 
-	s.m.Lock()
+	s.lock()
 	defer s.m.Unlock()
 
+	if err := s.checkNotAlias(records); err != nil {
+		return err
+	}
+
+	s.advertiseRecords(i.ServiceInstanceName, records)
+
+	return nil
+}
+
+// advertiseRecords starts advertising the given records for the instance
+// with the given name, replacing any records that were previously advertised
+// for that instance. It assumes s.m is already locked for writing.
+func (s *UnicastServer) advertiseRecords(n ServiceInstanceName, records []dns.RR) {
+	name := n.Absolute()
+
 	if s.instances == nil {
 		s.services = map[string]*serviceRecords{}
 		s.instances = map[string]*instanceRecords{}
-		s.records = map[string]map[uint16][]dns.RR{}
 	} else {
 		s.removeInstance(name)
 	}
 
-	enumDomain := AbsoluteInstanceEnumerationDomain(i.ServiceType, i.Domain)
+	enumDomain := AbsoluteInstanceEnumerationDomain(n.ServiceType, n.Domain)
 
 	sr, ok := s.services[enumDomain]
 	if ok {
 		sr.instanceCount++
 	} else {
 		sr = &serviceRecords{
-			NewServiceTypePTRRecord(i.ServiceType, i.Domain, 0),
+			NewServiceTypePTRRecord(n.ServiceType, n.Domain, 0),
 			1,
 		}
 
@@ -92,26 +255,65 @@ func (s *UnicastServer) Advertise(i ServiceInstance, options ...AdvertiseOption)
 	}
 
 	s.instances[name] = &instanceRecords{sr, records}
+	s.serial++
 
 	for _, rr := range records {
 		s.addRecord(rr)
 	}
+
+	s.observeInstances()
+
+	logAttrs(
+		s.Logger,
+		slog.LevelInfo,
+		"advertising service instance",
+		append(
+			instanceAttrs(n),
+			slog.Int("records", len(records)),
+		)...,
+	)
 }
 
 // Remove stops advertising a DNS-SD service instance.
 func (s *UnicastServer) Remove(i ServiceInstance) {
-	name := AbsoluteServiceInstanceName(i.Name, i.ServiceType, i.Domain)
+	s.RemoveByName(i.ServiceInstanceName)
+}
 
-	s.m.Lock()
+// RemoveByName stops advertising the DNS-SD service instance with the given
+// name.
+//
+// All of the records that were advertised for the instance are removed,
+// including any records that were added by the options passed to Advertise().
+// It is a no-op if no such instance is advertised.
+func (s *UnicastServer) RemoveByName(n ServiceInstanceName) {
+	s.lock()
 	defer s.m.Unlock()
 
-	s.removeInstance(name)
+	s.removeByName(n)
 }
 
-func (s *UnicastServer) removeInstance(name string) {
+// removeByName stops advertising the DNS-SD service instance with the given
+// name. It assumes s.m is already locked for writing.
+func (s *UnicastServer) removeByName(n ServiceInstanceName) {
+	if s.removeInstance(n.Absolute()) {
+		s.observeInstances()
+
+		logAttrs(
+			s.Logger,
+			slog.LevelInfo,
+			"stopped advertising service instance",
+			instanceAttrs(n)...,
+		)
+	}
+}
+
+// removeInstance removes the records for the instance with the given
+// fully-qualified name. It returns false if there is no such instance. It
+// assumes s.m is already locked for writing.
+func (s *UnicastServer) removeInstance(name string) bool {
 	ir, ok := s.instances[name]
 	if !ok {
-		return
+		return false
 	}
 
 	ir.serviceRecords.instanceCount--
@@ -125,7 +327,17 @@ func (s *UnicastServer) removeInstance(name string) {
 		s.removeRecord(rr)
 	}
 
+	if l, ok := s.updated[name]; ok {
+		if l.Timer != nil {
+			l.Timer.Stop()
+		}
+		delete(s.updated, name)
+	}
+
 	delete(s.instances, name)
+	s.serial++
+
+	return true
 }
 
 // addRecord adds a record to the DNS server. It assumes s.m is already locked
@@ -133,21 +345,69 @@ func (s *UnicastServer) removeInstance(name string) {
 func (s *UnicastServer) addRecord(rr dns.RR) {
 	h := rr.Header()
 
-	domainRecords := s.records[h.Name]
+	if s.records == nil {
+		s.records = map[string]map[uint16][]dns.RR{}
+	}
+
+	name := dns.CanonicalName(h.Name)
+
+	domainRecords := s.records[name]
 	if domainRecords == nil {
 		domainRecords = map[uint16][]dns.RR{}
-		s.records[h.Name] = domainRecords
+		s.records[name] = domainRecords
+		s.countDescendant(name, 1)
 	}
 
 	domainRecords[h.Rrtype] = append(domainRecords[h.Rrtype], rr)
 }
 
+// countDescendant adds delta to the number of descendants of each ancestor of
+// name, which must be in canonical form. It assumes s.m is already locked for
+// writing.
+func (s *UnicastServer) countDescendant(name string, delta int) {
+	if s.descendants == nil {
+		s.descendants = map[string]int{}
+	}
+
+	for _, ancestor := range ancestors(name) {
+		n := s.descendants[ancestor] + delta
+		if n == 0 {
+			delete(s.descendants, ancestor)
+		} else {
+			s.descendants[ancestor] = n
+		}
+	}
+}
+
+// ancestors returns the names above name, from its parent up to and including
+// the root.
+func ancestors(name string) []string {
+	if name == "." {
+		return nil
+	}
+
+	var names []string
+	for _, i := range dns.Split(name)[1:] {
+		names = append(names, name[i:])
+	}
+
+	return append(names, ".")
+}
+
+// recordsAt returns the records owned by name, which is matched
+// case-insensitively. It assumes s.m is already locked for reading.
+func (s *UnicastServer) recordsAt(name string) map[uint16][]dns.RR {
+	return s.records[dns.CanonicalName(name)]
+}
+
 // removeRecord removes a record from the DNS server. It assumes s.m is already
 // locked for writing.
 func (s *UnicastServer) removeRecord(rr dns.RR) {
 	h := rr.Header()
 
-	domainRecords := s.records[h.Name]
+	name := dns.CanonicalName(h.Name)
+
+	domainRecords := s.records[name]
 	typeRecords := domainRecords[h.Rrtype]
 
 	for i, x := range typeRecords {
@@ -165,7 +425,8 @@ func (s *UnicastServer) removeRecord(rr dns.RR) {
 			// Likewise, if the domain contains no more records of any kind,
 			// remove the entire domainRecords map from s.records.
 			if len(domainRecords) == 0 {
-				delete(s.records, h.Name)
+				delete(s.records, name)
+				s.countDescendant(name, -1)
 			}
 
 			return
@@ -186,47 +447,118 @@ func (s *UnicastServer) removeRecord(rr dns.RR) {
 
 // Run runs the server until ctx is canceled or an error occurs.
 func (s *UnicastServer) Run(ctx context.Context, network, address string) error {
+	if err := s.validateZones(); err != nil {
+		return err
+	}
+
+	server := s.newServer()
+	server.Net = network
+	server.Addr = address
+
+	return serve(ctx, server, server.ListenAndServe)
+}
+
+// RunWithListener runs the server using a pre-existing stream listener, such
+// as a TCP listener, until ctx is canceled or an error occurs.
+//
+// It allows the server to use a listener that is created by some other means,
+// such as systemd socket activation or with custom socket options. l is
+// closed when the server stops.
+func (s *UnicastServer) RunWithListener(ctx context.Context, l net.Listener) error {
+	if err := s.validateZones(); err != nil {
+		l.Close()
+		return err
+	}
+
+	server := s.newServer()
+	server.Listener = l
+
+	return serve(ctx, server, server.ActivateAndServe)
+}
+
+// RunWithPacketConn runs the server using a pre-existing packet connection,
+// such as a UDP socket, until ctx is canceled or an error occurs.
+//
+// It allows the server to use a connection that is created by some other
+// means, such as systemd socket activation or with custom socket options. conn
+// is closed when the server stops.
+func (s *UnicastServer) RunWithPacketConn(ctx context.Context, conn net.PacketConn) error {
+	if err := s.validateZones(); err != nil {
+		conn.Close()
+		return err
+	}
+
+	server := s.newServer()
+	server.PacketConn = conn
+
+	return serve(ctx, server, server.ActivateAndServe)
+}
+
+// newServer returns a new dns.Server that serves requests using s.
+func (s *UnicastServer) newServer() *dns.Server {
 	timeout := s.Timeout
 	if timeout <= 0 {
 		timeout = DefaultUnicastQueryTimeout
 	}
 
-	server := &dns.Server{
-		Net:          network,
-		Addr:         address,
-		ReadTimeout:  timeout,
-		WriteTimeout: timeout,
+	return &dns.Server{
+		ReadTimeout:   timeout,
+		WriteTimeout:  timeout,
+		TsigSecret:    s.TSIGSecrets,
+		MsgAcceptFunc: s.acceptMessage,
+		// Read buffers must be large enough for EDNS(0) requests, such as the
+		// DNS UPDATE messages sent by SRP clients.
+		UDPSize: dns.DefaultMsgSize,
 		Handler: dns.HandlerFunc(
 			func(w dns.ResponseWriter, req *dns.Msg) {
 				defer w.Close()
-
-				if res, ok := s.buildResponse(req); ok {
-					_ = w.WriteMsg(res)
-				}
+				s.ServeDNS(w, req)
 			},
 		),
 	}
+}
 
-	// Create a context we can cancel when we exit so we can always signal
-	// server.Shutdown() to be called.
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	// Create a channel that is used to signal when server.Shutdown() has
-	// completed.
+// serve runs server until ctx is canceled or an error occurs.
+//
+// run is the function that starts the server, such as server.ListenAndServe.
+func serve(ctx context.Context, server *dns.Server, run func() error) error {
+	// started is closed when the server has started listening. Shutdown()
+	// fails if it is called before the server has started.
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+
+	// returned is closed when run() has returned, whether or not the server
+	// ever started.
+	returned := make(chan struct{})
+
+	// Create a channel that is used to signal when the shutdown goroutine has
+	// ended.
 	done := make(chan struct{})
 
 	go func() {
-		defer close(done)     // signal shutdown goroutine has ended
-		<-ctx.Done()          // wait for cancellation
-		_ = server.Shutdown() // shutdown server
+		defer close(done)
+
+		// wait for cancellation, or for the server to fail
+		select {
+		case <-ctx.Done():
+		case <-returned:
+			return
+		}
+
+		// wait for the server to start before shutting it down
+		select {
+		case <-started:
+			_ = server.Shutdown()
+		case <-returned:
+		}
 	}()
 
+	err := run()
+	close(returned)
+
 	// Always wait for the shutdown goroutine to finish before actually
 	// returning.
-	defer func() { <-done }()
-
-	err := server.ListenAndServe()
+	<-done
 
 	// If the context was canceled we don't care about whatever listener-related
 	// error is reported to us, just tell the caller about the context error.
@@ -237,6 +569,155 @@ func (s *UnicastServer) Run(ctx context.Context, network, address string) error
 	return err
 }
 
+// RunAll runs the server on both UDP and TCP at the given address until ctx is
+// canceled or an error occurs.
+//
+// TCP is required to serve responses that are too large for UDP. If either
+// listener fails, the other is stopped and the error is returned.
+func (s *UnicastServer) RunAll(ctx context.Context, address string) error {
+	g, ctx := errgroup.WithContext(ctx)
+
+	for _, network := range []string{"udp", "tcp"} {
+		g.Go(func() error {
+			return s.Run(ctx, network, address)
+		})
+	}
+
+	return g.Wait()
+}
+
+// Exchange returns the response to a DNS query without performing any network
+// I/O.
+//
+// It allows tests and embedded resolvers to query the advertised records
+// directly. The response is the same as would be sent to a network client,
+// except that the additional section is never truncated to fit within a UDP
+// payload, and AllowedNetworks, DeniedNetworks and Authorize are not
+// consulted. The middleware in s.Middleware is invoked with a nil client
+// address.
+//
+// It returns an error if req is not a query that contains exactly one
+// question, or if the middleware does not produce a response.
+func (s *UnicastServer) Exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if req.Opcode != dns.OpcodeQuery {
+		return nil, fmt.Errorf("unsupported DNS opcode: %s", dns.OpcodeToString[req.Opcode])
+	}
+
+	if len(req.Question) != 1 {
+		return nil, fmt.Errorf("DNS query must contain exactly one question, got %d", len(req.Question))
+	}
+
+	res := s.respond(ctx, nil, req)
+	if res == nil {
+		return nil, errors.New("middleware did not produce a response to the DNS query")
+	}
+
+	s.setEDNS0(req, res)
+	s.observeQuery(req, res)
+
+	return res, nil
+}
+
+// ServeDNS responds to a DNS request using the advertised DNS-SD records.
+//
+// DNS UPDATE messages are applied to the advertised records if s.AllowUpdates
+// is true.
+//
+// It allows the server's records to be served by any DNS server that accepts
+// a dns.Handler, such as a CoreDNS plugin or a custom dns.ServeMux, instead of
+// by Run().
+//
+// It does not close w, leaving the connection's lifetime to the caller.
+func (s *UnicastServer) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	if !s.authorize(w.RemoteAddr(), req) {
+		s.refuse(w, req)
+		return
+	}
+
+	if req.Opcode == dns.OpcodeUpdate {
+		s.serveUpdate(w, req)
+		return
+	}
+
+	if len(req.Question) != 1 {
+		logAttrs(
+			s.Logger,
+			slog.LevelDebug,
+			"received DNS query that does not contain exactly one question",
+			slog.String("client", w.RemoteAddr().String()),
+			slog.Int("questions", len(req.Question)),
+		)
+
+		if s.ParseMode == StrictParsing {
+			res := &dns.Msg{}
+			res.SetRcodeFormatError(req)
+			if err := w.WriteMsg(res); err == nil {
+				s.observeQuery(req, res)
+			}
+		}
+
+		return
+	}
+
+	res := s.respond(context.Background(), w.RemoteAddr(), req)
+	if res == nil {
+		logAttrs(
+			s.Logger,
+			slog.LevelDebug,
+			"middleware did not produce a response to DNS query",
+			append(
+				questionAttrs(req.Question[0]),
+				slog.String("client", w.RemoteAddr().String()),
+			)...,
+		)
+		return
+	}
+
+	s.setEDNS0(req, res)
+
+	if _, ok := w.LocalAddr().(*net.UDPAddr); ok {
+		s.fitResponse(req, res)
+	}
+
+	attrs := append(
+		questionAttrs(req.Question[0]),
+		slog.String("client", w.RemoteAddr().String()),
+		rcodeAttr(res.Rcode),
+		slog.Int("answers", len(res.Answer)),
+	)
+
+	if err := w.WriteMsg(res); err != nil {
+		logAttrs(
+			s.Logger,
+			slog.LevelWarn,
+			"unable to write DNS response",
+			append(attrs, slog.Any("error", err))...,
+		)
+		return
+	}
+
+	s.observeQuery(req, res)
+	logAttrs(s.Logger, slog.LevelDebug, "served DNS query", attrs...)
+}
+
+// respond returns the response to req, which contains exactly one question,
+// by calling buildResponse() via the middleware in s.Middleware.
+//
+// client is the address of the client that sent req, or nil if it is unknown.
+// The response is built by the server of the client's view, if any.
+func (s *UnicastServer) respond(ctx context.Context, client net.Addr, req *dns.Msg) *dns.Msg {
+	build := func(_ context.Context, client net.Addr, req *dns.Msg) *dns.Msg {
+		res, _ := s.view(client).buildResponse(req)
+		return res
+	}
+
+	return withResponseMiddleware(build, s.Middleware)(ctx, client, req)
+}
+
 // buildResponse builds the response to send in reply to the given request.
 func (s *UnicastServer) buildResponse(req *dns.Msg) (*dns.Msg, bool) {
 	// We only support queries with exactly one question. The RFC allows for
@@ -254,20 +735,39 @@ func (s *UnicastServer) buildResponse(req *dns.Msg) (*dns.Msg, bool) {
 	res.SetReply(req)
 	res.Authoritative = true
 	res.RecursionAvailable = false
+	res.Compress = true
 
 	if q.Qclass != dns.ClassINET && q.Qclass != dns.ClassANY {
 		res.Rcode = dns.RcodeNameError
 		return res, true
 	}
 
-	s.m.RLock()
+	s.rlock()
 	defer s.m.RUnlock()
 
-	records := s.records[q.Name]
+	records := s.recordsAt(q.Name)
+	zone, inZone := s.findZone(q.Name)
+	isApex := inZone && equalNames(q.Name, zone.apex())
 
-	if len(records) == 0 {
-		res.Rcode = dns.RcodeNameError
-		return res, true
+	if len(records) == 0 && !isApex {
+		authoritative := s.RcodePolicy == AuthoritativePolicy
+
+		if authoritative && !inZone {
+			res.Rcode = dns.RcodeRefused
+			return res, true
+		}
+
+		// Empty non-terminals exist, so they are answered with NODATA below.
+		if !authoritative || !s.hasDescendants(q.Name) {
+			res.Rcode = dns.RcodeNameError
+			if inZone {
+				res.Ns = append(res.Ns, s.negativeSOARecord(zone))
+				if zone.signs() && wantsDNSSEC(req) {
+					s.signResponse(zone, res, nil)
+				}
+			}
+			return res, true
+		}
 	}
 
 	// Always use a copy of the records in res.Answer.
@@ -278,9 +778,159 @@ func (s *UnicastServer) buildResponse(req *dns.Msg) (*dns.Msg, bool) {
 		for _, recs := range records {
 			res.Answer = append(res.Answer, recs...)
 		}
+	} else if q.Qtype != dns.TypeCNAME && len(records[dns.TypeCNAME]) != 0 {
+		res.Answer = s.resolveAliases(q.Name, q.Qtype)
+		res.Extra = s.additionalRecords(res.Answer)
 	} else {
 		res.Answer = append([]dns.RR{}, records[q.Qtype]...)
+		res.Extra = s.additionalRecords(res.Answer)
+	}
+
+	if isApex && (q.Qtype == dns.TypeSOA || q.Qtype == dns.TypeANY) {
+		res.Answer = append(res.Answer, s.soaRecord(zone))
+	}
+
+	if isApex && (q.Qtype == dns.TypeNS || q.Qtype == dns.TypeANY) {
+		res.Answer = append(res.Answer, zone.nsRecords()...)
+	}
+
+	if isApex && (q.Qtype == dns.TypeDNSKEY || q.Qtype == dns.TypeANY) {
+		res.Answer = append(res.Answer, zone.dnskeyRecords()...)
+	}
+
+	// Apply the TTL overrides before the records are signed, as the TTL is
+	// covered by the signature.
+	res.Answer = s.TTLPolicy.apply(res.Answer)
+	res.Extra = s.TTLPolicy.apply(res.Extra)
+
+	// Include the SOA record in NODATA responses so that they can be cached.
+	if len(res.Answer) == 0 && inZone {
+		res.Ns = append(res.Ns, s.negativeSOARecord(zone))
+	}
+
+	if inZone && zone.signs() && wantsDNSSEC(req) {
+		var types []uint16
+		for t := range records {
+			types = append(types, t)
+		}
+
+		if isApex {
+			types = append(types, dns.TypeSOA, dns.TypeDNSKEY)
+			if len(zone.NameServers) != 0 {
+				types = append(types, dns.TypeNS)
+			}
+		}
+
+		s.signResponse(zone, res, types)
 	}
 
 	return res, true
 }
+
+// fitResponse removes records from res until it fits within the UDP payload
+// size accepted by the client that sent req.
+//
+// The payload size is the size advertised in the client's EDNS(0) OPT record,
+// limited to s.UDPSize, or 512 bytes if the client does not support EDNS(0).
+//
+// Additional records are optional, so they are removed first without setting
+// the TC bit. They are removed one RRset at a time, along with the RRSIG
+// records that cover them, so that clients never receive a partial RRset. If
+// the response still does not fit, all records are removed and the TC bit is
+// set, indicating that the client should retry the query over TCP, where
+// responses are never truncated.
+//
+// See https://www.rfc-editor.org/rfc/rfc2181#section-9.
+// See https://www.rfc-editor.org/rfc/rfc6891#section-6.2.5.
+func (s *UnicastServer) fitResponse(req, res *dns.Msg) {
+	size := dns.MinMsgSize
+	if opt := req.IsEdns0(); opt != nil {
+		size = max(size, int(min(opt.UDPSize(), s.udpSize())))
+	}
+
+	opt := res.IsEdns0()
+
+	for res.Len() > size {
+		// Never remove the OPT record, which is always last.
+		i := len(res.Extra) - 1
+		if i >= 0 && res.Extra[i] == opt {
+			i--
+		}
+
+		if i < 0 {
+			break
+		}
+
+		k := rrsetKeyOf(res.Extra[i])
+		res.Extra = slices.DeleteFunc(res.Extra, func(rr dns.RR) bool {
+			return rr != opt && rrsetKeyOf(rr) == k
+		})
+	}
+
+	if res.Len() > size {
+		res.Truncated = true
+		res.Answer = nil
+		res.Ns = nil
+		res.Extra = nil
+
+		if opt != nil {
+			res.Extra = append(res.Extra, opt)
+		}
+	}
+}
+
+// rrsetKey identifies an RRset within a DNS message.
+type rrsetKey struct {
+	Name string
+	Type uint16
+}
+
+// rrsetKeyOf returns the key of the RRset that rr belongs to. RRSIG records
+// belong to the RRset that they cover.
+func rrsetKeyOf(rr dns.RR) rrsetKey {
+	h := rr.Header()
+	t := h.Rrtype
+
+	if sig, ok := rr.(*dns.RRSIG); ok {
+		t = sig.TypeCovered
+	}
+
+	return rrsetKey{dns.CanonicalName(h.Name), t}
+}
+
+// additionalRecords returns the records that are included in the additional
+// section of a response with the given answers.
+//
+// For PTR answers this is the SRV and TXT records of each service instance and
+// the address records of its target host. For SRV answers this is the address
+// records of the target host.
+//
+// It assumes s.m is already locked for reading.
+//
+// See https://www.rfc-editor.org/rfc/rfc6763#section-12.
+func (s *UnicastServer) additionalRecords(answers []dns.RR) []dns.RR {
+	var extra []dns.RR
+
+	addresses := func(host string) {
+		records := s.recordsAt(host)
+		extra = append(extra, records[dns.TypeA]...)
+		extra = append(extra, records[dns.TypeAAAA]...)
+	}
+
+	for _, rr := range answers {
+		switch rr := rr.(type) {
+		case *dns.PTR:
+			records := s.recordsAt(rr.Ptr)
+			extra = append(extra, records[dns.TypeSRV]...)
+			extra = append(extra, records[dns.TypeTXT]...)
+
+			for _, srv := range records[dns.TypeSRV] {
+				addresses(srv.(*dns.SRV).Target)
+			}
+		case *dns.SRV:
+			addresses(rr.Target)
+		}
+	}
+
+	return extra
+}
@@ -1,8 +1,16 @@
 package dnssd_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
 	"net"
+	"strings"
 	"time"
 
 	. "github.com/dogmatiq/dissolve/dnssd"
@@ -70,18 +78,18 @@ var _ = Context("UnicastServer", func() {
 
 		server = &UnicastServer{}
 
-		server.Advertise(
+		Expect(server.Advertise(
 			instanceA,
 			WithServiceSubType("_printer"),
-		)
+		)).To(Succeed())
 
-		server.Advertise(
+		Expect(server.Advertise(
 			instanceB,
 			WithIPAddress(net.IPv4(192, 168, 20, 1)),
 			WithIPAddress(net.ParseIP("fe80::1ce5:3c8b:36f:53cf")),
-		)
+		)).To(Succeed())
 
-		server.Advertise(instanceC)
+		Expect(server.Advertise(instanceC)).To(Succeed())
 	})
 
 	AfterEach(func() {
@@ -89,28 +97,6 @@ var _ = Context("UnicastServer", func() {
 	})
 
 	Context("DNS responses", func() {
-		var (
-			client *dns.Client
-			errors chan error
-		)
-
-		BeforeEach(func() {
-			client = &dns.Client{}
-			errors = make(chan error, 1)
-
-			go func() {
-				errors <- server.Run(ctx, "udp", "127.0.0.1:65353")
-			}()
-
-			// Fudge-factor to allow the server time to start.
-			time.Sleep(100 * time.Millisecond)
-		})
-
-		AfterEach(func() {
-			cancel()
-			Expect(<-errors).To(Equal(context.Canceled))
-		})
-
 		Context("service type enumeration", func() {
 			req := &dns.Msg{}
 			req.SetQuestion(
@@ -119,7 +105,7 @@ var _ = Context("UnicastServer", func() {
 			)
 
 			It("responds to service type enumeration queries", func() {
-				res, _, err := client.ExchangeContext(ctx, req, "127.0.0.1:65353")
+				res, err := server.Exchange(ctx, req)
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(res).NotTo(BeNil())
 				expectRecords(
@@ -136,7 +122,7 @@ var _ = Context("UnicastServer", func() {
 
 				By("asserting that the _http._tcp service type is still included in the response")
 
-				res, _, err := client.ExchangeContext(ctx, req, "127.0.0.1:65353")
+				res, err := server.Exchange(ctx, req)
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(res).NotTo(BeNil())
 				expectRecords(
@@ -151,7 +137,7 @@ var _ = Context("UnicastServer", func() {
 
 				By("asserting that the _http._tcp service type is no longer included in the response")
 
-				res, _, err = client.ExchangeContext(ctx, req, "127.0.0.1:65353")
+				res, err = server.Exchange(ctx, req)
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(res).NotTo(BeNil())
 				expectRecords(
@@ -169,20 +155,51 @@ var _ = Context("UnicastServer", func() {
 			)
 
 			It("responds to service instance enumeration queries", func() {
-				res, _, err := client.ExchangeContext(ctx, req, "127.0.0.1:65353")
+				res, err := server.Exchange(ctx, req)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(res).NotTo(BeNil())
+				expectRecords(
+					res,
+					`_http._tcp.example.org.	120	IN	PTR	Instance\ A._http._tcp.example.org.`,
+					`_http._tcp.example.org.	120	IN	PTR	Instance\ B._http._tcp.example.org.`,
+				)
+			})
+
+			It("includes the records of each instance in the additional section", func() {
+				res, err := server.Exchange(ctx, req)
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(res).NotTo(BeNil())
+				expectAdditionalRecords(
+					res,
+					`Instance\ A._http._tcp.example.org.	120	IN	SRV	10 20 12345 a.example.com.`,
+					`Instance\ A._http._tcp.example.org.	120	IN	TXT	"<key>=<instance-a>"`,
+					`Instance\ B._http._tcp.example.org.	120	IN	SRV	10 20 12345 b.example.com.`,
+					`Instance\ B._http._tcp.example.org.	120	IN	TXT	"<key>=<instance-b0>"`,
+					`Instance\ B._http._tcp.example.org.	120	IN	TXT	"<key>=<instance-b1>"`,
+					`b.example.com.	120	IN	A	192.168.20.1`,
+					"b.example.com.	120	IN	AAAA	fe80::1ce5:3c8b:36f:53cf",
+				)
+			})
+
+			It("matches the query name case-insensitively", func() {
+				req := &dns.Msg{}
+				req.SetQuestion("_HTTP._tcp.Example.ORG.", dns.TypePTR)
+
+				res, err := server.Exchange(ctx, req)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(res.Question[0].Name).To(Equal("_HTTP._tcp.Example.ORG."))
 				expectRecords(
 					res,
 					`_http._tcp.example.org.	120	IN	PTR	Instance\ A._http._tcp.example.org.`,
 					`_http._tcp.example.org.	120	IN	PTR	Instance\ B._http._tcp.example.org.`,
 				)
+				Expect(res.Extra).To(HaveLen(7))
 			})
 
 			It("does not include service instances that have been removed", func() {
 				server.Remove(instanceA)
 
-				res, _, err := client.ExchangeContext(ctx, req, "127.0.0.1:65353")
+				res, err := server.Exchange(ctx, req)
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(res).NotTo(BeNil())
 				expectRecords(
@@ -200,7 +217,7 @@ var _ = Context("UnicastServer", func() {
 			)
 
 			It("responds to selective service instance enumeration queries", func() {
-				res, _, err := client.ExchangeContext(ctx, req, "127.0.0.1:65353")
+				res, err := server.Exchange(ctx, req)
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(res).NotTo(BeNil())
 				expectRecords(
@@ -212,7 +229,19 @@ var _ = Context("UnicastServer", func() {
 			It("does not include service instances that have been removed", func() {
 				server.Remove(instanceA)
 
-				res, _, err := client.ExchangeContext(ctx, req, "127.0.0.1:65353")
+				res, err := server.Exchange(ctx, req)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(res).NotTo(BeNil())
+				expectRecords(
+					res,
+					// none
+				)
+			})
+
+			It("does not include service instances that have been removed by name", func() {
+				server.RemoveByName(instanceA.ServiceInstanceName)
+
+				res, err := server.Exchange(ctx, req)
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(res).NotTo(BeNil())
 				expectRecords(
@@ -230,7 +259,7 @@ var _ = Context("UnicastServer", func() {
 			)
 
 			It("responds to instance lookup queries", func() {
-				res, _, err := client.ExchangeContext(ctx, req, "127.0.0.1:65353")
+				res, err := server.Exchange(ctx, req)
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(res).NotTo(BeNil())
 				expectRecords(
@@ -246,7 +275,7 @@ var _ = Context("UnicastServer", func() {
 					dns.TypeSRV,
 				)
 
-				res, _, err := client.ExchangeContext(ctx, req, "127.0.0.1:65353")
+				res, err := server.Exchange(ctx, req)
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(res).NotTo(BeNil())
 				expectRecords(
@@ -255,10 +284,27 @@ var _ = Context("UnicastServer", func() {
 				)
 			})
 
+			It("includes the address records of the target host in the additional section of SRV responses", func() {
+				req := &dns.Msg{}
+				req.SetQuestion(
+					AbsoluteServiceInstanceName("Instance B", "_http._tcp", "example.org"),
+					dns.TypeSRV,
+				)
+
+				res, err := server.Exchange(ctx, req)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(res).NotTo(BeNil())
+				expectAdditionalRecords(
+					res,
+					`b.example.com.	120	IN	A	192.168.20.1`,
+					"b.example.com.	120	IN	AAAA	fe80::1ce5:3c8b:36f:53cf",
+				)
+			})
+
 			It("does not include service instances that have been removed", func() {
 				server.Remove(instanceA)
 
-				res, _, err := client.ExchangeContext(ctx, req, "127.0.0.1:65353")
+				res, err := server.Exchange(ctx, req)
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(res).NotTo(BeNil())
 				expectRecords(
@@ -276,7 +322,7 @@ var _ = Context("UnicastServer", func() {
 			)
 
 			It("responds to address lookup queries", func() {
-				res, _, err := client.ExchangeContext(ctx, req, "127.0.0.1:65353")
+				res, err := server.Exchange(ctx, req)
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(res).NotTo(BeNil())
 				expectRecords(
@@ -289,7 +335,19 @@ var _ = Context("UnicastServer", func() {
 			It("does not include service instances that have been removed", func() {
 				server.Remove(instanceB)
 
-				res, _, err := client.ExchangeContext(ctx, req, "127.0.0.1:65353")
+				res, err := server.Exchange(ctx, req)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(res).NotTo(BeNil())
+				expectRecords(
+					res,
+					// none
+				)
+			})
+
+			It("does not include service instances that have been removed by name", func() {
+				server.RemoveByName(instanceB.ServiceInstanceName)
+
+				res, err := server.Exchange(ctx, req)
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(res).NotTo(BeNil())
 				expectRecords(
@@ -309,7 +367,7 @@ var _ = Context("UnicastServer", func() {
 			It("responds normally if the class ANY", func() {
 				req.Question[0].Qclass = dns.ClassANY
 
-				res, _, err := client.ExchangeContext(ctx, req, "127.0.0.1:65353")
+				res, err := server.Exchange(ctx, req)
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(res).NotTo(BeNil())
 				expectRecords(
@@ -322,7 +380,7 @@ var _ = Context("UnicastServer", func() {
 			It("responds with a non-existant domain error if the class is any other class", func() {
 				req.Question[0].Qclass = dns.ClassCHAOS
 
-				res, _, err := client.ExchangeContext(ctx, req, "127.0.0.1:65353")
+				res, err := server.Exchange(ctx, req)
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(res).NotTo(BeNil())
 				Expect(res.Rcode).To(Equal(dns.RcodeNameError))
@@ -330,32 +388,2599 @@ var _ = Context("UnicastServer", func() {
 		})
 	})
 
-	Describe("func Run()", func() {
-		It("exits when the context is canceled", func() {
-			errors := make(chan error, 1)
+	Describe("truncation", func() {
+		var large ServiceInstance
+
+		serve := func(req *dns.Msg) *dns.Msg {
+			w := &responseWriter{}
+			server.ServeDNS(w, req)
+			Expect(w.Messages).To(HaveLen(1))
+
+			return w.Messages[0]
+		}
+
+		BeforeEach(func() {
+			attrs := NewAttributes()
+			for i := range 30 {
+				attrs = attrs.WithPair(
+					fmt.Sprintf("key-%02d", i),
+					bytes.Repeat([]byte("x"), 60),
+				)
+			}
+
+			large = instanceA
+			large.Name = "Large Instance"
+			large.Attributes = AttributeCollection{attrs}
+
+			Expect(server.Advertise(large)).To(Succeed())
+		})
+
+		It("sets the TC bit if the answer does not fit in a UDP response", func() {
+			req := &dns.Msg{}
+			req.SetQuestion(large.Absolute(), dns.TypeTXT)
+
+			res := serve(req)
+			Expect(res.Truncated).To(BeTrue())
+			Expect(res.Answer).To(BeEmpty())
+			Expect(res.Len()).To(BeNumerically("<=", dns.MinMsgSize))
+		})
+
+		It("uses the payload size advertised by the client", func() {
+			server.UDPSize = dns.DefaultMsgSize
+
+			req := &dns.Msg{}
+			req.SetQuestion(large.Absolute(), dns.TypeTXT)
+			req.SetEdns0(dns.DefaultMsgSize, false)
+
+			res := serve(req)
+			Expect(res.Truncated).To(BeFalse())
+			Expect(res.Answer).To(HaveLen(1))
+		})
+
+		It("does not exceed the server's payload size", func() {
+			req := &dns.Msg{}
+			req.SetQuestion(large.Absolute(), dns.TypeTXT)
+			req.SetEdns0(dns.DefaultMsgSize, false)
+
+			res := serve(req)
+			Expect(res.Truncated).To(BeTrue())
+			Expect(res.Answer).To(BeEmpty())
+
+			opt := res.IsEdns0()
+			Expect(opt).NotTo(BeNil())
+			Expect(opt.UDPSize()).To(BeEquivalentTo(DefaultUDPSize))
+		})
+
+		It("never removes part of an RRset from the additional section", func() {
+			for i := range 10 {
+				inst := instanceA
+				inst.Name = fmt.Sprintf("Instance %d", i)
+				inst.ServiceType = "_multi._tcp"
+				inst.Attributes = AttributeCollection{
+					NewAttributes().WithPair("a", bytes.Repeat([]byte("x"), 20)),
+					NewAttributes().WithPair("b", bytes.Repeat([]byte("x"), 20)),
+					NewAttributes().WithPair("c", bytes.Repeat([]byte("x"), 20)),
+				}
+
+				Expect(server.Advertise(inst)).To(Succeed())
+			}
+
+			req := &dns.Msg{}
+			req.SetQuestion("_multi._tcp.example.org.", dns.TypePTR)
+
+			res := serve(req)
+			Expect(res.Truncated).To(BeFalse())
+			Expect(res.Answer).To(HaveLen(10))
 
+			txt := map[string]int{}
+			for _, rr := range res.Extra {
+				if rr.Header().Rrtype == dns.TypeTXT {
+					txt[rr.Header().Name]++
+				}
+			}
+
+			Expect(txt).NotTo(BeEmpty())
+			Expect(len(txt)).To(BeNumerically("<", 10))
+			for name, n := range txt {
+				Expect(n).To(Equal(3), "partial TXT RRset for %s", name)
+			}
+		})
+
+		It("includes an OPT record in responses to queries that contain one", func() {
+			req := &dns.Msg{}
+			req.SetQuestion(instanceA.Absolute(), dns.TypeSRV)
+			req.SetEdns0(dns.DefaultMsgSize, false)
+
+			res := serve(req)
+			Expect(res.Answer).To(HaveLen(1))
+
+			opt := res.IsEdns0()
+			Expect(opt).NotTo(BeNil())
+			Expect(opt.UDPSize()).To(BeEquivalentTo(DefaultUDPSize))
+			Expect(opt.Do()).To(BeFalse())
+		})
+
+		It("serves the full answer over TCP", func() {
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			ctx, server := ctx, server
 			go func() {
-				errors <- server.Run(ctx, "udp", "127.0.0.1:65353")
+				_ = server.RunWithListener(ctx, lis)
 			}()
 
+			req := &dns.Msg{}
+			req.SetQuestion(large.Absolute(), dns.TypeTXT)
+
+			client := &dns.Client{Net: "tcp"}
+			res, _, err := client.ExchangeContext(ctx, req, lis.Addr().String())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Truncated).To(BeFalse())
+			Expect(res.Answer).To(HaveLen(1))
+			Expect(res.Len()).To(BeNumerically(">", dns.MinMsgSize))
+		})
+
+		It("allows UnicastResolver to retrieve the full answer over TCP", func() {
+			ctx, server := ctx, server
 			go func() {
-				time.Sleep(50 * time.Millisecond)
-				cancel()
+				_ = server.RunAll(ctx, "127.0.0.1:65353")
 			}()
 
-			var err error
-			Eventually(errors).Should(Receive(&err))
+			// Fudge-factor to allow the server time to start.
+			time.Sleep(100 * time.Millisecond)
+
+			resolver := &UnicastResolver{
+				Config: &dns.ClientConfig{
+					Servers: []string{"127.0.0.1"},
+					Port:    "65353",
+				},
+			}
+
+			i, ok, err := resolver.LookupInstance(
+				ctx,
+				large.Name,
+				large.ServiceType,
+				large.Domain,
+			)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(i.Attributes).To(Equal(large.Attributes))
+		})
+	})
+
+	Describe("TTL policy", func() {
+		query := func(name string, qtype uint16) *dns.Msg {
+			req := &dns.Msg{}
+			req.SetQuestion(name, qtype)
+
+			res, err := server.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+			return res
+		}
+
+		It("overrides the TTL of records of the configured record types", func() {
+			server.TTLPolicy.RecordTypes = map[uint16]time.Duration{
+				dns.TypePTR: 10 * time.Second,
+			}
+
+			res := query(AbsoluteInstanceEnumerationDomain("_http._tcp", "example.org"), dns.TypePTR)
+			expectRecords(
+				res,
+				`_http._tcp.example.org.	10	IN	PTR	Instance\ A._http._tcp.example.org.`,
+				`_http._tcp.example.org.	10	IN	PTR	Instance\ B._http._tcp.example.org.`,
+			)
+
+			for _, rr := range res.Extra {
+				Expect(rr.Header().Ttl).To(BeEquivalentTo(120))
+			}
+		})
+
+		It("overrides the TTL of the records of instances of the configured service types", func() {
+			server.TTLPolicy.ServiceTypes = map[string]time.Duration{
+				"_http._tcp": 5 * time.Minute,
+			}
+
+			expectRecords(
+				query(instanceA.Absolute(), dns.TypeSRV),
+				`Instance\ A._http._tcp.example.org.	300	IN	SRV	10 20 12345 a.example.com.`,
+			)
+
+			expectRecords(
+				query(AbsoluteSelectiveInstanceEnumerationDomain("_printer", "_http._tcp", "example.org"), dns.TypePTR),
+				`_printer._sub._http._tcp.example.org.	300	IN	PTR	Instance\ A._http._tcp.example.org.`,
+			)
+
+			expectRecords(
+				query(AbsoluteTypeEnumerationDomain("example.org"), dns.TypePTR),
+				`_services._dns-sd._udp.example.org.	300	IN	PTR	_http._tcp.example.org.`,
+				`_services._dns-sd._udp.example.org.	120	IN	PTR	_other._udp.example.org.`,
+			)
+
+			expectRecords(
+				query(instanceC.Absolute(), dns.TypeSRV),
+				`Instance\ C._other._udp.example.org.	120	IN	SRV	10 20 12345 c.example.com.`,
+			)
+		})
+
+		It("does not override the TTL of address records by service type", func() {
+			server.TTLPolicy.ServiceTypes = map[string]time.Duration{
+				"_http._tcp": 5 * time.Minute,
+			}
+
+			res := query(instanceB.Absolute(), dns.TypeSRV)
+			expectRecords(
+				res,
+				`Instance\ B._http._tcp.example.org.	300	IN	SRV	10 20 12345 b.example.com.`,
+			)
+			expectAdditionalRecords(
+				res,
+				`b.example.com.	120	IN	A	192.168.20.1`,
+				"b.example.com.	120	IN	AAAA	fe80::1ce5:3c8b:36f:53cf",
+			)
+		})
+
+		It("prefers record type overrides to service type overrides", func() {
+			server.TTLPolicy = TTLPolicy{
+				ServiceTypes: map[string]time.Duration{
+					"_http._tcp": 5 * time.Minute,
+				},
+				RecordTypes: map[uint16]time.Duration{
+					dns.TypeSRV: 30 * time.Second,
+				},
+			}
+
+			expectRecords(
+				query(instanceA.Absolute(), dns.TypeSRV),
+				`Instance\ A._http._tcp.example.org.	30	IN	SRV	10 20 12345 a.example.com.`,
+			)
+		})
+
+		It("does not modify the advertised records", func() {
+			server.TTLPolicy.RecordTypes = map[uint16]time.Duration{
+				dns.TypeSRV: 30 * time.Second,
+			}
+			query(instanceA.Absolute(), dns.TypeSRV)
+
+			server.TTLPolicy = TTLPolicy{}
+			expectRecords(
+				query(instanceA.Absolute(), dns.TypeSRV),
+				`Instance\ A._http._tcp.example.org.	120	IN	SRV	10 20 12345 a.example.com.`,
+			)
+		})
+	})
+
+	Describe("func Exchange()", func() {
+		It("returns an error if the query does not contain exactly one question", func() {
+			_, err := server.Exchange(ctx, &dns.Msg{})
+			Expect(err).To(MatchError("DNS query must contain exactly one question, got 0"))
+		})
+
+		It("returns an error if the message is not a query", func() {
+			req := &dns.Msg{}
+			req.SetUpdate("example.org.")
+
+			_, err := server.Exchange(ctx, req)
+			Expect(err).To(MatchError("unsupported DNS opcode: UPDATE"))
+		})
+
+		It("returns an error if the context is canceled", func() {
+			cancel()
+
+			req := &dns.Msg{}
+			req.SetQuestion(instanceA.Absolute(), dns.TypeSRV)
+
+			_, err := server.Exchange(ctx, req)
 			Expect(err).To(Equal(context.Canceled))
 		})
+
+		It("does not restrict the response to authorized clients", func() {
+			server.Authorize = func(net.Addr, *dns.Msg) bool {
+				return false
+			}
+
+			req := &dns.Msg{}
+			req.SetQuestion(instanceA.Absolute(), dns.TypeSRV)
+
+			res, err := server.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+			Expect(res.Answer).To(HaveLen(1))
+		})
 	})
-})
 
-func expectRecords(res *dns.Msg, records ...string) {
-	var actual []string
+	Describe("func AdvertiseHost() and RemoveHost()", func() {
+		It("responds to address lookup queries for the host", func() {
+			Expect(server.AdvertiseHost(
+				"a.example.com",
+				net.IPv4(192, 168, 10, 1),
+				net.ParseIP("fe80::1"),
+			)).To(Succeed())
 
-	for _, rr := range res.Answer {
-		actual = append(actual, rr.String())
-	}
+			req := &dns.Msg{}
+			req.SetQuestion("a.example.com.", dns.TypeANY)
 
-	Expect(actual).To(ConsistOf(records))
+			res, err := server.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+			expectRecords(
+				res,
+				`a.example.com.	120	IN	A	192.168.10.1`,
+				`a.example.com.	120	IN	AAAA	fe80::1`,
+			)
+		})
+
+		It("includes the host's address records in the additional section of SRV responses", func() {
+			Expect(server.AdvertiseHost("a.example.com", net.IPv4(192, 168, 10, 1))).To(Succeed())
+
+			req := &dns.Msg{}
+			req.SetQuestion(instanceA.Absolute(), dns.TypeSRV)
+
+			res, err := server.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+			expectAdditionalRecords(
+				res,
+				`a.example.com.	120	IN	A	192.168.10.1`,
+			)
+		})
+
+		It("replaces the addresses previously advertised for the host", func() {
+			Expect(server.AdvertiseHost("a.example.com", net.IPv4(192, 168, 10, 1))).To(Succeed())
+			Expect(server.AdvertiseHost("a.example.com", net.IPv4(192, 168, 10, 2))).To(Succeed())
+
+			req := &dns.Msg{}
+			req.SetQuestion("a.example.com.", dns.TypeA)
+
+			res, err := server.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+			expectRecords(
+				res,
+				`a.example.com.	120	IN	A	192.168.10.2`,
+			)
+		})
+
+		It("does not include hosts that have been removed", func() {
+			Expect(server.AdvertiseHost("a.example.com", net.IPv4(192, 168, 10, 1))).To(Succeed())
+			server.RemoveHost("a.example.com")
+
+			req := &dns.Msg{}
+			req.SetQuestion("a.example.com.", dns.TypeA)
+
+			res, err := server.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Rcode).To(Equal(dns.RcodeNameError))
+		})
+
+		It("does not affect address records added to an instance", func() {
+			Expect(server.AdvertiseHost("b.example.com", net.IPv4(192, 168, 10, 1))).To(Succeed())
+			server.RemoveHost("b.example.com")
+
+			req := &dns.Msg{}
+			req.SetQuestion("b.example.com.", dns.TypeA)
+
+			res, err := server.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+			expectRecords(
+				res,
+				`b.example.com.	120	IN	A	192.168.20.1`,
+			)
+		})
+
+		It("does not affect the host when instances that target it are removed", func() {
+			Expect(server.AdvertiseHost("a.example.com", net.IPv4(192, 168, 10, 1))).To(Succeed())
+			server.Remove(instanceA)
+
+			req := &dns.Msg{}
+			req.SetQuestion("a.example.com.", dns.TypeA)
+
+			res, err := server.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+			expectRecords(
+				res,
+				`a.example.com.	120	IN	A	192.168.10.1`,
+			)
+		})
+
+		It("can advertise hosts before any instances", func() {
+			s := &UnicastServer{}
+			Expect(s.AdvertiseHost("a.example.com", net.IPv4(192, 168, 10, 1))).To(Succeed())
+			Expect(s.Advertise(instanceA)).To(Succeed())
+
+			req := &dns.Msg{}
+			req.SetQuestion("a.example.com.", dns.TypeA)
+
+			res, err := s.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+			expectRecords(
+				res,
+				`a.example.com.	120	IN	A	192.168.10.1`,
+			)
+		})
+	})
+
+	Describe("func AdvertiseAlias() and RemoveAlias()", func() {
+		It("follows the alias when answering address lookup queries", func() {
+			Expect(server.AdvertiseAlias("www.example.com", "b.example.com")).To(Succeed())
+
+			req := &dns.Msg{}
+			req.SetQuestion("www.example.com.", dns.TypeA)
+
+			res, err := server.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+			expectRecords(
+				res,
+				`www.example.com.	120	IN	CNAME	b.example.com.`,
+				`b.example.com.	120	IN	A	192.168.20.1`,
+			)
+		})
+
+		It("follows chains of aliases", func() {
+			Expect(server.AdvertiseAlias("www.example.com", "web.example.com")).To(Succeed())
+			Expect(server.AdvertiseAlias("web.example.com", "b.example.com")).To(Succeed())
+
+			req := &dns.Msg{}
+			req.SetQuestion("www.example.com.", dns.TypeAAAA)
+
+			res, err := server.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+			expectRecords(
+				res,
+				`www.example.com.	120	IN	CNAME	web.example.com.`,
+				`web.example.com.	120	IN	CNAME	b.example.com.`,
+				`b.example.com.	120	IN	AAAA	fe80::1ce5:3c8b:36f:53cf`,
+			)
+		})
+
+		It("stops following aliases that form a loop", func() {
+			Expect(server.AdvertiseAlias("x.example.com", "y.example.com")).To(Succeed())
+			Expect(server.AdvertiseAlias("y.example.com", "x.example.com")).To(Succeed())
+
+			req := &dns.Msg{}
+			req.SetQuestion("x.example.com.", dns.TypeA)
+
+			res, err := server.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+			expectRecords(
+				res,
+				`x.example.com.	120	IN	CNAME	y.example.com.`,
+				`y.example.com.	120	IN	CNAME	x.example.com.`,
+			)
+		})
+
+		It("responds with only the CNAME record if the target has no records", func() {
+			Expect(server.AdvertiseAlias("www.example.com", "elsewhere.example.net")).To(Succeed())
+
+			req := &dns.Msg{}
+			req.SetQuestion("www.example.com.", dns.TypeA)
+
+			res, err := server.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+			expectRecords(
+				res,
+				`www.example.com.	120	IN	CNAME	elsewhere.example.net.`,
+			)
+		})
+
+		It("does not follow the alias when answering CNAME queries", func() {
+			Expect(server.AdvertiseAlias("www.example.com", "b.example.com")).To(Succeed())
+
+			req := &dns.Msg{}
+			req.SetQuestion("www.example.com.", dns.TypeCNAME)
+
+			res, err := server.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+			expectRecords(
+				res,
+				`www.example.com.	120	IN	CNAME	b.example.com.`,
+			)
+		})
+
+		It("replaces the target previously advertised for the alias", func() {
+			Expect(server.AdvertiseAlias("www.example.com", "a.example.com")).To(Succeed())
+			Expect(server.AdvertiseAlias("www.example.com", "b.example.com")).To(Succeed())
+
+			req := &dns.Msg{}
+			req.SetQuestion("www.example.com.", dns.TypeCNAME)
+
+			res, err := server.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+			expectRecords(
+				res,
+				`www.example.com.	120	IN	CNAME	b.example.com.`,
+			)
+		})
+
+		It("returns an error if the alias has other records", func() {
+			err := server.AdvertiseAlias("b.example.com", "elsewhere.example.net")
+			Expect(err).To(MatchError(`can not advertise "b.example.com." as an alias because it has other records`))
+
+			req := &dns.Msg{}
+			req.SetQuestion("b.example.com.", dns.TypeCNAME)
+
+			res, err := server.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Answer).To(BeEmpty())
+		})
+
+		It("prevents hosts from being advertised at the name of the alias", func() {
+			Expect(server.AdvertiseAlias("www.example.com", "b.example.com")).To(Succeed())
+
+			err := server.AdvertiseHost("www.example.com", net.IPv4(192, 168, 40, 1))
+			Expect(err).To(MatchError(`can not advertise records for "www.example.com." because it is an alias`))
+
+			req := &dns.Msg{}
+			req.SetQuestion("www.example.com.", dns.TypeA)
+
+			res, err := server.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Answer).To(HaveLen(2))
+			Expect(res.Answer[0].Header().Rrtype).To(Equal(dns.TypeCNAME))
+			Expect(res.Answer[1].String()).To(Equal("b.example.com.\t120\tIN\tA\t192.168.20.1"))
+		})
+
+		It("prevents instances from being advertised with records at the name of the alias", func() {
+			Expect(server.AdvertiseAlias("www.example.com", "b.example.com")).To(Succeed())
+
+			inst := instanceA
+			inst.Name = "Instance D"
+			inst.TargetHost = "www.example.com"
+
+			err := server.Advertise(inst, WithIPAddress(net.IPv4(192, 168, 40, 1)))
+			Expect(err).To(MatchError(`can not advertise records for "www.example.com." because it is an alias`))
+
+			for _, i := range server.Instances() {
+				Expect(i.Name).NotTo(Equal(inst.Name))
+			}
+		})
+
+		It("does not include aliases that have been removed", func() {
+			Expect(server.AdvertiseAlias("www.example.com", "b.example.com")).To(Succeed())
+			server.RemoveAlias("www.example.com")
+
+			req := &dns.Msg{}
+			req.SetQuestion("www.example.com.", dns.TypeA)
+
+			res, err := server.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Rcode).To(Equal(dns.RcodeNameError))
+		})
+	})
+
+	Describe("func Instances()", func() {
+		It("returns the advertised instances in order of their names", func() {
+			instances := server.Instances()
+			Expect(instances).To(HaveLen(3))
+
+			var names []string
+			for _, i := range instances {
+				names = append(names, i.Absolute())
+			}
+
+			Expect(names).To(Equal([]string{
+				instanceA.Absolute(),
+				instanceB.Absolute(),
+				instanceC.Absolute(),
+			}))
+		})
+
+		It("includes the instance's attributes, IP addresses and sub-types", func() {
+			instanceA.TTL = DefaultTTL
+			instanceB.TTL = DefaultTTL
+
+			instances := server.Instances()
+
+			Expect(instances[0].ServiceInstance.Equal(instanceA)).To(BeTrue())
+			Expect(instances[0].ServiceSubTypes).To(Equal([]string{"_printer"}))
+			Expect(instances[0].IPAddresses).To(BeEmpty())
+
+			Expect(instances[1].ServiceInstance.Equal(instanceB)).To(BeTrue())
+			Expect(instances[1].ServiceSubTypes).To(BeEmpty())
+			Expect(instances[1].IPAddresses).To(ConsistOf(
+				net.IPv4(192, 168, 20, 1).To4(),
+				net.ParseIP("fe80::1ce5:3c8b:36f:53cf"),
+			))
+		})
+
+		It("includes the instance's service bindings", func() {
+			instanceC.TTL = 5 * time.Minute
+			instanceC.Bindings = []ServiceBinding{
+				{Priority: 1, ALPN: []string{"h2"}, Port: 8443},
+				{HTTPS: true, Priority: 2, Target: "c.example.com"},
+			}
+			Expect(server.Advertise(instanceC)).To(Succeed())
+
+			instances := server.Instances()
+			Expect(instances[2].ServiceInstance.Equal(instanceC)).To(BeTrue())
+		})
+
+		It("does not include instances that have been removed", func() {
+			server.Remove(instanceA)
+
+			instances := server.Instances()
+			Expect(instances).To(HaveLen(2))
+			Expect(instances[0].Absolute()).To(Equal(instanceB.Absolute()))
+		})
+
+		It("returns options that advertise the instance identically", func() {
+			other := &UnicastServer{}
+			for _, i := range server.Instances() {
+				Expect(other.Advertise(i.ServiceInstance, i.Options()...)).To(Succeed())
+			}
+
+			a, err := server.Snapshot()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			b, err := other.Snapshot()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(b).To(Equal(a))
+		})
+
+		It("returns an empty slice if no instances are advertised", func() {
+			Expect((&UnicastServer{}).Instances()).To(BeEmpty())
+		})
+	})
+
+	Describe("func List()", func() {
+		It("returns the instances of the given service type and domain", func() {
+			instances, err := server.List(ctx, "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(HaveLen(2))
+			Expect(instances[0].Name).To(Equal("Instance A"))
+			Expect(instances[1].Name).To(Equal("Instance B"))
+
+			instances, err = server.List(ctx, "_other._udp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(HaveLen(1))
+			Expect(instances[0].Name).To(Equal("Instance C"))
+		})
+
+		It("returns an empty slice if there are no matching instances", func() {
+			instances, err := server.List(ctx, "_http._tcp", "example.com")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(instances).To(BeEmpty())
+		})
+
+		It("returns an error if the context is canceled", func() {
+			cancel()
+
+			_, err := server.List(ctx, "_http._tcp", "example.org")
+			Expect(err).To(Equal(context.Canceled))
+		})
+	})
+
+	Describe("func Snapshot() and Restore()", func() {
+		query := func(s *UnicastServer, name string, qtype uint16) []string {
+			req := &dns.Msg{}
+			req.SetQuestion(name, qtype)
+
+			res, err := s.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			var records []string
+			for _, rr := range res.Answer {
+				records = append(records, rr.String())
+			}
+			return records
+		}
+
+		It("restores the advertised instances into another server", func() {
+			snapshot, err := server.Snapshot()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			restored := &UnicastServer{}
+			err = restored.Restore(snapshot)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			questions := []dns.Question{
+				{Name: AbsoluteTypeEnumerationDomain("example.org"), Qtype: dns.TypePTR},
+				{Name: AbsoluteInstanceEnumerationDomain("_http._tcp", "example.org"), Qtype: dns.TypePTR},
+				{Name: AbsoluteSelectiveInstanceEnumerationDomain("_printer", "_http._tcp", "example.org"), Qtype: dns.TypePTR},
+				{Name: instanceA.Absolute(), Qtype: dns.TypeANY},
+				{Name: instanceB.Absolute(), Qtype: dns.TypeANY},
+				{Name: instanceC.Absolute(), Qtype: dns.TypeANY},
+				{Name: "b.example.com.", Qtype: dns.TypeA},
+				{Name: "b.example.com.", Qtype: dns.TypeAAAA},
+			}
+
+			for _, q := range questions {
+				expected := query(server, q.Name, q.Qtype)
+				Expect(expected).NotTo(BeEmpty())
+				Expect(query(restored, q.Name, q.Qtype)).To(ConsistOf(expected), "%s %s", q.Name, dns.TypeToString[q.Qtype])
+			}
+		})
+
+		It("restores the advertised hosts into another server", func() {
+			Expect(server.AdvertiseHost("host.example.com", net.IPv4(192, 168, 10, 1))).To(Succeed())
+
+			snapshot, err := server.Snapshot()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			restored := &UnicastServer{}
+			err = restored.Restore(snapshot)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(query(restored, "host.example.com.", dns.TypeA)).To(ConsistOf(
+				`host.example.com.	120	IN	A	192.168.10.1`,
+			))
+
+			restored.RemoveHost("host.example.com")
+			Expect(query(restored, "host.example.com.", dns.TypeA)).To(BeEmpty())
+		})
+
+		It("restores the advertised aliases into another server", func() {
+			Expect(server.AdvertiseAlias("www.example.com", "b.example.com")).To(Succeed())
+
+			snapshot, err := server.Snapshot()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			restored := &UnicastServer{}
+			err = restored.Restore(snapshot)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(query(restored, "www.example.com.", dns.TypeA)).To(ConsistOf(
+				`www.example.com.	120	IN	CNAME	b.example.com.`,
+				`b.example.com.	120	IN	A	192.168.20.1`,
+			))
+		})
+
+		It("returns an error if an alias has other records in the server it is restored into", func() {
+			Expect(server.AdvertiseAlias("www.example.com", "b.example.com")).To(Succeed())
+
+			snapshot, err := server.Snapshot()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			restored := &UnicastServer{}
+			Expect(restored.AdvertiseHost("www.example.com", net.IPv4(192, 168, 40, 1))).To(Succeed())
+
+			err = restored.Restore(snapshot)
+			Expect(err).To(MatchError(`can not advertise "www.example.com." as an alias because it has other records`))
+			Expect(restored.Instances()).To(BeEmpty())
+		})
+
+		It("returns an error if a host has the name of an alias in the server it is restored into", func() {
+			Expect(server.AdvertiseHost("www.example.com", net.IPv4(192, 168, 40, 1))).To(Succeed())
+
+			snapshot, err := server.Snapshot()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			restored := &UnicastServer{}
+			Expect(restored.AdvertiseAlias("www.example.com", "b.example.com")).To(Succeed())
+
+			err = restored.Restore(snapshot)
+			Expect(err).To(MatchError(`can not advertise records for "www.example.com." because it is an alias`))
+			Expect(restored.Instances()).To(BeEmpty())
+		})
+
+		It("does not include instances that have been removed", func() {
+			server.Remove(instanceA)
+
+			snapshot, err := server.Snapshot()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			restored := &UnicastServer{}
+			err = restored.Restore(snapshot)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(query(restored, instanceA.Absolute(), dns.TypeSRV)).To(BeEmpty())
+			Expect(query(restored, instanceB.Absolute(), dns.TypeSRV)).To(HaveLen(1))
+		})
+
+		It("replaces existing instances with the same name and leaves others unchanged", func() {
+			snapshot, err := server.Snapshot()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			restored := &UnicastServer{}
+
+			modified := instanceA
+			modified.TargetPort = 54321
+			Expect(restored.Advertise(modified)).To(Succeed())
+
+			other := instanceA
+			other.Name = "Instance D"
+			Expect(restored.Advertise(other)).To(Succeed())
+
+			err = restored.Restore(snapshot)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(query(restored, instanceA.Absolute(), dns.TypeSRV)).To(ConsistOf(
+				`Instance\ A._http._tcp.example.org.	120	IN	SRV	10 20 12345 a.example.com.`,
+			))
+			Expect(query(restored, other.Absolute(), dns.TypeSRV)).To(HaveLen(1))
+			Expect(query(restored, AbsoluteInstanceEnumerationDomain("_http._tcp", "example.org"), dns.TypePTR)).To(HaveLen(3))
+		})
+
+		It("returns an error if the snapshot is empty", func() {
+			err := server.Restore(nil)
+			Expect(err).To(MatchError("snapshot is empty"))
+		})
+
+		It("returns an error if the snapshot version is not supported", func() {
+			err := server.Restore([]byte{255})
+			Expect(err).To(MatchError("unsupported snapshot version: 255"))
+		})
+
+		It("returns an error and does not advertise any instances if the snapshot is truncated", func() {
+			snapshot, err := server.Snapshot()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			restored := &UnicastServer{}
+			err = restored.Restore(snapshot[:len(snapshot)-1])
+			Expect(err).To(MatchError("snapshot is truncated"))
+
+			Expect(query(restored, instanceA.Absolute(), dns.TypeSRV)).To(BeEmpty())
+		})
+	})
+
+	Describe("func WriteZoneFile() and ReadZoneFile()", func() {
+		query := func(s *UnicastServer, name string, qtype uint16) []string {
+			req := &dns.Msg{}
+			req.SetQuestion(name, qtype)
+
+			res, err := s.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			var records []string
+			for _, rr := range res.Answer {
+				records = append(records, rr.String())
+			}
+			return records
+		}
+
+		It("writes the records in the master file format", func() {
+			server = &UnicastServer{
+				Zones: []Zone{
+					{
+						SOA: &dns.SOA{
+							Hdr:    dns.RR_Header{Name: "example.org.", Ttl: 3600},
+							Ns:     "ns.example.org.",
+							Mbox:   "hostmaster.example.org.",
+							Serial: 100,
+							Minttl: 60,
+						},
+						NameServers: []string{"ns.example.org"},
+					},
+				},
+			}
+			Expect(server.Advertise(instanceC, WithIPAddress(net.IPv4(192, 168, 20, 3)))).To(Succeed())
+
+			var buf strings.Builder
+			err := server.WriteZoneFile(&buf)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(buf.String()).To(Equal(
+				"example.org.	3600	IN	SOA	ns.example.org. hostmaster.example.org. 101 0 0 0 60\n" +
+					"example.org.	3600	IN	NS	ns.example.org.\n" +
+					`Instance\ C._other._udp.example.org.	120	IN	SRV	10 20 12345 c.example.com.` + "\n" +
+					`Instance\ C._other._udp.example.org.	120	IN	TXT	""` + "\n" +
+					"_other._udp.example.org.	120	IN	PTR	Instance\\ C._other._udp.example.org.\n" +
+					"_services._dns-sd._udp.example.org.	120	IN	PTR	_other._udp.example.org.\n" +
+					"c.example.com.	120	IN	A	192.168.20.3\n",
+			))
+		})
+
+		It("reads the instances written by another server", func() {
+			var buf strings.Builder
+			err := server.WriteZoneFile(&buf)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			restored := &UnicastServer{}
+			err = restored.ReadZoneFile(strings.NewReader(buf.String()), "")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			questions := []dns.Question{
+				{Name: AbsoluteTypeEnumerationDomain("example.org"), Qtype: dns.TypePTR},
+				{Name: AbsoluteInstanceEnumerationDomain("_http._tcp", "example.org"), Qtype: dns.TypePTR},
+				{Name: AbsoluteSelectiveInstanceEnumerationDomain("_printer", "_http._tcp", "example.org"), Qtype: dns.TypePTR},
+				{Name: instanceA.Absolute(), Qtype: dns.TypeANY},
+				{Name: instanceB.Absolute(), Qtype: dns.TypeANY},
+				{Name: instanceC.Absolute(), Qtype: dns.TypeANY},
+				{Name: "b.example.com.", Qtype: dns.TypeA},
+				{Name: "b.example.com.", Qtype: dns.TypeAAAA},
+			}
+
+			for _, q := range questions {
+				expected := query(server, q.Name, q.Qtype)
+				Expect(expected).NotTo(BeEmpty())
+				Expect(query(restored, q.Name, q.Qtype)).To(ConsistOf(expected), "%s %s", q.Name, dns.TypeToString[q.Qtype])
+			}
+		})
+
+		It("reads instances from a zone file with relative names", func() {
+			zone := `
+$ORIGIN example.net.
+$TTL 300
+@               IN SOA   ns hostmaster 1 7200 900 86400 60
+@               IN NS    ns
+@               IN TXT   "v=spf1 -all"
+ns              IN A     192.168.30.1
+printer         IN A     192.168.30.2
+Office\ Printer._ipp._tcp     IN SRV  0 0 631 printer
+Office\ Printer._ipp._tcp     IN TXT  "rp=ipp/print" "color"
+_color._sub._ipp._tcp         IN PTR  Office\ Printer._ipp._tcp
+`
+
+			server = &UnicastServer{}
+			err := server.ReadZoneFile(strings.NewReader(zone), "")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			name := `Office\ Printer._ipp._tcp.example.net.`
+
+			Expect(query(server, name, dns.TypeANY)).To(ConsistOf(
+				name+"	300	IN	SRV	0 0 631 printer.example.net.",
+				name+`	300	IN	TXT	"color" "rp=ipp/print"`,
+			))
+			Expect(query(server, "printer.example.net.", dns.TypeA)).To(ConsistOf(
+				"printer.example.net.	300	IN	A	192.168.30.2",
+			))
+			Expect(query(server, "_color._sub._ipp._tcp.example.net.", dns.TypePTR)).To(ConsistOf(
+				"_color._sub._ipp._tcp.example.net.	300	IN	PTR	" + name,
+			))
+			Expect(query(server, "ns.example.net.", dns.TypeA)).To(ConsistOf(
+				"ns.example.net.	300	IN	A	192.168.30.1",
+			))
+		})
+
+		It("reads the hosts written by another server", func() {
+			Expect(server.AdvertiseHost(
+				"host.example.org",
+				net.IPv4(192, 168, 40, 1),
+				net.ParseIP("fe80::1"),
+			)).To(Succeed())
+
+			var buf strings.Builder
+			err := server.WriteZoneFile(&buf)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			restored := &UnicastServer{}
+			err = restored.ReadZoneFile(strings.NewReader(buf.String()), "")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+				expected := query(server, "host.example.org.", qtype)
+				Expect(expected).NotTo(BeEmpty())
+				Expect(query(restored, "host.example.org.", qtype)).To(ConsistOf(expected))
+			}
+
+			// Address records of target hosts are restored as part of the
+			// instances, not as separate hosts.
+			restored.RemoveHost("b.example.com")
+			Expect(query(restored, "b.example.com.", dns.TypeA)).NotTo(BeEmpty())
+		})
+
+		It("reads the aliases written by another server", func() {
+			Expect(server.AdvertiseAlias("www.example.com", "b.example.com")).To(Succeed())
+
+			var buf strings.Builder
+			err := server.WriteZoneFile(&buf)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			restored := &UnicastServer{}
+			err = restored.ReadZoneFile(strings.NewReader(buf.String()), "")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(query(restored, "www.example.com.", dns.TypeA)).To(ConsistOf(
+				`www.example.com.	120	IN	CNAME	b.example.com.`,
+				`b.example.com.	120	IN	A	192.168.20.1`,
+			))
+		})
+
+		It("returns an error if the name of a CNAME record has other records in the zone file", func() {
+			zone := `
+www.example.org. IN CNAME host.example.org.
+www.example.org. IN A     192.168.40.1
+`
+
+			restored := &UnicastServer{}
+			err := restored.ReadZoneFile(strings.NewReader(zone), "")
+			Expect(err).To(MatchError(`unable to parse zone file: "www.example.org." has a CNAME record and other records`))
+		})
+
+		It("returns an error if the name of a CNAME record has other records in the server", func() {
+			zone := `
+Instance\ X._http._tcp.example.org. IN SRV   0 0 80 x.example.org.
+b.example.com.                      IN CNAME host.example.org.
+`
+
+			err := server.ReadZoneFile(strings.NewReader(zone), "")
+			Expect(err).To(MatchError(`can not advertise "b.example.com." as an alias because it has other records`))
+			Expect(server.Instances()).To(HaveLen(3))
+		})
+
+		It("advertises all of the instances atomically", func() {
+			var buf strings.Builder
+			err := server.WriteZoneFile(&buf)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			metrics := &serverMetrics{}
+			restored := &UnicastServer{Metrics: metrics}
+			err = restored.ReadZoneFile(strings.NewReader(buf.String()), "")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(metrics.lockWaits).To(Equal([]bool{true}))
+			Expect(restored.Instances()).To(HaveLen(3))
+		})
+
+		It("returns an error if the zone file can not be parsed", func() {
+			err := server.ReadZoneFile(strings.NewReader("example.org. IN SRV invalid"), "")
+			Expect(err).To(MatchError(ContainSubstring("unable to parse zone file")))
+		})
+
+		It("does not advertise any instances if the zone file can not be parsed", func() {
+			zone := `
+Instance\ X._http._tcp.example.org. IN SRV 0 0 80 x.example.org.
+Instance\ Y._http._tcp.example.org. IN SRV invalid
+`
+
+			restored := &UnicastServer{}
+			err := restored.ReadZoneFile(strings.NewReader(zone), "")
+			Expect(err).To(MatchError(ContainSubstring("unable to parse zone file")))
+			Expect(restored.Instances()).To(BeEmpty())
+		})
+	})
+
+	Describe("middleware", func() {
+		var req *dns.Msg
+
+		BeforeEach(func() {
+			req = &dns.Msg{}
+			req.SetQuestion(instanceA.Absolute(), dns.TypeSRV)
+		})
+
+		It("invokes each middleware around every query, outermost first", func() {
+			var calls []string
+
+			record := func(label string) ResponseMiddleware {
+				return func(next ResponseFunc) ResponseFunc {
+					return func(ctx context.Context, client net.Addr, req *dns.Msg) *dns.Msg {
+						calls = append(calls, fmt.Sprintf("%s before %s from %s", label, req.Question[0].Name, client))
+						res := next(ctx, client, req)
+						calls = append(calls, fmt.Sprintf("%s after %s (%d answers)", label, req.Question[0].Name, len(res.Answer)))
+						return res
+					}
+				}
+			}
+
+			server.Middleware = []ResponseMiddleware{
+				record("outer"),
+				record("inner"),
+			}
+
+			w := &responseWriter{}
+			server.ServeDNS(w, req)
+			Expect(w.Messages).To(HaveLen(1))
+
+			Expect(calls).To(Equal([]string{
+				`outer before Instance\ A._http._tcp.example.org. from 127.0.0.1:65000`,
+				`inner before Instance\ A._http._tcp.example.org. from 127.0.0.1:65000`,
+				`inner after Instance\ A._http._tcp.example.org. (1 answers)`,
+				`outer after Instance\ A._http._tcp.example.org. (1 answers)`,
+			}))
+		})
+
+		It("allows middleware to rewrite the response", func() {
+			server.Middleware = []ResponseMiddleware{
+				func(next ResponseFunc) ResponseFunc {
+					return func(ctx context.Context, client net.Addr, req *dns.Msg) *dns.Msg {
+						res := next(ctx, client, req)
+						res.Answer = nil
+						res.Rcode = dns.RcodeRefused
+						return res
+					}
+				},
+			}
+
+			w := &responseWriter{}
+			server.ServeDNS(w, req)
+			Expect(w.Messages).To(HaveLen(1))
+			Expect(w.Messages[0].Rcode).To(Equal(dns.RcodeRefused))
+			Expect(w.Messages[0].Answer).To(BeEmpty())
+		})
+
+		It("does not respond if the middleware does not produce a response", func() {
+			server.Middleware = []ResponseMiddleware{
+				func(next ResponseFunc) ResponseFunc {
+					return func(context.Context, net.Addr, *dns.Msg) *dns.Msg {
+						return nil
+					}
+				},
+			}
+
+			w := &responseWriter{}
+			server.ServeDNS(w, req)
+			Expect(w.Messages).To(BeEmpty())
+
+			_, err := server.Exchange(ctx, req)
+			Expect(err).To(MatchError("middleware did not produce a response to the DNS query"))
+		})
+
+		It("invokes the middleware with a nil client address when using Exchange()", func() {
+			var client net.Addr = &net.UDPAddr{}
+
+			server.Middleware = []ResponseMiddleware{
+				func(next ResponseFunc) ResponseFunc {
+					return func(ctx context.Context, c net.Addr, req *dns.Msg) *dns.Msg {
+						client = c
+						return next(ctx, c, req)
+					}
+				},
+			}
+
+			res, err := server.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Answer).To(HaveLen(1))
+			Expect(client).To(BeNil())
+		})
+
+		It("does not invoke the middleware for refused requests", func() {
+			server.Authorize = func(net.Addr, *dns.Msg) bool {
+				return false
+			}
+
+			server.Middleware = []ResponseMiddleware{
+				func(next ResponseFunc) ResponseFunc {
+					return func(context.Context, net.Addr, *dns.Msg) *dns.Msg {
+						Fail("unexpected call to middleware")
+						return nil
+					}
+				},
+			}
+
+			w := &responseWriter{}
+			server.ServeDNS(w, req)
+			Expect(w.Messages).To(HaveLen(1))
+			Expect(w.Messages[0].Rcode).To(Equal(dns.RcodeRefused))
+		})
+	})
+
+	Describe("views", func() {
+		var (
+			req      *dns.Msg
+			internal *UnicastServer
+		)
+
+		network := func(cidr string) *net.IPNet {
+			_, n, err := net.ParseCIDR(cidr)
+			Expect(err).ShouldNot(HaveOccurred())
+			return n
+		}
+
+		BeforeEach(func() {
+			req = &dns.Msg{}
+			req.SetQuestion(instanceA.Absolute(), dns.TypeSRV)
+
+			inst := instanceA
+			inst.TargetHost = "a.internal"
+
+			internal = &UnicastServer{}
+			Expect(internal.Advertise(inst, WithIPAddress(net.IPv4(10, 0, 0, 1)))).To(Succeed())
+		})
+
+		It("serves the records of the view that contains the client's address", func() {
+			server.Views = []View{
+				{
+					Networks: []*net.IPNet{network("10.0.0.0/8")},
+					Server:   &UnicastServer{},
+				},
+				{
+					Networks: []*net.IPNet{network("127.0.0.0/8")},
+					Server:   internal,
+				},
+			}
+
+			w := &responseWriter{}
+			server.ServeDNS(w, req)
+			Expect(w.Messages).To(HaveLen(1))
+			expectRecords(
+				w.Messages[0],
+				`Instance\ A._http._tcp.example.org.	120	IN	SRV	10 20 12345 a.internal.`,
+			)
+			expectAdditionalRecords(
+				w.Messages[0],
+				`a.internal.	120	IN	A	10.0.0.1`,
+			)
+		})
+
+		It("serves the server's own records to clients that are not within any view", func() {
+			server.Views = []View{
+				{
+					Networks: []*net.IPNet{network("10.0.0.0/8")},
+					Server:   internal,
+				},
+			}
+
+			w := &responseWriter{}
+			server.ServeDNS(w, req)
+			Expect(w.Messages).To(HaveLen(1))
+			expectRecords(
+				w.Messages[0],
+				`Instance\ A._http._tcp.example.org.	120	IN	SRV	10 20 12345 a.example.com.`,
+			)
+		})
+
+		It("serves the server's own records to queries made using Exchange()", func() {
+			server.Views = []View{
+				{
+					Networks: []*net.IPNet{network("0.0.0.0/0")},
+					Server:   internal,
+				},
+			}
+
+			res, err := server.Exchange(ctx, req)
+			Expect(err).ShouldNot(HaveOccurred())
+			expectRecords(
+				res,
+				`Instance\ A._http._tcp.example.org.	120	IN	SRV	10 20 12345 a.example.com.`,
+			)
+		})
+
+		It("responds with NXDOMAIN if the view does not contain the queried name", func() {
+			server.Views = []View{
+				{
+					Networks: []*net.IPNet{network("127.0.0.0/8")},
+					Server:   internal,
+				},
+			}
+
+			req.SetQuestion(instanceB.Absolute(), dns.TypeSRV)
+
+			w := &responseWriter{}
+			server.ServeDNS(w, req)
+			Expect(w.Messages).To(HaveLen(1))
+			Expect(w.Messages[0].Rcode).To(Equal(dns.RcodeNameError))
+		})
+	})
+
+	Describe("func ServeDNS()", func() {
+		It("omits additional records that do not fit in a UDP response", func() {
+			for i := range 8 {
+				inst := instanceA
+				inst.Name = fmt.Sprintf("Instance %d", i)
+				Expect(server.Advertise(inst)).To(Succeed())
+			}
+
+			req := &dns.Msg{}
+			req.SetQuestion(
+				AbsoluteInstanceEnumerationDomain("_http._tcp", "example.org"),
+				dns.TypePTR,
+			)
+
+			w := &responseWriter{}
+			server.ServeDNS(w, req)
+			Expect(w.Messages).To(HaveLen(1))
+
+			res := w.Messages[0]
+			Expect(res.Truncated).To(BeFalse())
+			Expect(res.Answer).To(HaveLen(10))
+			Expect(len(res.Extra)).To(BeNumerically("<", 23))
+			Expect(res.Len()).To(BeNumerically("<=", dns.MinMsgSize))
+		})
+
+		It("writes the response to the response writer", func() {
+			req := &dns.Msg{}
+			req.SetQuestion(
+				AbsoluteInstanceEnumerationDomain("_http._tcp", "example.org"),
+				dns.TypePTR,
+			)
+
+			w := &responseWriter{}
+			server.ServeDNS(w, req)
+
+			Expect(w.Closed).To(BeFalse())
+			Expect(w.Messages).To(HaveLen(1))
+			expectRecords(
+				w.Messages[0],
+				`_http._tcp.example.org.	120	IN	PTR	Instance\ A._http._tcp.example.org.`,
+				`_http._tcp.example.org.	120	IN	PTR	Instance\ B._http._tcp.example.org.`,
+			)
+		})
+
+		It("can be used as a handler within a dns.ServeMux", func() {
+			mux := dns.NewServeMux()
+			mux.Handle("example.org.", server)
+
+			req := &dns.Msg{}
+			req.SetQuestion(
+				AbsoluteServiceInstanceName("Instance A", "_http._tcp", "example.org"),
+				dns.TypeSRV,
+			)
+
+			w := &responseWriter{}
+			mux.ServeDNS(w, req)
+
+			Expect(w.Messages).To(HaveLen(1))
+			expectRecords(
+				w.Messages[0],
+				`Instance\ A._http._tcp.example.org.	120	IN	SRV	10 20 12345 a.example.com.`,
+			)
+		})
+	})
+
+	Describe("parse modes", func() {
+		var req *dns.Msg
+
+		BeforeEach(func() {
+			req = &dns.Msg{}
+			req.SetQuestion("a.example.com.", dns.TypeA)
+			req.Question = append(req.Question, req.Question[0])
+		})
+
+		It("ignores queries without exactly one question when using lenient parsing", func() {
+			w := &responseWriter{}
+			server.ServeDNS(w, req)
+
+			Expect(w.Messages).To(BeEmpty())
+		})
+
+		It("responds with a format error to queries without exactly one question when using strict parsing", func() {
+			server.ParseMode = StrictParsing
+
+			w := &responseWriter{}
+			server.ServeDNS(w, req)
+
+			Expect(w.Messages).To(HaveLen(1))
+			Expect(w.Messages[0].Rcode).To(Equal(dns.RcodeFormatError))
+		})
+	})
+
+	Describe("zones", func() {
+		serve := func(name string, qtype uint16) *dns.Msg {
+			req := &dns.Msg{}
+			req.SetQuestion(name, qtype)
+
+			w := &responseWriter{}
+			server.ServeDNS(w, req)
+			Expect(w.Messages).To(HaveLen(1))
+
+			return w.Messages[0]
+		}
+
+		BeforeEach(func() {
+			server.Zones = []Zone{
+				{
+					SOA: &dns.SOA{
+						Hdr: dns.RR_Header{
+							Name: "example.org.",
+							Ttl:  3600,
+						},
+						Ns:      "ns.example.org.",
+						Mbox:    "hostmaster.example.org.",
+						Serial:  100,
+						Refresh: 7200,
+						Retry:   900,
+						Expire:  86400,
+						Minttl:  60,
+					},
+					NameServers: []string{
+						"ns1.example.org",
+						"ns2.example.org.",
+					},
+				},
+			}
+		})
+
+		It("responds to SOA queries at the zone apex", func() {
+			res := serve("example.org.", dns.TypeSOA)
+			Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+			expectRecords(
+				res,
+				"example.org.	3600	IN	SOA	ns.example.org. hostmaster.example.org. 103 7200 900 86400 60",
+			)
+		})
+
+		It("responds to NS queries at the zone apex", func() {
+			res := serve("example.org.", dns.TypeNS)
+			Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+			expectRecords(
+				res,
+				"example.org.	3600	IN	NS	ns1.example.org.",
+				"example.org.	3600	IN	NS	ns2.example.org.",
+			)
+		})
+
+		It("responds to ANY queries at the zone apex", func() {
+			res := serve("example.org.", dns.TypeANY)
+			Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+			expectRecords(
+				res,
+				"example.org.	3600	IN	SOA	ns.example.org. hostmaster.example.org. 103 7200 900 86400 60",
+				"example.org.	3600	IN	NS	ns1.example.org.",
+				"example.org.	3600	IN	NS	ns2.example.org.",
+			)
+		})
+
+		It("increments the serial number when the advertised records change", func() {
+			server.Remove(instanceA)
+			server.Remove(instanceA) // no change
+
+			res := serve("example.org.", dns.TypeSOA)
+			expectRecords(
+				res,
+				"example.org.	3600	IN	SOA	ns.example.org. hostmaster.example.org. 104 7200 900 86400 60",
+			)
+		})
+
+		It("includes the SOA record in the authority section of NXDOMAIN responses", func() {
+			res := serve("unknown.example.org.", dns.TypeA)
+			Expect(res.Rcode).To(Equal(dns.RcodeNameError))
+			Expect(res.Ns).To(HaveLen(1))
+			Expect(res.Ns[0].String()).To(Equal(
+				"example.org.	60	IN	SOA	ns.example.org. hostmaster.example.org. 103 7200 900 86400 60",
+			))
+		})
+
+		It("includes the SOA record in the authority section of NODATA responses", func() {
+			res := serve("b.example.com.", dns.TypeTXT)
+			Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+			Expect(res.Ns).To(BeEmpty()) // not within the zone
+
+			res = serve(instanceA.Absolute(), dns.TypeA)
+			Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+			Expect(res.Answer).To(BeEmpty())
+			Expect(res.Ns).To(HaveLen(1))
+			Expect(res.Ns[0].Header().Rrtype).To(Equal(dns.TypeSOA))
+		})
+
+		It("responds to queries for other record types at the zone apex with NODATA", func() {
+			res := serve("example.org.", dns.TypeA)
+			Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+			Expect(res.Answer).To(BeEmpty())
+			Expect(res.Ns).To(HaveLen(1))
+		})
+
+		It("does not include an SOA record in responses for names outside of any zone", func() {
+			res := serve("unknown.example.com.", dns.TypeA)
+			Expect(res.Rcode).To(Equal(dns.RcodeNameError))
+			Expect(res.Ns).To(BeEmpty())
+		})
+
+		It("uses the zone with the longest matching apex", func() {
+			server.Zones = append(server.Zones, Zone{
+				SOA: &dns.SOA{
+					Hdr:    dns.RR_Header{Name: "_tcp.example.org.", Ttl: 3600},
+					Ns:     "ns.example.org.",
+					Mbox:   "hostmaster.example.org.",
+					Minttl: 30,
+				},
+			})
+
+			res := serve("unknown._tcp.example.org.", dns.TypeA)
+			Expect(res.Ns).To(HaveLen(1))
+			Expect(res.Ns[0].Header().Name).To(Equal("_tcp.example.org."))
+		})
+
+		It("ignores zones that do not have an SOA record", func() {
+			server.Zones = append(server.Zones, Zone{})
+
+			res := serve("unknown.example.org.", dns.TypeA)
+			Expect(res.Rcode).To(Equal(dns.RcodeNameError))
+			Expect(res.Ns).To(HaveLen(1))
+			Expect(res.Ns[0].Header().Name).To(Equal("example.org."))
+		})
+
+		It("returns an error when run if a zone does not have an SOA record", func() {
+			s := &UnicastServer{
+				Zones: []Zone{{}},
+			}
+
+			err := s.Run(ctx, "udp", "127.0.0.1:0")
+			Expect(err).To(MatchError("zone at index 0 does not have an SOA record"))
+
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			err = s.RunWithPacketConn(ctx, conn)
+			Expect(err).To(MatchError("zone at index 0 does not have an SOA record"))
+
+			_, err = conn.WriteTo([]byte{0}, conn.LocalAddr())
+			Expect(err).To(MatchError(net.ErrClosed))
+		})
+
+		It("returns an error when run if a zone within a view does not have an SOA record", func() {
+			s := &UnicastServer{
+				Views: []View{
+					{
+						Server: &UnicastServer{
+							Zones: []Zone{{}},
+						},
+					},
+				},
+			}
+
+			err := s.Run(ctx, "udp", "127.0.0.1:0")
+			Expect(err).To(MatchError("zone at index 0 does not have an SOA record"))
+		})
+
+		When("using the authoritative rcode policy", func() {
+			BeforeEach(func() {
+				server.RcodePolicy = AuthoritativePolicy
+			})
+
+			It("responds with REFUSED to queries for names outside of any zone", func() {
+				res := serve("unknown.example.com.", dns.TypeA)
+				Expect(res.Rcode).To(Equal(dns.RcodeRefused))
+				Expect(res.Answer).To(BeEmpty())
+				Expect(res.Ns).To(BeEmpty())
+			})
+
+			It("responds normally to queries for names outside of any zone that have records", func() {
+				res := serve("b.example.com.", dns.TypeA)
+				Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+				Expect(res.Answer).To(HaveLen(1))
+			})
+
+			It("responds with NXDOMAIN to queries for names within a zone that do not exist", func() {
+				res := serve("unknown.example.org.", dns.TypeA)
+				Expect(res.Rcode).To(Equal(dns.RcodeNameError))
+				Expect(res.Ns).To(HaveLen(1))
+			})
+
+			It("responds with NODATA to queries for empty non-terminals", func() {
+				res := serve("_tcp.example.org.", dns.TypeA)
+				Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+				Expect(res.Answer).To(BeEmpty())
+				Expect(res.Ns).To(HaveLen(1))
+				Expect(res.Ns[0].Header().Rrtype).To(Equal(dns.TypeSOA))
+
+				res = serve("_sub._http._tcp.example.org.", dns.TypePTR)
+				Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+				Expect(res.Answer).To(BeEmpty())
+			})
+
+			It("responds with NXDOMAIN to queries for names that are no longer empty non-terminals", func() {
+				server.Remove(instanceA)
+				server.Remove(instanceB)
+
+				res := serve("_tcp.example.org.", dns.TypeA)
+				Expect(res.Rcode).To(Equal(dns.RcodeNameError))
+
+				res = serve("_udp.example.org.", dns.TypeA)
+				Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+				Expect(res.Answer).To(BeEmpty())
+			})
+		})
+
+		It("responds with NXDOMAIN to queries for empty non-terminals by default", func() {
+			res := serve("_tcp.example.org.", dns.TypeA)
+			Expect(res.Rcode).To(Equal(dns.RcodeNameError))
+		})
+
+		When("the zone has DNSSEC signing keys", func() {
+			var ksk, zsk *SigningKey
+
+			newSigningKey := func(flags uint16) *SigningKey {
+				k := &dns.DNSKEY{
+					Hdr: dns.RR_Header{
+						Name:   "example.org.",
+						Rrtype: dns.TypeDNSKEY,
+						Class:  dns.ClassINET,
+						Ttl:    3600,
+					},
+					Flags:     flags,
+					Protocol:  3,
+					Algorithm: dns.ECDSAP256SHA256,
+				}
+
+				priv, err := k.Generate(256)
+				Expect(err).ShouldNot(HaveOccurred())
+
+				return &SigningKey{k, priv.(*ecdsa.PrivateKey)}
+			}
+
+			serveSigned := func(name string, qtype uint16) *dns.Msg {
+				req := &dns.Msg{}
+				req.SetQuestion(name, qtype)
+				req.SetEdns0(dns.DefaultMsgSize, true)
+
+				w := &responseWriter{}
+				server.ServeDNS(w, req)
+				Expect(w.Messages).To(HaveLen(1))
+
+				return w.Messages[0]
+			}
+
+			// expectSigned verifies that the records of the given type within
+			// records are signed by key.
+			expectSigned := func(records []dns.RR, rrtype uint16, key *SigningKey) {
+				var (
+					rrset []dns.RR
+					sigs  []*dns.RRSIG
+				)
+
+				for _, rr := range records {
+					if sig, ok := rr.(*dns.RRSIG); ok {
+						if sig.TypeCovered == rrtype {
+							sigs = append(sigs, sig)
+						}
+					} else if rr.Header().Rrtype == rrtype {
+						rrset = append(rrset, rr)
+					}
+				}
+
+				Expect(rrset).NotTo(BeEmpty())
+				Expect(sigs).To(HaveLen(1))
+				Expect(sigs[0].SignerName).To(Equal("example.org."))
+				Expect(sigs[0].KeyTag).To(Equal(key.DNSKEY.KeyTag()))
+				Expect(sigs[0].ValidityPeriod(time.Now())).To(BeTrue())
+				Expect(sigs[0].Verify(key.DNSKEY, rrset)).To(Succeed())
+			}
+
+			findNSEC := func(records []dns.RR) *dns.NSEC {
+				for _, rr := range records {
+					if nsec, ok := rr.(*dns.NSEC); ok {
+						return nsec
+					}
+				}
+
+				Fail("response does not contain an NSEC record")
+				return nil
+			}
+
+			BeforeEach(func() {
+				ksk = newSigningKey(dns.SEP | dns.ZONE)
+				zsk = newSigningKey(dns.ZONE)
+
+				server.Zones[0].KSK = ksk
+				server.Zones[0].ZSK = zsk
+			})
+
+			It("signs answers for clients that set the DO bit", func() {
+				res := serveSigned(instanceA.Absolute(), dns.TypeSRV)
+				Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+				expectSigned(res.Answer, dns.TypeSRV, zsk)
+
+				opt := res.IsEdns0()
+				Expect(opt).NotTo(BeNil())
+				Expect(opt.Do()).To(BeTrue())
+			})
+
+			It("signs the records in the additional section that are within the zone", func() {
+				res := serveSigned("_printer._sub._http._tcp.example.org.", dns.TypePTR)
+				expectSigned(res.Answer, dns.TypePTR, zsk)
+				expectSigned(res.Extra, dns.TypeSRV, zsk)
+				expectSigned(res.Extra, dns.TypeTXT, zsk)
+
+				// The address records of instance B's target host are outside
+				// of the zone.
+				res = serveSigned(instanceB.Absolute(), dns.TypeSRV)
+				Expect(res.Extra).To(HaveLen(3)) // A, AAAA and OPT
+				for _, rr := range res.Extra {
+					Expect(rr.Header().Rrtype).NotTo(Equal(dns.TypeRRSIG))
+				}
+			})
+
+			It("does not sign responses for clients that do not set the DO bit", func() {
+				res := serve(instanceA.Absolute(), dns.TypeSRV)
+				for _, rr := range append(res.Answer, res.Ns...) {
+					Expect(rr.Header().Rrtype).NotTo(Equal(dns.TypeRRSIG))
+				}
+				Expect(res.IsEdns0()).To(BeNil())
+			})
+
+			It("serves DNSKEY records signed by the KSK", func() {
+				res := serveSigned("example.org.", dns.TypeDNSKEY)
+				Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+				Expect(res.Answer).To(ContainElements(
+					WithTransform(dns.RR.String, Equal(ksk.DNSKEY.String())),
+					WithTransform(dns.RR.String, Equal(zsk.DNSKEY.String())),
+				))
+				expectSigned(res.Answer, dns.TypeDNSKEY, ksk)
+			})
+
+			It("signs the SOA and NS records at the zone apex", func() {
+				res := serveSigned("example.org.", dns.TypeANY)
+				expectSigned(res.Answer, dns.TypeSOA, zsk)
+				expectSigned(res.Answer, dns.TypeNS, zsk)
+			})
+
+			It("proves that names do not exist using an NSEC record", func() {
+				res := serveSigned("unknown.example.org.", dns.TypeA)
+				Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+				Expect(res.Answer).To(BeEmpty())
+				expectSigned(res.Ns, dns.TypeSOA, zsk)
+				expectSigned(res.Ns, dns.TypeNSEC, zsk)
+
+				nsec := findNSEC(res.Ns)
+				Expect(nsec.Hdr.Name).To(Equal("unknown.example.org."))
+				Expect(nsec.NextDomain).To(Equal(`\000.unknown.example.org.`))
+				Expect(nsec.TypeBitMap).To(ConsistOf(dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNXNAME))
+			})
+
+			It("proves that records do not exist using an NSEC record", func() {
+				res := serveSigned(instanceA.Absolute(), dns.TypeA)
+				Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+				Expect(res.Answer).To(BeEmpty())
+				expectSigned(res.Ns, dns.TypeNSEC, zsk)
+
+				nsec := findNSEC(res.Ns)
+				Expect(nsec.Hdr.Name).To(Equal(instanceA.Absolute()))
+				Expect(nsec.TypeBitMap).To(ConsistOf(dns.TypeSRV, dns.TypeTXT, dns.TypeRRSIG, dns.TypeNSEC))
+			})
+
+			It("uses the ZSK to sign DNSKEY records if there is no KSK", func() {
+				server.Zones[0].KSK = nil
+
+				res := serveSigned("example.org.", dns.TypeDNSKEY)
+				Expect(res.Answer).To(HaveLen(2))
+				expectSigned(res.Answer, dns.TypeDNSKEY, zsk)
+			})
+		})
+	})
+
+	Describe("access control", func() {
+		serve := func() *dns.Msg {
+			req := &dns.Msg{}
+			req.SetQuestion(instanceA.Absolute(), dns.TypeSRV)
+
+			w := &responseWriter{}
+			server.ServeDNS(w, req)
+			Expect(w.Messages).To(HaveLen(1))
+
+			return w.Messages[0]
+		}
+
+		network := func(cidr string) *net.IPNet {
+			_, n, err := net.ParseCIDR(cidr)
+			Expect(err).ShouldNot(HaveOccurred())
+			return n
+		}
+
+		It("serves clients within the allowed networks", func() {
+			server.AllowedNetworks = []*net.IPNet{
+				network("10.0.0.0/8"),
+				network("127.0.0.0/8"),
+			}
+
+			res := serve()
+			Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+			Expect(res.Answer).To(HaveLen(1))
+		})
+
+		It("refuses clients outside of the allowed networks", func() {
+			server.AllowedNetworks = []*net.IPNet{
+				network("10.0.0.0/8"),
+			}
+
+			res := serve()
+			Expect(res.Rcode).To(Equal(dns.RcodeRefused))
+			Expect(res.Answer).To(BeEmpty())
+		})
+
+		It("refuses clients within the denied networks", func() {
+			server.AllowedNetworks = []*net.IPNet{
+				network("127.0.0.0/8"),
+			}
+			server.DeniedNetworks = []*net.IPNet{
+				network("127.0.0.1/32"),
+			}
+
+			res := serve()
+			Expect(res.Rcode).To(Equal(dns.RcodeRefused))
+		})
+
+		It("serves clients outside of the denied networks", func() {
+			server.DeniedNetworks = []*net.IPNet{
+				network("10.0.0.0/8"),
+			}
+
+			res := serve()
+			Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+		})
+
+		It("refuses clients that are rejected by the authorization function", func() {
+			var client net.Addr
+			server.Authorize = func(addr net.Addr, req *dns.Msg) bool {
+				client = addr
+				Expect(req.Question[0].Name).To(Equal(instanceA.Absolute()))
+				return false
+			}
+
+			res := serve()
+			Expect(res.Rcode).To(Equal(dns.RcodeRefused))
+			Expect(client.String()).To(Equal("127.0.0.1:65000"))
+		})
+
+		It("does not call the authorization function for clients in denied networks", func() {
+			server.DeniedNetworks = []*net.IPNet{
+				network("127.0.0.0/8"),
+			}
+			server.Authorize = func(net.Addr, *dns.Msg) bool {
+				Fail("unexpected call")
+				return true
+			}
+
+			res := serve()
+			Expect(res.Rcode).To(Equal(dns.RcodeRefused))
+		})
+
+		It("refuses DNS updates from unauthorized clients", func() {
+			server.AllowUpdates = true
+			server.Authorize = func(net.Addr, *dns.Msg) bool {
+				return false
+			}
+
+			req := &dns.Msg{}
+			req.SetUpdate("example.org.")
+			req.RemoveName([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: instanceA.Absolute()}}})
+
+			w := &responseWriter{}
+			server.ServeDNS(w, req)
+			Expect(w.Messages).To(HaveLen(1))
+			Expect(w.Messages[0].Rcode).To(Equal(dns.RcodeRefused))
+
+			server.Authorize = nil
+			res := serve()
+			Expect(res.Answer).To(HaveLen(1)) // still advertised
+		})
+	})
+
+	Describe("metrics", func() {
+		var metrics *serverMetrics
+
+		serve := func(name string, qtype uint16) *dns.Msg {
+			req := &dns.Msg{}
+			req.SetQuestion(name, qtype)
+
+			w := &responseWriter{}
+			server.ServeDNS(w, req)
+			Expect(w.Messages).To(HaveLen(1))
+
+			return w.Messages[0]
+		}
+
+		BeforeEach(func() {
+			metrics = &serverMetrics{}
+			server.Metrics = metrics
+		})
+
+		It("reports each query that is served", func() {
+			res := serve(instanceA.Absolute(), dns.TypeSRV)
+			serve("unknown.example.org.", dns.TypeA)
+
+			Expect(metrics.queries).To(Equal([]string{
+				fmt.Sprintf(`Instance\ A._http._tcp.example.org. SRV NOERROR 1 %d`, res.Len()),
+				"unknown.example.org. A NXDOMAIN 0 37",
+			}))
+		})
+
+		It("reports refused queries", func() {
+			server.Authorize = func(net.Addr, *dns.Msg) bool {
+				return false
+			}
+
+			serve(instanceA.Absolute(), dns.TypeSRV)
+
+			Expect(metrics.queries).To(Equal([]string{
+				`Instance\ A._http._tcp.example.org. SRV REFUSED 0 51`,
+			}))
+		})
+
+		It("reports the time spent waiting for the lock", func() {
+			serve(instanceA.Absolute(), dns.TypeSRV)
+			server.Remove(instanceA)
+
+			Expect(metrics.lockWaits).To(Equal([]bool{false, true}))
+		})
+
+		It("reports the number of advertised instances", func() {
+			Expect(server.Advertise(instanceA)).To(Succeed()) // replaces the existing instance
+			server.Remove(instanceB)
+			server.Remove(instanceB) // no change
+
+			Expect(metrics.instances).To(Equal([]int{3, 2}))
+		})
+	})
+
+	Describe("DNS updates", func() {
+		var (
+			key      *ecdsa.PrivateKey
+			instance ServiceInstance
+		)
+
+		// start starts the server and returns its address.
+		start := func() string {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			ctx, server := ctx, server
+			go func() {
+				_ = server.RunWithPacketConn(ctx, conn)
+			}()
+
+			return conn.LocalAddr().String()
+		}
+
+		serve := func(name string, qtype uint16) *dns.Msg {
+			req := &dns.Msg{}
+			req.SetQuestion(name, qtype)
+
+			w := &responseWriter{}
+			server.ServeDNS(w, req)
+			Expect(w.Messages).To(HaveLen(1))
+
+			return w.Messages[0]
+		}
+
+		// sign adds a KEY record for k to the update section of req, and signs
+		// req with k using SIG(0), in the same way as an SRP client.
+		sign := func(req *dns.Msg, k *ecdsa.PrivateKey) {
+			pub, err := k.PublicKey.ECDH()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			rr := &dns.KEY{
+				DNSKEY: dns.DNSKEY{
+					Hdr: dns.RR_Header{
+						Name:   "x.example.org.",
+						Rrtype: dns.TypeKEY,
+						Class:  dns.ClassINET,
+					},
+					Protocol:  3,
+					Algorithm: dns.ECDSAP256SHA256,
+					PublicKey: base64.StdEncoding.EncodeToString(pub.Bytes()[1:]),
+				},
+			}
+			req.Insert([]dns.RR{rr})
+
+			sig := &dns.SIG{
+				RRSIG: dns.RRSIG{
+					Algorithm:  dns.ECDSAP256SHA256,
+					SignerName: rr.Hdr.Name,
+					KeyTag:     rr.KeyTag(),
+					Inception:  uint32(time.Now().Add(-time.Minute).Unix()),
+					Expiration: uint32(time.Now().Add(time.Minute).Unix()),
+				},
+			}
+
+			_, err = sig.Sign(k, req)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			req.Extra = append(req.Extra, sig)
+		}
+
+		BeforeEach(func() {
+			var err error
+			key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			instance = ServiceInstance{
+				ServiceInstanceName: ServiceInstanceName{
+					Name:        "Instance X",
+					ServiceType: "_http._tcp",
+					Domain:      "example.org",
+				},
+				TargetHost: "x.example.org",
+				TargetPort: 12345,
+				Priority:   10,
+				Weight:     20,
+				Attributes: AttributeCollection{
+					NewAttributes().
+						WithPair("<key>", []byte("<instance-x>")),
+				},
+			}
+
+			server.AllowUpdates = true
+		})
+
+		It("advertises instances registered by an SRP client", func() {
+			addr := start()
+
+			client := &SRPClient{Key: key}
+			err := client.Register(
+				ctx,
+				addr,
+				instance,
+				WithIPAddress(net.IPv4(192, 168, 20, 2)),
+				WithServiceSubType("_secure"),
+			)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			expectRecords(
+				serve(instance.Absolute(), dns.TypeANY),
+				`Instance\ X._http._tcp.example.org.	120	IN	SRV	10 20 12345 x.example.org.`,
+				`Instance\ X._http._tcp.example.org.	120	IN	TXT	"<key>=<instance-x>"`,
+			)
+
+			expectRecords(
+				serve("x.example.org.", dns.TypeA),
+				`x.example.org.	120	IN	A	192.168.20.2`,
+			)
+
+			expectRecords(
+				serve(AbsoluteSelectiveInstanceEnumerationDomain("_secure", "_http._tcp", "example.org"), dns.TypePTR),
+				`_secure._sub._http._tcp.example.org.	120	IN	PTR	Instance\ X._http._tcp.example.org.`,
+			)
+		})
+
+		It("removes instances when an SRP client requests a lease of zero", func() {
+			addr := start()
+
+			client := &SRPClient{Key: key}
+			err := client.Register(ctx, addr, instance)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			err = client.Remove(ctx, addr, instance)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			res := serve(instance.Absolute(), dns.TypeSRV)
+			Expect(res.Rcode).To(Equal(dns.RcodeNameError))
+		})
+
+		It("removes instances when their lease expires", func() {
+			addr := start()
+
+			client := &SRPClient{Key: key, Lease: time.Second}
+			err := client.Register(ctx, addr, instance)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			res := serve(instance.Absolute(), dns.TypeSRV)
+			Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+
+			Eventually(func() int {
+				return serve(instance.Absolute(), dns.TypeSRV).Rcode
+			}, 2*time.Second).Should(Equal(dns.RcodeNameError))
+		})
+
+		It("does not remove instances whose lease is renewed", func() {
+			addr := start()
+
+			client := &SRPClient{Key: key, Lease: time.Second}
+			err := client.Register(ctx, addr, instance)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			client.Lease = time.Hour
+			err = client.Register(ctx, addr, instance)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Consistently(func() int {
+				return serve(instance.Absolute(), dns.TypeSRV).Rcode
+			}, 1200*time.Millisecond).Should(Equal(dns.RcodeSuccess))
+		})
+
+		It("removes instances when their names are deleted", func() {
+			addr := start()
+
+			client := &SRPClient{Key: key}
+			err := client.Register(ctx, addr, instance)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			req := &dns.Msg{}
+			req.SetUpdate("example.org.")
+			req.RemoveName([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: instance.Absolute()}}})
+			sign(req, key)
+
+			res, _, err := (&dns.Client{}).ExchangeContext(ctx, req, addr)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+
+			res = serve(instance.Absolute(), dns.TypeSRV)
+			Expect(res.Rcode).To(Equal(dns.RcodeNameError))
+		})
+
+		It("refuses unsigned updates that remove instances that were added by a signed update", func() {
+			addr := start()
+
+			client := &SRPClient{Key: key}
+			err := client.Register(ctx, addr, instance)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			req := &dns.Msg{}
+			req.SetUpdate("example.org.")
+			req.RemoveName([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: instance.Absolute()}}})
+
+			res, _, err := (&dns.Client{}).ExchangeContext(ctx, req, addr)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Rcode).To(Equal(dns.RcodeRefused))
+
+			res = serve(instance.Absolute(), dns.TypeSRV)
+			Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+		})
+
+		It("refuses updates that replace instances that were added by an update signed with a different key", func() {
+			addr := start()
+
+			client := &SRPClient{Key: key}
+			err := client.Register(ctx, addr, instance)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			replacement := instance
+			replacement.TargetPort = 54321
+
+			client = &SRPClient{Key: other}
+			err = client.Register(ctx, addr, replacement)
+			Expect(err).To(MatchError("SRP registrar rejected update: REFUSED"))
+
+			err = client.Remove(ctx, addr, instance)
+			Expect(err).To(MatchError("SRP registrar rejected update: REFUSED"))
+
+			expectRecords(
+				serve(instance.Absolute(), dns.TypeSRV),
+				`Instance\ X._http._tcp.example.org.	120	IN	SRV	10 20 12345 x.example.org.`,
+			)
+		})
+
+		It("refuses updates that replace instances that were added by an unsigned update", func() {
+			addr := start()
+
+			req := &dns.Msg{}
+			req.SetUpdate("example.org.")
+			req.Insert([]dns.RR{NewSRVRecord(instance)})
+
+			res, _, err := (&dns.Client{}).ExchangeContext(ctx, req, addr)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+
+			replacement := instance
+			replacement.TargetPort = 54321
+
+			req = &dns.Msg{}
+			req.SetUpdate("example.org.")
+			req.Insert([]dns.RR{NewSRVRecord(replacement)})
+
+			res, _, err = (&dns.Client{}).ExchangeContext(ctx, req, addr)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Rcode).To(Equal(dns.RcodeRefused))
+
+			client := &SRPClient{Key: key}
+			err = client.Register(ctx, addr, replacement)
+			Expect(err).To(MatchError("SRP registrar rejected update: REFUSED"))
+
+			expectRecords(
+				serve(instance.Absolute(), dns.TypeSRV),
+				`Instance\ X._http._tcp.example.org.	120	IN	SRV	10 20 12345 x.example.org.`,
+			)
+		})
+
+		It("refuses updates with an invalid SIG(0) signature", func() {
+			addr := start()
+
+			req := &dns.Msg{}
+			req.SetUpdate("example.org.")
+			req.Insert([]dns.RR{NewSRVRecord(instance)})
+			sign(req, key)
+
+			// Modify the update after it has been signed.
+			req.Ns[0].(*dns.SRV).Port = 54321
+
+			res, _, err := (&dns.Client{}).ExchangeContext(ctx, req, addr)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Rcode).To(Equal(dns.RcodeRefused))
+
+			res = serve(instance.Absolute(), dns.TypeSRV)
+			Expect(res.Rcode).To(Equal(dns.RcodeNameError))
+		})
+
+		It("allows the client that added an instance to update it after it is restored from a snapshot", func() {
+			addr := start()
+
+			client := &SRPClient{Key: key}
+			err := client.Register(ctx, addr, instance)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			snapshot, err := server.Snapshot()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			server = &UnicastServer{AllowUpdates: true}
+			err = server.Restore(snapshot)
+			Expect(err).ShouldNot(HaveOccurred())
+			addr = start()
+
+			other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			err = (&SRPClient{Key: other}).Remove(ctx, addr, instance)
+			Expect(err).To(MatchError("SRP registrar rejected update: REFUSED"))
+
+			err = client.Remove(ctx, addr, instance)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			res := serve(instance.Absolute(), dns.TypeSRV)
+			Expect(res.Rcode).To(Equal(dns.RcodeNameError))
+		})
+
+		It("removes instances restored from a snapshot when their lease expires", func() {
+			addr := start()
+
+			client := &SRPClient{Key: key, Lease: time.Second}
+			err := client.Register(ctx, addr, instance)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			snapshot, err := server.Snapshot()
+			Expect(err).ShouldNot(HaveOccurred())
+
+			server = &UnicastServer{}
+			err = server.Restore(snapshot)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			res := serve(instance.Absolute(), dns.TypeSRV)
+			Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+
+			Eventually(func() int {
+				return serve(instance.Absolute(), dns.TypeSRV).Rcode
+			}, 2*time.Second).Should(Equal(dns.RcodeNameError))
+		})
+
+		It("refuses unauthenticated updates that remove instances that were not added by an update", func() {
+			addr := start()
+
+			req := &dns.Msg{}
+			req.SetUpdate("example.org.")
+			req.RemoveName([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: instanceA.Absolute()}}})
+
+			res, _, err := (&dns.Client{}).ExchangeContext(ctx, req, addr)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Rcode).To(Equal(dns.RcodeRefused))
+
+			res = serve(instanceA.Absolute(), dns.TypeSRV)
+			Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+		})
+
+		It("refuses unauthenticated updates that replace instances that were not added by an update", func() {
+			addr := start()
+
+			replacement := instanceA
+			replacement.TargetHost = "a.example.org"
+
+			client := &SRPClient{Key: key}
+			err := client.Register(ctx, addr, replacement)
+			Expect(err).To(MatchError("SRP registrar rejected update: REFUSED"))
+
+			expectRecords(
+				serve(instanceA.Absolute(), dns.TypeSRV),
+				`Instance\ A._http._tcp.example.org.	120	IN	SRV	10 20 12345 a.example.com.`,
+			)
+		})
+
+		It("does not apply any of the changes in an update that is refused", func() {
+			addr := start()
+
+			req := &dns.Msg{}
+			req.SetUpdate("example.org.")
+			req.Insert([]dns.RR{NewSRVRecord(instance)})
+			req.RemoveName([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: instanceA.Absolute()}}})
+
+			res, _, err := (&dns.Client{}).ExchangeContext(ctx, req, addr)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Rcode).To(Equal(dns.RcodeRefused))
+
+			res = serve(instance.Absolute(), dns.TypeSRV)
+			Expect(res.Rcode).To(Equal(dns.RcodeNameError))
+		})
+
+		It("rejects updates if updates are not allowed", func() {
+			server.AllowUpdates = false
+			addr := start()
+
+			client := &SRPClient{Key: key}
+			err := client.Register(ctx, addr, instance)
+			Expect(err).To(MatchError("SRP registrar rejected update: NOTIMP"))
+		})
+
+		It("refuses updates for zones that the server is not authoritative for", func() {
+			server.Zones = []Zone{
+				{
+					SOA: &dns.SOA{
+						Hdr: dns.RR_Header{Name: "example.com."},
+					},
+				},
+			}
+			addr := start()
+
+			client := &SRPClient{Key: key}
+			err := client.Register(ctx, addr, instance)
+			Expect(err).To(MatchError("SRP registrar rejected update: NOTAUTH"))
+		})
+
+		It("rejects updates that contain records outside of the zone", func() {
+			addr := start()
+
+			req := &dns.Msg{}
+			req.SetUpdate("example.org.")
+			req.Insert([]dns.RR{NewSRVRecord(instanceC), NewARecord(instanceC, net.IPv4(192, 168, 20, 3))})
+
+			res, _, err := (&dns.Client{}).ExchangeContext(ctx, req, addr)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(res.Rcode).To(Equal(dns.RcodeNotZone))
+		})
+
+		When("TSIG secrets are configured", func() {
+			const (
+				keyName = "update-key."
+				secret  = "c2VjcmV0IGtleSBmb3IgdXBkYXRlcw=="
+			)
+
+			BeforeEach(func() {
+				server.TSIGSecrets = map[string]string{keyName: secret}
+			})
+
+			It("accepts updates that are signed with a configured key", func() {
+				addr := start()
+
+				req := &dns.Msg{}
+				req.SetUpdate("example.org.")
+				req.Insert([]dns.RR{NewSRVRecord(instance)})
+				req.SetTsig(keyName, dns.HmacSHA256, 300, time.Now().Unix())
+
+				client := &dns.Client{TsigSecret: map[string]string{keyName: secret}}
+				res, _, err := client.ExchangeContext(ctx, req, addr)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+
+				res = serve(instance.Absolute(), dns.TypeSRV)
+				Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+			})
+
+			It("accepts signed updates that remove instances that were not added by an update", func() {
+				addr := start()
+
+				req := &dns.Msg{}
+				req.SetUpdate("example.org.")
+				req.RemoveName([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: instanceA.Absolute()}}})
+				req.SetTsig(keyName, dns.HmacSHA256, 300, time.Now().Unix())
+
+				client := &dns.Client{TsigSecret: map[string]string{keyName: secret}}
+				res, _, err := client.ExchangeContext(ctx, req, addr)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(res.Rcode).To(Equal(dns.RcodeSuccess))
+
+				res = serve(instanceA.Absolute(), dns.TypeSRV)
+				Expect(res.Rcode).To(Equal(dns.RcodeNameError))
+			})
+
+			It("refuses updates that are not signed", func() {
+				addr := start()
+
+				client := &SRPClient{Key: key}
+				err := client.Register(ctx, addr, instance)
+				Expect(err).To(MatchError("SRP registrar rejected update: REFUSED"))
+			})
+
+			It("rejects updates that are signed with an unknown key", func() {
+				addr := start()
+
+				req := &dns.Msg{}
+				req.SetUpdate("example.org.")
+				req.Insert([]dns.RR{NewSRVRecord(instance)})
+				req.SetTsig("other-key.", dns.HmacSHA256, 300, time.Now().Unix())
+
+				client := &dns.Client{TsigSecret: map[string]string{"other-key.": secret}}
+				res, _, err := client.ExchangeContext(ctx, req, addr)
+				Expect(res).NotTo(BeNil(), "error: %v", err)
+				Expect(res.Rcode).To(Equal(dns.RcodeNotAuth))
+			})
+		})
+	})
+
+	Describe("logging", func() {
+		var buf *bytes.Buffer
+
+		BeforeEach(func() {
+			buf = &bytes.Buffer{}
+			server.Logger = slog.New(
+				slog.NewTextHandler(
+					buf,
+					&slog.HandlerOptions{Level: slog.LevelDebug},
+				),
+			)
+		})
+
+		It("logs when instances are advertised and removed", func() {
+			Expect(server.Advertise(instanceA)).To(Succeed())
+			server.Remove(instanceA)
+
+			Expect(buf.String()).To(ContainSubstring(`msg="advertising service instance" instance="Instance A" service_type=_http._tcp domain=example.org records=3`))
+			Expect(buf.String()).To(ContainSubstring(`msg="stopped advertising service instance" instance="Instance A" service_type=_http._tcp domain=example.org`))
+		})
+
+		It("does not log when removing an instance that is not advertised", func() {
+			server.Remove(instanceA)
+			buf.Reset()
+			server.Remove(instanceA)
+
+			Expect(buf.String()).To(BeEmpty())
+		})
+
+		It("logs the queries that are served", func() {
+			req := &dns.Msg{}
+			req.SetQuestion("b.example.com.", dns.TypeA)
+
+			server.ServeDNS(&responseWriter{}, req)
+
+			Expect(buf.String()).To(ContainSubstring(`msg="served DNS query" qname=b.example.com. qtype=A client=127.0.0.1:65000 rcode=NOERROR answers=1`))
+		})
+	})
+
+	Describe("func Run()", func() {
+		It("exits when the context is canceled", func() {
+			errors := make(chan error, 1)
+
+			go func() {
+				errors <- server.Run(ctx, "udp", "127.0.0.1:65353")
+			}()
+
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				cancel()
+			}()
+
+			var err error
+			Eventually(errors).Should(Receive(&err))
+			Expect(err).To(Equal(context.Canceled))
+		})
+
+		It("returns an error if the server can not start listening", func() {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:65353")
+			Expect(err).ShouldNot(HaveOccurred())
+			defer conn.Close()
+
+			err = server.Run(ctx, "udp", "127.0.0.1:65353")
+			Expect(err).To(MatchError(ContainSubstring("address already in use")))
+		})
+	})
+
+	Describe("func RunWithListener()", func() {
+		It("serves queries using the listener", func() {
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			errors := make(chan error, 1)
+			go func() {
+				errors <- server.RunWithListener(ctx, lis)
+			}()
+
+			req := &dns.Msg{}
+			req.SetQuestion("b.example.com.", dns.TypeA)
+
+			client := &dns.Client{Net: "tcp"}
+			res, _, err := client.ExchangeContext(ctx, req, lis.Addr().String())
+			Expect(err).ShouldNot(HaveOccurred())
+			expectRecords(
+				res,
+				`b.example.com.	120	IN	A	192.168.20.1`,
+			)
+
+			cancel()
+			Expect(<-errors).To(Equal(context.Canceled))
+		})
+	})
+
+	Describe("func RunWithPacketConn()", func() {
+		It("serves queries using the packet connection", func() {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			errors := make(chan error, 1)
+			go func() {
+				errors <- server.RunWithPacketConn(ctx, conn)
+			}()
+
+			req := &dns.Msg{}
+			req.SetQuestion("b.example.com.", dns.TypeA)
+
+			client := &dns.Client{}
+			res, _, err := client.ExchangeContext(ctx, req, conn.LocalAddr().String())
+			Expect(err).ShouldNot(HaveOccurred())
+			expectRecords(
+				res,
+				`b.example.com.	120	IN	A	192.168.20.1`,
+			)
+
+			cancel()
+			Expect(<-errors).To(Equal(context.Canceled))
+		})
+	})
+
+	Describe("func RunAll()", func() {
+		It("serves queries over both UDP and TCP", func() {
+			errors := make(chan error, 1)
+
+			go func() {
+				errors <- server.RunAll(ctx, "127.0.0.1:65353")
+			}()
+
+			// Fudge-factor to allow the server time to start.
+			time.Sleep(100 * time.Millisecond)
+
+			req := &dns.Msg{}
+			req.SetQuestion("b.example.com.", dns.TypeA)
+
+			for _, network := range []string{"udp", "tcp"} {
+				client := &dns.Client{Net: network}
+				res, _, err := client.ExchangeContext(ctx, req, "127.0.0.1:65353")
+				Expect(err).ShouldNot(HaveOccurred())
+				expectRecords(
+					res,
+					`b.example.com.	120	IN	A	192.168.20.1`,
+				)
+			}
+
+			cancel()
+			Expect(<-errors).To(Equal(context.Canceled))
+		})
+
+		It("returns an error if one of the listeners can not be started", func() {
+			lis, err := net.Listen("tcp", "127.0.0.1:65353")
+			Expect(err).ShouldNot(HaveOccurred())
+			defer lis.Close()
+
+			err = server.RunAll(ctx, "127.0.0.1:65353")
+			Expect(err).To(MatchError(ContainSubstring("address already in use")))
+		})
+	})
+})
+
+func expectRecords(res *dns.Msg, records ...string) {
+	var actual []string
+
+	for _, rr := range res.Answer {
+		actual = append(actual, rr.String())
+	}
+
+	Expect(actual).To(ConsistOf(records))
+}
+
+func expectAdditionalRecords(res *dns.Msg, records ...string) {
+	var actual []string
+
+	for _, rr := range res.Extra {
+		actual = append(actual, rr.String())
+	}
+
+	Expect(actual).To(ConsistOf(records))
+}
+
+// responseWriter is an implementation of dns.ResponseWriter that records the
+// messages written to it.
+type responseWriter struct {
+	Messages []*dns.Msg
+	Closed   bool
+}
+
+func (w *responseWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+}
+
+func (w *responseWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 65000}
+}
+
+func (w *responseWriter) WriteMsg(m *dns.Msg) error {
+	w.Messages = append(w.Messages, m)
+	return nil
+}
+
+func (w *responseWriter) Write([]byte) (int, error) {
+	panic("not implemented")
+}
+
+func (w *responseWriter) Close() error {
+	w.Closed = true
+	return nil
+}
+
+func (w *responseWriter) TsigStatus() error   { return nil }
+func (w *responseWriter) TsigTimersOnly(bool) {}
+func (w *responseWriter) Hijack()             {}
+
+// serverMetrics is an implementation of ServerMetrics that records the metrics
+// reported by a UnicastServer.
+type serverMetrics struct {
+	queries   []string
+	lockWaits []bool
+	instances []int
+}
+
+func (m *serverMetrics) QueryServed(q dns.Question, rcode int, answers, size int) {
+	m.queries = append(
+		m.queries,
+		fmt.Sprintf("%s %s %s %d %d", q.Name, dns.TypeToString[q.Qtype], dns.RcodeToString[rcode], answers, size),
+	)
+}
+
+func (m *serverMetrics) LockWaited(exclusive bool, _ time.Duration) {
+	m.lockWaits = append(m.lockWaits, exclusive)
+}
+
+func (m *serverMetrics) InstancesChanged(count int) {
+	m.instances = append(m.instances, count)
 }
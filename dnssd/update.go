@@ -0,0 +1,552 @@
+package dnssd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// acceptMessage is a dns.MsgAcceptFunc that accepts DNS UPDATE messages if
+// s.AllowUpdates is true, in addition to those accepted by
+// dns.DefaultMsgAcceptFunc.
+func (s *UnicastServer) acceptMessage(h dns.Header) dns.MsgAcceptAction {
+	const (
+		responseBit = 1 << 15
+		opcodeShift = 11
+	)
+
+	isResponse := h.Bits&responseBit != 0
+	opcode := int(h.Bits>>opcodeShift) & 0xF
+
+	if s.AllowUpdates && !isResponse && opcode == dns.OpcodeUpdate {
+		return dns.MsgAccept
+	}
+
+	return dns.DefaultMsgAcceptFunc(h)
+}
+
+// serveUpdate responds to a DNS UPDATE message.
+//
+// See https://www.rfc-editor.org/rfc/rfc2136.
+func (s *UnicastServer) serveUpdate(w dns.ResponseWriter, req *dns.Msg) {
+	res := &dns.Msg{}
+	res.SetReply(req)
+	res.Rcode = s.applyUpdate(w, req)
+
+	// Responses to requests that fail TSIG verification are not signed, as the
+	// client's key may not be known to the server.
+	//
+	// See https://www.rfc-editor.org/rfc/rfc8945#section-5.3.2.
+	if tsig := req.IsTsig(); tsig != nil && len(s.TSIGSecrets) != 0 && w.TsigStatus() == nil {
+		res.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+	}
+
+	attrs := []slog.Attr{
+		slog.String("client", w.RemoteAddr().String()),
+		rcodeAttr(res.Rcode),
+		slog.Int("updates", len(req.Ns)),
+	}
+
+	if len(req.Question) == 1 {
+		attrs = append(attrs, slog.String("zone", req.Question[0].Name))
+	}
+
+	if err := w.WriteMsg(res); err != nil {
+		logAttrs(
+			s.Logger,
+			slog.LevelWarn,
+			"unable to write DNS response",
+			append(attrs, slog.Any("error", err))...,
+		)
+		return
+	}
+
+	logAttrs(s.Logger, slog.LevelDebug, "served DNS update", attrs...)
+}
+
+// applyUpdate applies the changes described by a DNS UPDATE message to the
+// server's service instances. It returns the response code to send to the
+// client.
+func (s *UnicastServer) applyUpdate(w dns.ResponseWriter, req *dns.Msg) int {
+	if !s.AllowUpdates {
+		return dns.RcodeRefused
+	}
+
+	if len(s.TSIGSecrets) != 0 {
+		if req.IsTsig() == nil {
+			return dns.RcodeRefused
+		}
+
+		if err := w.TsigStatus(); err != nil {
+			return dns.RcodeNotAuth
+		}
+	}
+
+	if len(req.Question) != 1 || req.Question[0].Qtype != dns.TypeSOA {
+		return dns.RcodeFormatError
+	}
+
+	zone := req.Question[0].Name
+
+	if len(s.Zones) != 0 {
+		if z, ok := s.findZone(zone); !ok || !equalNames(z.apex(), zone) {
+			return dns.RcodeNotAuth
+		}
+	}
+
+	// Prerequisites are not supported, so rather than ignoring them we refuse
+	// to apply updates that depend on them.
+	if len(req.Answer) != 0 {
+		return dns.RcodeNotImplemented
+	}
+
+	u, err := parseUpdate(zone, req)
+	if err != nil {
+		logAttrs(
+			s.Logger,
+			slog.LevelDebug,
+			"unable to parse DNS update",
+			slog.String("client", w.RemoteAddr().String()),
+			slog.Any("error", err),
+		)
+
+		var notZone notZoneError
+		if errors.As(err, &notZone) {
+			return dns.RcodeNotZone
+		}
+
+		return dns.RcodeFormatError
+	}
+
+	authenticated := len(s.TSIGSecrets) != 0
+
+	// Updates that are not authenticated by TSIG may still be signed using
+	// SIG(0), which determines which of the existing instances they may
+	// change.
+	var key *dns.KEY
+	if !authenticated {
+		key, err = verifySIG0(req)
+		if err != nil {
+			logAttrs(
+				s.Logger,
+				slog.LevelDebug,
+				"refused DNS update with an invalid SIG(0) signature",
+				slog.String("client", w.RemoteAddr().String()),
+				slog.Any("error", err),
+			)
+			return dns.RcodeRefused
+		}
+	}
+
+	s.lock()
+	defer s.m.Unlock()
+
+	// All of the changes are checked before any are applied, such that the
+	// update is applied atomically.
+	//
+	// See https://www.rfc-editor.org/rfc/rfc2136#section-3.7.
+	if !authenticated && !s.updatable(u, key) {
+		logAttrs(
+			s.Logger,
+			slog.LevelDebug,
+			"refused unauthenticated DNS update that changes instances owned by another client",
+			slog.String("client", w.RemoteAddr().String()),
+		)
+		return dns.RcodeRefused
+	}
+
+	records := make([][]dns.RR, len(u.Added))
+
+	for index, i := range u.Added {
+		if u.Expired {
+			continue
+		}
+
+		records[index] = NewRecords(i.ServiceInstance, i.Options...)
+
+		if err := s.checkNotAlias(records[index]); err != nil {
+			logAttrs(
+				s.Logger,
+				slog.LevelDebug,
+				"refused DNS update that adds records to an alias",
+				slog.String("client", w.RemoteAddr().String()),
+				slog.Any("error", err),
+			)
+			return dns.RcodeRefused
+		}
+	}
+
+	for _, n := range u.Removed {
+		s.removeByName(n)
+	}
+
+	for index, i := range u.Added {
+		if u.Expired {
+			s.removeByName(i.ServiceInstanceName)
+			continue
+		}
+
+		s.advertiseRecords(i.ServiceInstanceName, records[index])
+		s.leaseInstance(i.ServiceInstanceName, u.Lease, key)
+	}
+
+	return dns.RcodeSuccess
+}
+
+// updatable returns true if every instance that is changed by u is either not
+// currently advertised, or was added by a previous DNS UPDATE message that was
+// signed with the same SIG(0) key as u. key is nil if u is not signed. It
+// assumes s.m is already locked.
+func (s *UnicastServer) updatable(u update, key *dns.KEY) bool {
+	isUpdatable := func(n ServiceInstanceName) bool {
+		name := n.Absolute()
+		if _, ok := s.instances[name]; !ok {
+			return true
+		}
+		l, ok := s.updated[name]
+		return ok && sameKey(l.Key, key)
+	}
+
+	for _, n := range u.Removed {
+		if !isUpdatable(n) {
+			return false
+		}
+	}
+
+	for _, i := range u.Added {
+		if !isUpdatable(i.ServiceInstanceName) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// updateLease describes an instance that was added by a DNS UPDATE message.
+type updateLease struct {
+	// Key is the KEY record of the SIG(0) key that signed the update, or nil
+	// if the update was not signed.
+	Key *dns.KEY
+
+	// Timer removes the instance when the lease expires. It is nil if the
+	// update did not request a lease.
+	Timer *time.Timer
+
+	// Expires is the time at which the lease expires. It is the zero value if
+	// Timer is nil.
+	Expires time.Time
+}
+
+// leaseInstance records that the instance with the given name was added by a
+// DNS UPDATE message signed with the given SIG(0) key, which may be nil. If
+// lease is positive, the instance is removed after that duration unless it is
+// updated again before then. It assumes s.m is already locked for writing.
+func (s *UnicastServer) leaseInstance(n ServiceInstanceName, lease time.Duration, key *dns.KEY) {
+	name := n.Absolute()
+
+	if s.updated == nil {
+		s.updated = map[string]*updateLease{}
+	}
+
+	l := &updateLease{Key: key}
+	s.updated[name] = l
+
+	if lease <= 0 {
+		return
+	}
+
+	l.Expires = time.Now().Add(lease)
+	l.Timer = time.AfterFunc(lease, func() {
+		s.lock()
+		defer s.m.Unlock()
+
+		// The instance has been removed, replaced or updated since this timer
+		// was started.
+		if s.updated[name] != l {
+			return
+		}
+
+		logAttrs(
+			s.Logger,
+			slog.LevelInfo,
+			"service instance lease expired",
+			append(
+				instanceAttrs(n),
+				slog.Duration("lease", lease),
+			)...,
+		)
+
+		s.removeByName(n)
+	})
+}
+
+// verifySIG0 verifies the SIG(0) signature of a DNS UPDATE message against
+// the KEY record in its update section that identifies the signer. It returns
+// that KEY record, or nil if the message is not signed.
+//
+// The message is verified by packing it again, which reproduces the signed
+// bytes for clients that do not use name compression, such as SRPClient, or
+// that compress names in the same way as this package.
+//
+// See https://www.rfc-editor.org/rfc/rfc2931.
+func verifySIG0(req *dns.Msg) (*dns.KEY, error) {
+	if len(req.Extra) == 0 {
+		return nil, nil
+	}
+
+	sig, ok := req.Extra[len(req.Extra)-1].(*dns.SIG)
+	if !ok {
+		return nil, nil
+	}
+
+	var key *dns.KEY
+	for _, rr := range req.Ns {
+		if k, ok := rr.(*dns.KEY); ok &&
+			k.Hdr.Class == dns.ClassINET &&
+			k.Algorithm == sig.Algorithm &&
+			k.KeyTag() == sig.KeyTag &&
+			equalNames(k.Hdr.Name, sig.SignerName) {
+			key = k
+			break
+		}
+	}
+
+	if key == nil {
+		return nil, fmt.Errorf("the update does not contain the KEY record for %q", sig.SignerName)
+	}
+
+	var err error
+	for _, compress := range []bool{false, true} {
+		m := req.Copy()
+		m.Compress = compress
+
+		var buf []byte
+		buf, err = m.Pack()
+		if err != nil {
+			return nil, err
+		}
+
+		if err = sig.Verify(key, buf); err == nil {
+			return key, nil
+		}
+	}
+
+	return nil, err
+}
+
+// sameKey returns true if a and b are non-nil KEY records that contain the
+// same public key.
+func sameKey(a, b *dns.KEY) bool {
+	return a != nil &&
+		b != nil &&
+		a.Algorithm == b.Algorithm &&
+		a.PublicKey == b.PublicKey
+}
+
+// update is the set of changes to service instances described by a DNS UPDATE
+// message.
+type update struct {
+	// Removed is the set of instances that are removed by the update.
+	Removed []ServiceInstanceName
+
+	// Added is the set of instances that are added (or replaced) by the
+	// update.
+	Added []*updatedInstance
+
+	// Expired is true if the update requests a lease of zero, which is a
+	// request to remove the added instances.
+	//
+	// See https://datatracker.ietf.org/doc/draft-ietf-dnssd-update-lease/.
+	Expired bool
+
+	// Lease is the lease requested for the added instances. It is zero if the
+	// update does not request a lease, in which case the instances are
+	// advertised until they are removed.
+	Lease time.Duration
+}
+
+// updatedInstance is a service instance that is added by a DNS UPDATE message.
+type updatedInstance struct {
+	ServiceInstance
+	Options []AdvertiseOption
+}
+
+// notZoneError indicates that a DNS UPDATE message contains a record that is
+// outside of the zone being updated.
+type notZoneError struct {
+	Name string
+}
+
+func (e notZoneError) Error() string {
+	return fmt.Sprintf("%q is outside of the zone being updated", e.Name)
+}
+
+// parseUpdate parses the update section of a DNS UPDATE message into changes
+// to service instances.
+func parseUpdate(zone string, req *dns.Msg) (update, error) {
+	u, err := parseRecords(zone, req.Ns)
+	if err != nil {
+		return update{}, err
+	}
+
+	if opt := req.IsEdns0(); opt != nil {
+		for _, o := range opt.Option {
+			if ul, ok := o.(*dns.EDNS0_UL); ok {
+				u.Lease = time.Duration(ul.Lease) * time.Second
+				u.Expired = ul.Lease == 0
+			}
+		}
+	}
+
+	return u, nil
+}
+
+// parseRecords parses a set of records within the given zone into changes to
+// service instances.
+//
+// Records with the ANY or NONE class describe deletions, as per RFC 2136.
+// Records that do not describe service instances, such as the KEY records
+// used by SRP, are ignored.
+func parseRecords(zone string, records []dns.RR) (update, error) {
+	var (
+		u         update
+		instances = map[string]*updatedInstance{}
+		addresses = map[string][]net.IP{}
+		subTypes  = map[string][]string{}
+	)
+
+	for _, rr := range records {
+		h := rr.Header()
+
+		if !dns.IsSubDomain(zone, h.Name) {
+			return update{}, notZoneError{h.Name}
+		}
+
+		switch h.Class {
+		case dns.ClassANY, dns.ClassNONE:
+			// Deleting all records at a name, or the SRV records at a name,
+			// removes the instance with that name. Deleting a PTR record
+			// removes the instance that it points to.
+			name := h.Name
+			if ptr, ok := rr.(*dns.PTR); ok {
+				name = ptr.Ptr
+			} else if h.Rrtype != dns.TypeANY && h.Rrtype != dns.TypeSRV {
+				continue
+			}
+
+			if n, ok := parseServiceInstanceName(name); ok {
+				u.Removed = append(u.Removed, n)
+			}
+
+		case dns.ClassINET:
+			switch rr := rr.(type) {
+			case *dns.SRV:
+				n, ok := parseServiceInstanceName(h.Name)
+				if !ok {
+					return update{}, fmt.Errorf("%q is not a service instance name", h.Name)
+				}
+
+				i := &updatedInstance{}
+				i.ServiceInstanceName = n
+				i.TTL = time.Duration(h.Ttl) * time.Second
+				unpackSRV(&i.ServiceInstance, rr)
+
+				if existing, ok := instances[dns.CanonicalName(h.Name)]; ok {
+					i.Attributes = existing.Attributes
+				}
+				instances[dns.CanonicalName(h.Name)] = i
+
+			case *dns.TXT:
+				// TXT records at other names, such as SPF records, do not
+				// describe service instances.
+				if _, ok := parseServiceInstanceName(h.Name); !ok {
+					continue
+				}
+
+				i, ok := instances[dns.CanonicalName(h.Name)]
+				if !ok {
+					i = &updatedInstance{}
+					instances[dns.CanonicalName(h.Name)] = i
+				}
+
+				var attrs Attributes
+				for _, pair := range rr.Txt {
+					a, err := withEscapedTXT(attrs, pair)
+					if err != nil {
+						return update{}, fmt.Errorf("unable to parse TXT record: %w", err)
+					}
+					attrs = a
+				}
+
+				if !attrs.IsEmpty() {
+					i.Attributes = append(i.Attributes, attrs)
+				}
+
+			case *dns.PTR:
+				// The PTR records that enumerate the instance are implied by
+				// its SRV record, but the sub-type PTR records are not.
+				if labels := dns.SplitDomainName(h.Name); len(labels) > 1 && labels[1] == "_sub" {
+					target := dns.CanonicalName(rr.Ptr)
+					subTypes[target] = append(subTypes[target], labels[0])
+				}
+
+			case *dns.A:
+				host := dns.CanonicalName(h.Name)
+				addresses[host] = append(addresses[host], rr.A)
+
+			case *dns.AAAA:
+				host := dns.CanonicalName(h.Name)
+				addresses[host] = append(addresses[host], rr.AAAA)
+			}
+
+		default:
+			return update{}, fmt.Errorf("unsupported class in update section: %s", dns.ClassToString[h.Class])
+		}
+	}
+
+	for name, i := range instances {
+		// TXT records without a corresponding SRV record do not describe a
+		// complete instance.
+		if i.Name == "" {
+			continue
+		}
+
+		for _, ip := range addresses[dns.CanonicalName(dns.Fqdn(i.TargetHost))] {
+			i.Options = append(i.Options, WithIPAddress(ip))
+		}
+
+		for _, subType := range subTypes[name] {
+			i.Options = append(i.Options, WithServiceSubType(subType))
+		}
+
+		u.Added = append(u.Added, i)
+	}
+
+	return u, nil
+}
+
+// parseServiceInstanceName parses a fully-qualified service instance name.
+func parseServiceInstanceName(name string) (ServiceInstanceName, bool) {
+	instance, tail, err := ParseInstance(name)
+	if err != nil || instance == "" {
+		return ServiceInstanceName{}, false
+	}
+
+	// The service type consists of the first two labels of the tail, such as
+	// "_http._tcp".
+	labels := dns.SplitDomainName(tail)
+	if len(labels) < 3 || !strings.HasPrefix(labels[0], "_") || !strings.HasPrefix(labels[1], "_") {
+		return ServiceInstanceName{}, false
+	}
+
+	return ServiceInstanceName{
+		Name:        instance,
+		ServiceType: labels[0] + "." + labels[1],
+		Domain:      strings.Join(labels[2:], "."),
+	}, true
+}
@@ -0,0 +1,44 @@
+package dnssd
+
+import "net"
+
+// View is a set of records that is served only to clients within particular
+// networks, allowing a UnicastServer to provide "split-horizon" DNS.
+//
+// For example, an instance may be advertised with an internal target host by
+// a view that is served to clients on the local network, and with an external
+// target host by the UnicastServer itself.
+type View struct {
+	// Networks is the set of networks that the view is served to.
+	Networks []*net.IPNet
+
+	// Server is the server whose records are served to clients within
+	// Networks.
+	//
+	// It need not be run. Only its records and the fields that affect how
+	// responses are built, such as Zones, RcodePolicy and TTLPolicy, are used.
+	// The fields that affect how requests are received and authorized, such as
+	// AllowUpdates and Middleware, are taken from the server that has the view.
+	Server *UnicastServer
+}
+
+// view returns the server whose records are served to the client at the given
+// address, which is s itself if the client is not within any of s.Views.
+func (s *UnicastServer) view(client net.Addr) *UnicastServer {
+	if len(s.Views) == 0 {
+		return s
+	}
+
+	ip := clientIP(client)
+	if ip == nil {
+		return s
+	}
+
+	for _, v := range s.Views {
+		if v.Server != nil && containsIP(v.Networks, ip) {
+			return v.Server
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,134 @@
+package dnssd
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// Zone describes a DNS zone that a UnicastServer is authoritative for.
+type Zone struct {
+	// SOA is the zone's start-of-authority record. Its owner name is the zone
+	// apex, for example "example.org.".
+	//
+	// The server increments the serial number each time the records that it
+	// advertises change, starting from SOA.Serial. The SOA record itself is
+	// never modified.
+	//
+	// It must not be nil. The UnicastServer.Run() methods return an error if
+	// any zone does not have an SOA record, and such zones are otherwise
+	// ignored.
+	SOA *dns.SOA
+
+	// NameServers is the set of fully-qualified hostnames of the servers that
+	// are authoritative for the zone, such as "ns1.example.org".
+	//
+	// They are served as NS records at the zone apex, which allows the zone to
+	// be delegated to the server from its parent zone. The NS records have the
+	// same TTL as the SOA record.
+	NameServers []string
+
+	// ZSK is the zone-signing key. If it is non-nil, responses about names
+	// within the zone are signed on the fly (RRSIG records are added to each
+	// RRset, and negative responses are proven with NSEC records) for clients
+	// that set the DNSSEC OK (DO) bit in their queries.
+	//
+	// The public keys are served as DNSKEY records at the zone apex. A DS
+	// record that refers to the key-signing key, as produced by
+	// KSK.DNSKEY.ToDS(), must be added to the parent zone in order for the
+	// signatures to be validated.
+	ZSK *SigningKey
+
+	// KSK is the key-signing key, which is used to sign the DNSKEY records at
+	// the zone apex. If it is nil, ZSK is used to sign all records.
+	KSK *SigningKey
+}
+
+// apex returns the fully-qualified name of the zone apex.
+func (z Zone) apex() string {
+	return dns.Fqdn(z.SOA.Hdr.Name)
+}
+
+// findZone returns the zone in s.Zones that contains name. If name is within
+// several zones, the zone with the longest apex is returned.
+func (s *UnicastServer) findZone(name string) (Zone, bool) {
+	var (
+		zone  Zone
+		found bool
+	)
+
+	for _, z := range s.Zones {
+		if z.SOA == nil || !dns.IsSubDomain(z.apex(), name) {
+			continue
+		}
+
+		if !found || dns.CountLabel(z.apex()) > dns.CountLabel(zone.apex()) {
+			zone, found = z, true
+		}
+	}
+
+	return zone, found
+}
+
+// validateZones returns an error if any of the zones in s.Zones, or in the
+// Zones of the servers in s.Views, does not have an SOA record.
+func (s *UnicastServer) validateZones() error {
+	servers := []*UnicastServer{s}
+	for _, v := range s.Views {
+		if v.Server != nil {
+			servers = append(servers, v.Server)
+		}
+	}
+
+	for _, srv := range servers {
+		for i, z := range srv.Zones {
+			if z.SOA == nil {
+				return fmt.Errorf("zone at index %d does not have an SOA record", i)
+			}
+		}
+	}
+
+	return nil
+}
+
+// soaRecord returns the SOA record for z with the serial number adjusted to
+// reflect the changes made to the server's records. It assumes s.m is already
+// locked for reading.
+func (s *UnicastServer) soaRecord(z Zone) *dns.SOA {
+	soa := dns.Copy(z.SOA).(*dns.SOA)
+	soa.Hdr.Name = z.apex()
+	soa.Hdr.Rrtype = dns.TypeSOA
+	soa.Hdr.Class = dns.ClassINET
+	soa.Serial += s.serial
+	return soa
+}
+
+// nsRecords returns the NS records at the apex of z.
+func (z Zone) nsRecords() []dns.RR {
+	var records []dns.RR
+
+	for _, ns := range z.NameServers {
+		records = append(records, &dns.NS{
+			Hdr: dns.RR_Header{
+				Name:   z.apex(),
+				Rrtype: dns.TypeNS,
+				Class:  dns.ClassINET,
+				Ttl:    z.SOA.Hdr.Ttl,
+			},
+			Ns: dns.Fqdn(ns),
+		})
+	}
+
+	return records
+}
+
+// negativeSOARecord returns the SOA record to include in the authority section
+// of a negative response for a name within z. It assumes s.m is already
+// locked for reading.
+//
+// See https://www.rfc-editor.org/rfc/rfc2308#section-3.
+func (s *UnicastServer) negativeSOARecord(z Zone) *dns.SOA {
+	soa := s.soaRecord(z)
+	soa.Hdr.Ttl = min(soa.Hdr.Ttl, soa.Minttl)
+	return soa
+}
@@ -0,0 +1,193 @@
+package dnssd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// WriteZoneFile writes the records served by the server to w in the RFC 1035
+// master file format, such that they can be loaded by other DNS servers, such
+// as BIND or NSD.
+//
+// The SOA, NS and DNSKEY records at the apex of each zone in s.Zones are
+// written first, followed by the records of all advertised service instances,
+// hosts and aliases.
+// All names are fully-qualified. DNSSEC signatures are not included.
+//
+// See https://www.rfc-editor.org/rfc/rfc1035#section-5.
+func (s *UnicastServer) WriteZoneFile(w io.Writer) error {
+	s.rlock()
+
+	var apex []dns.RR
+	for _, z := range s.Zones {
+		if z.SOA == nil {
+			continue
+		}
+		apex = append(apex, s.soaRecord(z))
+		apex = append(apex, z.nsRecords()...)
+		apex = append(apex, z.dnskeyRecords()...)
+	}
+
+	var records []string
+	for _, types := range s.records {
+		for _, recs := range types {
+			for _, rr := range recs {
+				records = append(records, rr.String())
+			}
+		}
+	}
+
+	s.m.RUnlock()
+
+	// Sort the records so that the output is deterministic, and remove the
+	// duplicate address records of instances that share a target host.
+	slices.Sort(records)
+	records = slices.Compact(records)
+
+	buf := bufio.NewWriter(w)
+
+	for _, rr := range apex {
+		if _, err := fmt.Fprintln(buf, rr.String()); err != nil {
+			return err
+		}
+	}
+
+	for _, rr := range records {
+		if _, err := fmt.Fprintln(buf, rr); err != nil {
+			return err
+		}
+	}
+
+	return buf.Flush()
+}
+
+// ReadZoneFile reads records in the RFC 1035 master file format from r and
+// advertises the service instances, hosts and aliases that they describe.
+//
+// origin is the initial origin that is used to qualify relative names, which
+// may be changed by $ORIGIN directives within the file.
+//
+// An instance is advertised for each SRV record with a service instance name.
+// The instance's attributes are taken from the TXT records with the same name.
+// Its IP addresses are taken from the A and AAAA records of its target host,
+// and its sub-types from the sub-type PTR records that refer to it.
+//
+// A and AAAA records at names that are not the target host of any instance
+// are advertised as hosts, as per AdvertiseHost(), and CNAME records are
+// advertised as aliases, as per AdvertiseAlias(). Other records, such as SOA
+// and NS records, are ignored.
+//
+// Instances, hosts and aliases in the file replace any that are already
+// advertised with the same name. The file is parsed in its entirety before anything is
+// advertised, and its contents are advertised atomically, such that queries
+// never observe a partially-read file. If the file can not be parsed, an error
+// is returned and nothing is advertised. The same is true if the name of a
+// CNAME record has other records, either within the file or already
+// advertised by the server.
+func (s *UnicastServer) ReadZoneFile(r io.Reader, origin string) error {
+	p := dns.NewZoneParser(r, dns.Fqdn(origin), "")
+
+	var records []dns.RR
+	for rr, ok := p.Next(); ok; rr, ok = p.Next() {
+		records = append(records, rr)
+	}
+
+	if err := p.Err(); err != nil {
+		return fmt.Errorf("unable to parse zone file: %w", err)
+	}
+
+	u, err := parseRecords(".", records)
+	if err != nil {
+		return fmt.Errorf("unable to parse zone file: %w", err)
+	}
+
+	if len(u.Removed) != 0 {
+		return errors.New("unable to parse zone file: records must have the IN class")
+	}
+
+	// Advertise the instances in order of their names so that the log output
+	// is deterministic.
+	slices.SortFunc(u.Added, func(a, b *updatedInstance) int {
+		return strings.Compare(a.Absolute(), b.Absolute())
+	})
+
+	instanceRecords := make([][]dns.RR, len(u.Added))
+	targets := map[string]struct{}{}
+
+	for index, i := range u.Added {
+		instanceRecords[index] = NewRecords(i.ServiceInstance, i.Options...)
+		targets[dns.CanonicalName(dns.Fqdn(i.TargetHost))] = struct{}{}
+	}
+
+	// The address records of the instances' target hosts have already been
+	// added to the instances by parseRecords(). Any others describe hosts.
+	var (
+		hosts   = map[string][]dns.RR{}
+		aliases []*dns.CNAME
+		owners  = map[string]uint16{}
+	)
+
+	for _, rr := range records {
+		h := rr.Header()
+
+		// A name that has a CNAME record must not have any other records,
+		// including other CNAME records.
+		//
+		// See https://www.rfc-editor.org/rfc/rfc1034#section-3.6.2.
+		owner := dns.CanonicalName(h.Name)
+		if t, ok := owners[owner]; ok && (t == dns.TypeCNAME || h.Rrtype == dns.TypeCNAME) {
+			return fmt.Errorf("unable to parse zone file: %q has a CNAME record and other records", h.Name)
+		}
+		owners[owner] = h.Rrtype
+
+		switch rr := rr.(type) {
+		case *dns.A, *dns.AAAA:
+			if _, ok := targets[owner]; !ok {
+				hosts[h.Name] = append(hosts[h.Name], rr)
+			}
+		case *dns.CNAME:
+			aliases = append(aliases, rr)
+		}
+	}
+
+	s.lock()
+	defer s.m.Unlock()
+
+	for _, rr := range aliases {
+		if err := s.checkAlias(rr.Hdr.Name); err != nil {
+			return err
+		}
+	}
+
+	for _, records := range instanceRecords {
+		if err := s.checkNotAlias(records); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range sortedKeys(hosts) {
+		if err := s.checkNotAlias(hosts[name]); err != nil {
+			return err
+		}
+	}
+
+	for index, i := range u.Added {
+		s.advertiseRecords(i.ServiceInstanceName, instanceRecords[index])
+	}
+
+	for _, name := range sortedKeys(hosts) {
+		s.advertiseHostRecords(name, hosts[name])
+	}
+
+	for _, rr := range aliases {
+		s.advertiseAliasRecord(rr)
+	}
+
+	return nil
+}
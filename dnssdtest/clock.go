@@ -0,0 +1,103 @@
+package dnssdtest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a virtual clock that only advances when Advance() is called.
+//
+// It allows tests to script changes that occur over time, such as instances
+// appearing and disappearing, and to observe those changes deterministically
+// without waiting in real time. The zero value starts at the zero time.
+type Clock struct {
+	m      sync.Mutex
+	now    time.Time
+	seq    int
+	events []clockEvent
+}
+
+// clockEvent is a function that is scheduled to be called at a specific
+// virtual time.
+type clockEvent struct {
+	At   time.Time
+	Seq  int
+	Func func()
+}
+
+// Now returns the current virtual time.
+func (c *Clock) Now() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.now
+}
+
+// AfterFunc schedules fn to be called once the clock has advanced by d.
+//
+// Functions that are scheduled for the same time are called in the order that
+// they were scheduled. If d is non-positive, fn is called by the next call to
+// Advance().
+func (c *Clock) AfterFunc(d time.Duration, fn func()) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.seq++
+	c.events = append(c.events, clockEvent{c.now.Add(d), c.seq, fn})
+}
+
+// Advance moves the clock forward by d, calling each of the functions that are
+// scheduled to occur within that period, in order of their scheduled times.
+//
+// The functions are called synchronously, so their effects are visible to the
+// caller once Advance() returns. Each function observes Now() as its scheduled
+// time. Functions may schedule further functions, which are also called if
+// they fall within the period.
+func (c *Clock) Advance(d time.Duration) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	until := c.now.Add(d)
+
+	for {
+		i := c.next(until)
+		if i == -1 {
+			break
+		}
+
+		e := c.events[i]
+		c.events = append(c.events[:i], c.events[i+1:]...)
+
+		if e.At.After(c.now) {
+			c.now = e.At
+		}
+
+		c.m.Unlock()
+		e.Func()
+		c.m.Lock()
+	}
+
+	if until.After(c.now) {
+		c.now = until
+	}
+}
+
+// next returns the index of the earliest event scheduled at or before until,
+// or -1 if there is no such event. It assumes c.m is already locked.
+func (c *Clock) next(until time.Time) int {
+	index := -1
+
+	for i, e := range c.events {
+		if e.At.After(until) {
+			continue
+		}
+
+		if index == -1 ||
+			e.At.Before(c.events[index].At) ||
+			e.At.Equal(c.events[index].At) && e.Seq < c.events[index].Seq {
+			index = i
+		}
+	}
+
+	return index
+}
@@ -0,0 +1,59 @@
+package dnssdtest_test
+
+import (
+	"time"
+
+	. "github.com/dogmatiq/dissolve/dnssdtest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("type Clock", func() {
+	var clock *Clock
+
+	BeforeEach(func() {
+		clock = &Clock{}
+	})
+
+	Describe("func Advance()", func() {
+		It("calls the scheduled functions in order of their scheduled times", func() {
+			var calls []string
+			record := func(label string) func() {
+				return func() {
+					calls = append(calls, label+" at "+clock.Now().Sub(time.Time{}).String())
+				}
+			}
+
+			clock.AfterFunc(20*time.Second, record("c"))
+			clock.AfterFunc(10*time.Second, record("a"))
+			clock.AfterFunc(10*time.Second, record("b"))
+			clock.AfterFunc(30*time.Second, record("d"))
+
+			clock.Advance(25 * time.Second)
+
+			Expect(calls).To(Equal([]string{
+				"a at 10s",
+				"b at 10s",
+				"c at 20s",
+			}))
+			Expect(clock.Now()).To(Equal(time.Time{}.Add(25 * time.Second)))
+
+			clock.Advance(5 * time.Second)
+			Expect(calls).To(HaveLen(4))
+			Expect(calls[3]).To(Equal("d at 30s"))
+		})
+
+		It("calls functions that are scheduled by other scheduled functions", func() {
+			called := false
+
+			clock.AfterFunc(time.Second, func() {
+				clock.AfterFunc(time.Second, func() {
+					called = true
+				})
+			})
+
+			clock.Advance(2 * time.Second)
+			Expect(called).To(BeTrue())
+		})
+	})
+})
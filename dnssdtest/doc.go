@@ -0,0 +1,3 @@
+// Package dnssdtest provides utilities for testing code that discovers DNS-SD
+// services, without making any network requests.
+package dnssdtest
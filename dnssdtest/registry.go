@@ -0,0 +1,295 @@
+package dnssdtest
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dogmatiq/dissolve/dnssd"
+	"github.com/dogmatiq/dissolve/internal/observer"
+)
+
+// Registry is an in-memory collection of DNS-SD service instances.
+//
+// It implements [dnssd.Enumerator], and provides a LookupInstance() method with
+// the same signature as [dnssd.UnicastResolver.LookupInstance]. The instances
+// are declared programmatically using Advertise() and Remove(), allowing tests
+// to control exactly when instances appear and disappear.
+//
+// Changes made by Advertise() and Remove() are delivered to any active
+// enumerations before those methods return. That is, observer functions for
+// new instances have been started, and the contexts of observer functions for
+// removed instances have been canceled.
+//
+// Changes can also be scheduled to occur in virtual time using
+// AdvertiseAfter() and RemoveAfter(), which requires Clock to be set.
+type Registry struct {
+	// Clock is the virtual clock used to schedule changes made by
+	// AdvertiseAfter() and RemoveAfter().
+	Clock *Clock
+
+	m         sync.Mutex
+	instances map[string]registryEntry
+	watchers  map[watcher]struct{}
+}
+
+var _ dnssd.Enumerator = (*Registry)(nil)
+
+type registryEntry struct {
+	Instance dnssd.ServiceInstance
+	SubTypes []string
+}
+
+// Advertise adds a service instance to the registry, or replaces an existing
+// instance with the same name.
+//
+// subTypes is the set of service sub-types that the instance provides, for
+// use with selective instance enumeration.
+//
+// If an existing instance is replaced with one that is not equal to it, any
+// enumerations that observed the original instance see it go away, and then
+// observe the new instance.
+func (r *Registry) Advertise(i dnssd.ServiceInstance, subTypes ...string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.instances == nil {
+		r.instances = map[string]registryEntry{}
+	}
+
+	r.instances[i.Absolute()] = registryEntry{
+		i,
+		slices.Clone(subTypes),
+	}
+
+	r.notify()
+}
+
+// Remove removes the service instance with the given name from the registry.
+//
+// It is a no-op if no such instance is advertised.
+func (r *Registry) Remove(n dnssd.ServiceInstanceName) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	name := n.Absolute()
+
+	if _, ok := r.instances[name]; ok {
+		delete(r.instances, name)
+		r.notify()
+	}
+}
+
+// AdvertiseAfter schedules a call to Advertise() for when r.Clock has
+// advanced by d.
+//
+// It panics if r.Clock is nil.
+func (r *Registry) AdvertiseAfter(d time.Duration, i dnssd.ServiceInstance, subTypes ...string) {
+	subTypes = slices.Clone(subTypes)
+	r.clock().AfterFunc(d, func() {
+		r.Advertise(i, subTypes...)
+	})
+}
+
+// RemoveAfter schedules a call to Remove() for when r.Clock has advanced by
+// d.
+//
+// It panics if r.Clock is nil.
+func (r *Registry) RemoveAfter(d time.Duration, n dnssd.ServiceInstanceName) {
+	r.clock().AfterFunc(d, func() {
+		r.Remove(n)
+	})
+}
+
+// clock returns r.Clock, or panics if it is nil.
+func (r *Registry) clock() *Clock {
+	if r.Clock == nil {
+		panic("dnssdtest: registry has no clock")
+	}
+	return r.Clock
+}
+
+// LookupInstance looks up the details about a specific service instance.
+//
+// ok is false if the instance is not currently advertised. The options are
+// accepted for compatibility with [dnssd.UnicastResolver.LookupInstance], but
+// have no effect as the registry never blocks.
+func (r *Registry) LookupInstance(
+	ctx context.Context,
+	instance, serviceType, domain string,
+	options ...dnssd.LookupOption,
+) (_ dnssd.ServiceInstance, ok bool, _ error) {
+	if err := ctx.Err(); err != nil {
+		return dnssd.ServiceInstance{}, false, err
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	e, ok := r.instances[dnssd.AbsoluteServiceInstanceName(instance, serviceType, domain)]
+	return e.Instance, ok, nil
+}
+
+// EnumerateServiceTypes finds all of the service types advertised within a
+// single domain.
+//
+// See [dnssd.Enumerator].
+func (r *Registry) EnumerateServiceTypes(
+	ctx context.Context,
+	domain string,
+	obs func(ctx context.Context, serviceType string) error,
+) error {
+	return r.enumerate(
+		ctx,
+		&typedWatcher[string]{
+			Observer: obs,
+			Select: func(e registryEntry) (string, string, bool) {
+				i := e.Instance
+				return i.ServiceType, i.ServiceType, i.Domain == domain
+			},
+			Equal: func(a, b string) bool { return a == b },
+		},
+	)
+}
+
+// EnumerateInstances finds all of the instances of a specific service type
+// that are advertised within a single domain.
+//
+// See [dnssd.Enumerator].
+func (r *Registry) EnumerateInstances(
+	ctx context.Context,
+	serviceType, domain string,
+	obs func(ctx context.Context, i dnssd.ServiceInstance) error,
+) error {
+	return r.enumerate(
+		ctx,
+		&typedWatcher[dnssd.ServiceInstance]{
+			Observer: obs,
+			Select: func(e registryEntry) (string, dnssd.ServiceInstance, bool) {
+				i := e.Instance
+				return i.Absolute(), i, i.ServiceType == serviceType && i.Domain == domain
+			},
+			Equal: dnssd.ServiceInstance.Equal,
+		},
+	)
+}
+
+// EnumerateInstancesSelectively finds all of the instances of a specific
+// service type that are advertised within a single domain where those services
+// have a specific service sub-type.
+//
+// See [dnssd.Enumerator].
+func (r *Registry) EnumerateInstancesSelectively(
+	ctx context.Context,
+	subType, serviceType, domain string,
+	obs func(ctx context.Context, i dnssd.ServiceInstance) error,
+) error {
+	return r.enumerate(
+		ctx,
+		&typedWatcher[dnssd.ServiceInstance]{
+			Observer: obs,
+			Select: func(e registryEntry) (string, dnssd.ServiceInstance, bool) {
+				i := e.Instance
+				return i.Absolute(), i, i.ServiceType == serviceType &&
+					i.Domain == domain &&
+					slices.Contains(e.SubTypes, subType)
+			},
+			Equal: dnssd.ServiceInstance.Equal,
+		},
+	)
+}
+
+// enumerate delivers changes to w until ctx is canceled or one of its
+// observer functions returns an error.
+func (r *Registry) enumerate(ctx context.Context, w watcher) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	r.m.Lock()
+	if r.watchers == nil {
+		r.watchers = map[watcher]struct{}{}
+	}
+	r.watchers[w] = struct{}{}
+	w.sync(ctx, cancel, r.instances)
+	r.m.Unlock()
+
+	<-ctx.Done()
+
+	r.m.Lock()
+	delete(r.watchers, w)
+	r.m.Unlock()
+
+	w.wait()
+
+	return context.Cause(ctx)
+}
+
+// notify delivers the current set of instances to each of the watchers. It
+// assumes r.m is already locked.
+func (r *Registry) notify() {
+	for w := range r.watchers {
+		w.sync(nil, nil, r.instances)
+	}
+}
+
+// watcher is an interface for an active enumeration.
+type watcher interface {
+	// sync starts and stops observer functions so that they match the given
+	// instances.
+	//
+	// ctx and cancel are the context of the enumeration, and the function used
+	// to abort it. They are non-nil only on the first call.
+	sync(
+		ctx context.Context,
+		cancel context.CancelCauseFunc,
+		instances map[string]registryEntry,
+	)
+
+	// wait stops all observer functions and waits for them to return.
+	wait()
+}
+
+// typedWatcher is an implementation of watcher for enumerations that observe
+// values of type T.
+type typedWatcher[T any] struct {
+	// Observer is the observer function to invoke for each new value.
+	Observer func(context.Context, T) error
+
+	// Select returns the key and value that a registry entry contributes to
+	// the enumeration. ok is false if the entry is not included.
+	Select func(registryEntry) (key string, value T, ok bool)
+
+	// Equal returns true if two values are equal.
+	Equal func(T, T) bool
+
+	observers *observer.Set[T]
+}
+
+func (w *typedWatcher[T]) sync(
+	ctx context.Context,
+	cancel context.CancelCauseFunc,
+	instances map[string]registryEntry,
+) {
+	if ctx != nil {
+		w.observers = &observer.Set[T]{
+			Context:  ctx,
+			Cancel:   cancel,
+			Observer: w.Observer,
+			Equal:    w.Equal,
+		}
+	}
+
+	values := map[string]T{}
+	for _, e := range instances {
+		if k, v, ok := w.Select(e); ok {
+			values[k] = v
+		}
+	}
+
+	w.observers.Sync(values)
+}
+
+func (w *typedWatcher[T]) wait() {
+	w.observers.Stop()
+}
@@ -0,0 +1,268 @@
+package dnssdtest_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dogmatiq/dissolve/dnssd"
+	. "github.com/dogmatiq/dissolve/dnssdtest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("type Registry", func() {
+	var (
+		ctx                             context.Context
+		cancel                          context.CancelFunc
+		instanceA, instanceB, instanceC dnssd.ServiceInstance
+		registry                        *Registry
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+
+		instanceA = dnssd.ServiceInstance{
+			ServiceInstanceName: dnssd.ServiceInstanceName{
+				Name:        "Instance A",
+				ServiceType: "_http._tcp",
+				Domain:      "example.org",
+			},
+			TargetHost: "a.example.com",
+			TargetPort: 12345,
+		}
+
+		instanceB = dnssd.ServiceInstance{
+			ServiceInstanceName: dnssd.ServiceInstanceName{
+				Name:        "Instance B",
+				ServiceType: "_http._tcp",
+				Domain:      "example.org",
+			},
+			TargetHost: "b.example.com",
+			TargetPort: 12345,
+		}
+
+		instanceC = dnssd.ServiceInstance{
+			ServiceInstanceName: dnssd.ServiceInstanceName{
+				Name:        "Instance C",
+				ServiceType: "_other._udp",
+				Domain:      "example.org",
+			},
+			TargetHost: "c.example.com",
+			TargetPort: 12345,
+		}
+
+		registry = &Registry{}
+		registry.Advertise(instanceA, "_printer")
+		registry.Advertise(instanceC)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	// observe starts an enumeration in the background and returns channels
+	// that receive the observed values as they appear and disappear.
+	observe := func(
+		enumerate func(func(context.Context, dnssd.ServiceInstance) error) error,
+	) (added, removed <-chan dnssd.ServiceInstance, result <-chan error) {
+		a := make(chan dnssd.ServiceInstance, 10)
+		r := make(chan dnssd.ServiceInstance, 10)
+		e := make(chan error, 1)
+
+		go func() {
+			e <- enumerate(func(ctx context.Context, i dnssd.ServiceInstance) error {
+				a <- i
+				<-ctx.Done()
+				r <- i
+				return nil
+			})
+		}()
+
+		return a, r, e
+	}
+
+	Describe("func EnumerateServiceTypes()", func() {
+		It("observes service types as they appear and disappear", func() {
+			added := make(chan string, 10)
+			removed := make(chan string, 10)
+			result := make(chan error, 1)
+
+			go func() {
+				result <- registry.EnumerateServiceTypes(
+					ctx,
+					"example.org",
+					func(ctx context.Context, serviceType string) error {
+						added <- serviceType
+						<-ctx.Done()
+						removed <- serviceType
+						return nil
+					},
+				)
+			}()
+
+			var types []string
+			for range 2 {
+				var t string
+				Eventually(added).Should(Receive(&t))
+				types = append(types, t)
+			}
+			Expect(types).To(ConsistOf("_http._tcp", "_other._udp"))
+
+			By("adding another instance of an existing service type")
+
+			registry.Advertise(instanceB)
+			Consistently(added, 50*time.Millisecond).ShouldNot(Receive())
+
+			By("removing all instances of a service type")
+
+			registry.Remove(instanceA.ServiceInstanceName)
+			Consistently(removed, 50*time.Millisecond).ShouldNot(Receive())
+
+			registry.Remove(instanceB.ServiceInstanceName)
+			Eventually(removed).Should(Receive(Equal("_http._tcp")))
+
+			cancel()
+			Eventually(result).Should(Receive(Equal(context.Canceled)))
+			Eventually(removed).Should(Receive(Equal("_other._udp")))
+		})
+	})
+
+	Describe("func EnumerateInstances()", func() {
+		It("observes instances as they appear and disappear", func() {
+			added, removed, result := observe(
+				func(obs func(context.Context, dnssd.ServiceInstance) error) error {
+					return registry.EnumerateInstances(ctx, "_http._tcp", "example.org", obs)
+				},
+			)
+
+			Eventually(added).Should(Receive(Equal(instanceA)))
+
+			registry.Advertise(instanceB)
+			Eventually(added).Should(Receive(Equal(instanceB)))
+
+			registry.Remove(instanceA.ServiceInstanceName)
+			Eventually(removed).Should(Receive(Equal(instanceA)))
+
+			cancel()
+			Eventually(result).Should(Receive(Equal(context.Canceled)))
+			Eventually(removed).Should(Receive(Equal(instanceB)))
+		})
+
+		It("observes the instance again when it changes", func() {
+			added, removed, _ := observe(
+				func(obs func(context.Context, dnssd.ServiceInstance) error) error {
+					return registry.EnumerateInstances(ctx, "_http._tcp", "example.org", obs)
+				},
+			)
+
+			Eventually(added).Should(Receive(Equal(instanceA)))
+
+			By("re-advertising the same instance")
+
+			registry.Advertise(instanceA)
+			Consistently(removed, 50*time.Millisecond).ShouldNot(Receive())
+
+			By("advertising a modified instance")
+
+			modified := instanceA
+			modified.TargetPort = 54321
+			registry.Advertise(modified)
+
+			Eventually(removed).Should(Receive(Equal(instanceA)))
+			Eventually(added).Should(Receive(Equal(modified)))
+		})
+
+		It("returns the error produced by the observer", func() {
+			err := registry.EnumerateInstances(
+				ctx,
+				"_http._tcp",
+				"example.org",
+				func(ctx context.Context, i dnssd.ServiceInstance) error {
+					return errors.New("<error>")
+				},
+			)
+			Expect(err).To(MatchError("<error>"))
+		})
+	})
+
+	Describe("func EnumerateInstancesSelectively()", func() {
+		It("observes only instances with the sub-type", func() {
+			added, removed, _ := observe(
+				func(obs func(context.Context, dnssd.ServiceInstance) error) error {
+					return registry.EnumerateInstancesSelectively(ctx, "_printer", "_http._tcp", "example.org", obs)
+				},
+			)
+
+			Eventually(added).Should(Receive(Equal(instanceA)))
+
+			registry.Advertise(instanceB)
+			Consistently(added, 50*time.Millisecond).ShouldNot(Receive())
+
+			registry.Advertise(instanceA)
+			Eventually(removed).Should(Receive(Equal(instanceA)))
+		})
+	})
+
+	Describe("func AdvertiseAfter() and RemoveAfter()", func() {
+		It("applies the changes when the clock advances", func() {
+			registry.Clock = &Clock{}
+
+			registry.AdvertiseAfter(10*time.Second, instanceB)
+			registry.RemoveAfter(20*time.Second, instanceB.ServiceInstanceName)
+
+			added, removed, result := observe(
+				func(obs func(context.Context, dnssd.ServiceInstance) error) error {
+					return registry.EnumerateInstances(ctx, "_http._tcp", "example.org", obs)
+				},
+			)
+
+			Eventually(added).Should(Receive(Equal(instanceA)))
+
+			registry.Clock.Advance(9 * time.Second)
+			Consistently(added, 50*time.Millisecond).ShouldNot(Receive())
+
+			registry.Clock.Advance(1 * time.Second)
+			Eventually(added).Should(Receive(Equal(instanceB)))
+
+			registry.Clock.Advance(10 * time.Second)
+			Eventually(removed).Should(Receive(Equal(instanceB)))
+
+			cancel()
+			Eventually(result).Should(Receive(Equal(context.Canceled)))
+		})
+
+		It("panics if the registry has no clock", func() {
+			Expect(func() {
+				registry.AdvertiseAfter(time.Second, instanceB)
+			}).To(PanicWith("dnssdtest: registry has no clock"))
+		})
+	})
+
+	Describe("func LookupInstance()", func() {
+		It("returns the instance", func() {
+			i, ok, err := registry.LookupInstance(ctx, "Instance A", "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(i).To(Equal(instanceA))
+		})
+
+		It("returns false if no such instance exists", func() {
+			registry.Remove(instanceA.ServiceInstanceName)
+
+			_, ok, err := registry.LookupInstance(ctx, "Instance A", "_http._tcp", "example.org")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ok).To(BeFalse())
+		})
+
+		It("has the same signature as UnicastResolver.LookupInstance()", func() {
+			lookup := (&dnssd.UnicastResolver{}).LookupInstance
+			lookup = registry.LookupInstance
+
+			i, ok, err := lookup(ctx, "Instance A", "_http._tcp", "example.org", dnssd.WithQueryTimeout(time.Second))
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(i).To(Equal(instanceA))
+		})
+	})
+})
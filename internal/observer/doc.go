@@ -0,0 +1,3 @@
+// Package observer manages the observer functions that are invoked for the
+// values discovered by a DNS-SD enumeration.
+package observer
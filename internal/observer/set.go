@@ -0,0 +1,79 @@
+package observer
+
+import (
+	"context"
+	"sync"
+)
+
+// Set manages the observer function calls made during a single enumeration.
+type Set[T any] struct {
+	// Context is the context of the enumeration. The context passed to each
+	// observer function is derived from it.
+	Context context.Context
+
+	// Cancel aborts the enumeration. It is called with the error returned by
+	// an observer function, if any.
+	Cancel context.CancelCauseFunc
+
+	// Observer is the observer function to invoke for each new value.
+	Observer func(context.Context, T) error
+
+	// Equal returns true if two values are equal.
+	Equal func(T, T) bool
+
+	g      sync.WaitGroup
+	active map[string]active[T]
+}
+
+// active is a value that has been passed to an observer function.
+type active[T any] struct {
+	Value  T
+	Cancel context.CancelFunc
+}
+
+// Sync starts and stops observer functions so that they match values, which
+// is keyed by a unique identifier of each value.
+//
+// The context of any value that is no longer present, or that has changed, is
+// canceled. An observer function is started for each new or changed value.
+func (s *Set[T]) Sync(values map[string]T) {
+	if s.active == nil {
+		s.active = map[string]active[T]{}
+	}
+
+	for k, a := range s.active {
+		if v, ok := values[k]; !ok || !s.Equal(a.Value, v) {
+			a.Cancel()
+			delete(s.active, k)
+		}
+	}
+
+	for k, v := range values {
+		if _, ok := s.active[k]; ok {
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(s.Context)
+		s.active[k] = active[T]{v, cancel}
+
+		s.g.Add(1)
+		go func() {
+			defer s.g.Done()
+			defer cancel()
+
+			if err := s.Observer(ctx, v); err != nil {
+				s.Cancel(err)
+			}
+		}()
+	}
+}
+
+// Stop cancels the contexts of all active observer functions and waits for
+// them to return.
+func (s *Set[T]) Stop() {
+	for _, a := range s.active {
+		a.Cancel()
+	}
+
+	s.g.Wait()
+}